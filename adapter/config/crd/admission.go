@@ -0,0 +1,196 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
+	admission "k8s.io/api/admission/v1alpha1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/pilot/model"
+)
+
+// AdmissionOptions configure the admission webhook server
+type AdmissionOptions struct {
+	// Port for the webhook server, the server is disabled if the port is zero
+	Port int
+
+	// CertFile and KeyFile hold the TLS certificate of the server. The API
+	// server requires webhooks to be served over TLS, plain HTTP is only
+	// intended for testing.
+	CertFile string
+	KeyFile  string
+
+	// StrictConflicts rejects the route rules that add a conflict with the
+	// route rules in the store. The conflicts are only logged otherwise.
+	StrictConflicts bool
+}
+
+// AdmissionServer implements a validating admission webhook for the Istio
+// configuration kinds. The webhook rejects the objects that fail the same
+// validation as the one performed by the client, so that invalid
+// configuration submitted directly to the API server (e.g. by kubectl) is not
+// silently dropped by the controller. In the strict mode, the webhook also
+// rejects the route rules that add a conflict with the route rules in the
+// store, since the order of application of conflicting rules depends on their
+// names.
+type AdmissionServer struct {
+	descriptor model.ConfigDescriptor
+	store      model.ConfigStore
+	options    AdmissionOptions
+	server     *http.Server
+	container  *restful.Container
+}
+
+// NewAdmissionServer creates a webhook server validating the configuration
+// types in the descriptor, and the route rule conflicts against the store
+// unless the store is nil
+func NewAdmissionServer(descriptor model.ConfigDescriptor, store model.ConfigStore,
+	options AdmissionOptions) *AdmissionServer {
+	container := restful.NewContainer()
+	out := &AdmissionServer{
+		descriptor: descriptor,
+		store:      store,
+		options:    options,
+		server: &http.Server{
+			Addr:    fmt.Sprintf(":%d", options.Port),
+			Handler: container,
+		},
+		container: container,
+	}
+
+	ws := &restful.WebService{}
+	ws.Consumes(restful.MIME_JSON)
+	ws.Produces(restful.MIME_JSON)
+
+	ws.Route(ws.
+		POST("/admitpilot").
+		To(out.admit).
+		Doc("Validate Istio configuration objects").
+		Reads(admission.AdmissionReview{}).
+		Writes(admission.AdmissionReview{}))
+
+	container.Add(ws)
+
+	return out
+}
+
+// Run starts the server and blocks until the stop signal is received
+func (s *AdmissionServer) Run(stop <-chan struct{}) {
+	glog.Infof("Starting admission webhook at %v", s.server.Addr)
+	go func() {
+		<-stop
+		s.server.Close() // nolint: errcheck
+	}()
+
+	var err error
+	if s.options.CertFile != "" || s.options.KeyFile != "" {
+		err = s.server.ListenAndServeTLS(s.options.CertFile, s.options.KeyFile)
+	} else {
+		err = s.server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		glog.Error(err)
+	}
+}
+
+func (s *AdmissionServer) admit(request *restful.Request, response *restful.Response) {
+	review := admission.AdmissionReview{}
+	if err := request.ReadEntity(&review); err != nil {
+		glog.Warning(err)
+		if err = response.WriteError(http.StatusBadRequest, err); err != nil {
+			glog.Warning(err)
+		}
+		return
+	}
+
+	review.Status = admission.AdmissionReviewStatus{Allowed: true}
+	if err := s.validate(&review.Spec); err != nil {
+		glog.V(2).Infof("rejected %s %s/%s: %v",
+			review.Spec.Kind.Kind, review.Spec.Namespace, review.Spec.Name, err)
+		review.Status = admission.AdmissionReviewStatus{
+			Allowed: false,
+			Result: &meta_v1.Status{
+				Status:  meta_v1.StatusFailure,
+				Reason:  meta_v1.StatusReasonInvalid,
+				Code:    http.StatusUnprocessableEntity,
+				Message: err.Error(),
+			},
+		}
+	}
+
+	if err := response.WriteEntity(review); err != nil {
+		glog.Warning(err)
+	}
+}
+
+// validate checks the object under review, admitting the objects of the kinds
+// outside of the descriptor and all operations other than create and update
+func (s *AdmissionServer) validate(spec *admission.AdmissionReviewSpec) error {
+	if spec.Operation != admission.Create && spec.Operation != admission.Update {
+		return nil
+	}
+	if spec.Kind.Group != model.IstioAPIGroup {
+		return nil
+	}
+
+	var schema model.ProtoSchema
+	found := false
+	for _, desc := range s.descriptor {
+		if kabobCaseToCamelCase(desc.Type) == spec.Kind.Kind {
+			schema, found = desc, true
+			break
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	obj := &IstioKind{}
+	if err := json.Unmarshal(spec.Object.Raw, obj); err != nil {
+		return multierror.Prefix(err, "cannot decode object:")
+	}
+
+	config, err := convertObject(schema, obj)
+	if err != nil {
+		return multierror.Prefix(err, "cannot parse spec:")
+	}
+
+	if err = s.descriptor.ValidateConfig(schema.Type, config.Spec); err != nil {
+		return err
+	}
+
+	if s.store == nil {
+		return nil
+	}
+	if config.Namespace == "" {
+		config.Namespace = spec.Namespace
+	}
+	conflicts := model.NewRouteRuleConflicts(s.store, *config)
+	if s.options.StrictConflicts {
+		return model.ConflictsError(conflicts)
+	}
+	for _, conflict := range conflicts {
+		glog.Warningf("Admitted %s %s/%s with conflicting route rules: %v",
+			spec.Kind.Kind, config.Namespace, config.Name, conflict)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	admission "k8s.io/api/admission/v1alpha1"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+)
+
+func TestAdmissionServer(t *testing.T) {
+	server := NewAdmissionServer(model.IstioConfigTypes, nil, AdmissionOptions{})
+
+	cases := []struct {
+		file    string
+		allowed bool
+		// substrings of the rejection message
+		messages []string
+	}{
+		{file: "testdata/admission-valid.json", allowed: true},
+		{file: "testdata/admission-delete.json", allowed: true},
+		{
+			file: "testdata/admission-invalid.json",
+			messages: []string{
+				"route rule must have a destination service",
+				"rule cannot contain both route and redirect",
+				"Route weights total 60",
+			},
+		},
+		{
+			file:     "testdata/admission-malformed.json",
+			messages: []string{"cannot parse spec:", "circuitBreaker"},
+		},
+	}
+
+	for _, c := range cases {
+		body, err := ioutil.ReadFile(c.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request := httptest.NewRequest(http.MethodPost, "/admitpilot", bytes.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		server.container.ServeHTTP(recorder, request)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("%s: got status %d, want %d", c.file, recorder.Code, http.StatusOK)
+			continue
+		}
+		review := admission.AdmissionReview{}
+		if err = json.Unmarshal(recorder.Body.Bytes(), &review); err != nil {
+			t.Errorf("%s: %v", c.file, err)
+			continue
+		}
+		if review.Status.Allowed != c.allowed {
+			t.Errorf("%s: got allowed %t, want %t (%#v)", c.file, review.Status.Allowed, c.allowed, review.Status)
+			continue
+		}
+		if c.allowed {
+			continue
+		}
+		if review.Status.Result == nil {
+			t.Errorf("%s: missing rejection result", c.file)
+			continue
+		}
+		for _, message := range c.messages {
+			if !strings.Contains(review.Status.Result.Message, message) {
+				t.Errorf("%s: got message %q, want it to contain %q", c.file, review.Status.Result.Message, message)
+			}
+		}
+	}
+}
+
+func TestAdmissionServerBadRequest(t *testing.T) {
+	server := NewAdmissionServer(model.IstioConfigTypes, nil, AdmissionOptions{})
+	request := httptest.NewRequest(http.MethodPost, "/admitpilot", strings.NewReader("{"))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	server.container.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestAdmissionServerConflicts(t *testing.T) {
+	store := memory.Make(model.IstioConfigTypes)
+	body, err := ioutil.ReadFile("testdata/admission-valid.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	admit := func(options AdmissionOptions) admission.AdmissionReview {
+		server := NewAdmissionServer(model.IstioConfigTypes, store, options)
+		request := httptest.NewRequest(http.MethodPost, "/admitpilot", bytes.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		server.container.ServeHTTP(recorder, request)
+		review := admission.AdmissionReview{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &review); err != nil {
+			t.Fatal(err)
+		}
+		return review
+	}
+	strict := AdmissionOptions{StrictConflicts: true}
+	createRule := func(name string, precedence int32) {
+		if _, err := store.Create(model.Config{
+			ConfigMeta: model.ConfigMeta{Type: model.RouteRule.Type, Name: name, Namespace: "default"},
+			Spec: &proxyconfig.RouteRule{
+				Destination: "reviews.default.svc.cluster.local",
+				Precedence:  precedence,
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if review := admit(strict); !review.Status.Allowed {
+		t.Errorf("got rejection %v without conflicts", review.Status.Result)
+	}
+
+	// the rule under review would duplicate the stored rule
+	createRule("reviews-other", 1)
+	if review := admit(AdmissionOptions{}); !review.Status.Allowed {
+		t.Errorf("got rejection %v of a conflict outside of the strict mode", review.Status.Result)
+	}
+	review := admit(strict)
+	if review.Status.Allowed || review.Status.Result == nil ||
+		!strings.Contains(review.Status.Result.Message, "conflicting route rules") {
+		t.Errorf("got status %#v, want a conflict rejection", review.Status)
+	}
+
+	// the update of a rule without conflict adds the conflict
+	createRule("reviews-default", 2)
+	if review = admit(strict); review.Status.Allowed {
+		t.Errorf("got admission of an update adding a conflict")
+	}
+
+	// the update of a rule that already conflicts keeps its conflict
+	if err = store.Delete(model.RouteRule.Type, "reviews-default", "default"); err != nil {
+		t.Fatal(err)
+	}
+	createRule("reviews-default", 1)
+	if review = admit(strict); !review.Status.Allowed {
+		t.Errorf("got rejection %v of an update keeping its conflict", review.Status.Result)
+	}
+}
@@ -121,7 +121,9 @@ func (c *controller) createInformer(
 				c.queue.Push(kube.NewTask(handler.Apply, obj, model.EventAdd))
 			},
 			UpdateFunc: func(old, cur interface{}) {
-				if !reflect.DeepEqual(old, cur) {
+				// skip over the status updates to prevent the status reporter from
+				// triggering a configuration change and a status update in turn
+				if !reflect.DeepEqual(old, cur) && !statusUpdate(old, cur) {
 					c.queue.Push(kube.NewTask(handler.Apply, cur, model.EventUpdate))
 				}
 			},
@@ -133,6 +135,42 @@ func (c *controller) createInformer(
 	return cacheHandler{informer: informer, handler: handler}
 }
 
+// statusUpdate checks if the objects differ only in the status annotation
+func statusUpdate(old, cur interface{}) bool {
+	oldObj, ok := old.(IstioObject)
+	if !ok {
+		return false
+	}
+	curObj, ok := cur.(IstioObject)
+	if !ok {
+		return false
+	}
+	oldMeta, curMeta := oldObj.GetObjectMeta(), curObj.GetObjectMeta()
+	if oldMeta.Annotations[model.StatusAnnotation] == curMeta.Annotations[model.StatusAnnotation] ||
+		!reflect.DeepEqual(oldObj.GetSpec(), curObj.GetSpec()) {
+		return false
+	}
+	oldMeta.Annotations = withoutStatus(oldMeta.Annotations)
+	curMeta.Annotations = withoutStatus(curMeta.Annotations)
+	oldMeta.ResourceVersion = curMeta.ResourceVersion
+	return reflect.DeepEqual(oldMeta, curMeta)
+}
+
+// withoutStatus copies the annotations without the status annotation
+func withoutStatus(annotations map[string]string) map[string]string {
+	var out map[string]string
+	for k, v := range annotations {
+		if k == model.StatusAnnotation {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]string, len(annotations))
+		}
+		out[k] = v
+	}
+	return out
+}
+
 func (c *controller) RegisterEventHandler(typ string, f func(model.Config, model.Event)) {
 	schema, exists := c.ConfigDescriptor().GetByType(typ)
 	if !exists {
@@ -0,0 +1,148 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model"
+)
+
+// StatusReporter periodically records the status of the configuration
+// objects in the status annotation of the custom resources. The status
+// includes the outcome of the validation, which is otherwise only visible in
+// the logs of the controller, and the number of proxies that apply the object.
+//
+// The proxy counts come from the proxies known to a single discovery service,
+// so a single Pilot replica should run the reporter, otherwise the replicas
+// overwrite each other's counts. Every report also updates the objects and
+// notifies all their watchers. The reporter requires the permission to update
+// the custom resources, which the read-only role of Pilot does not grant.
+type StatusReporter struct {
+	client *Client
+	usage  model.ConfigUsage
+	period time.Duration
+}
+
+// NewStatusReporter creates a status reporter for the custom resources of the
+// client using the proxy counts from the usage report
+func NewStatusReporter(client *Client, usage model.ConfigUsage, period time.Duration) *StatusReporter {
+	return &StatusReporter{
+		client: client,
+		usage:  usage,
+		period: period,
+	}
+}
+
+// Run reports the status until a signal is received
+func (r *StatusReporter) Run(stop <-chan struct{}) {
+	wait.Until(r.report, r.period, stop)
+}
+
+type statusEntry struct {
+	schema model.ProtoSchema
+	object IstioObject
+	config *model.Config
+	err    error
+}
+
+func (r *StatusReporter) report() {
+	entries := make([]statusEntry, 0)
+	rules := make(map[string]*proxyconfig.RouteRule)
+	for _, schema := range r.client.descriptor {
+		list := knownTypes[schema.Type].collection.DeepCopyObject().(IstioObjectList)
+		if err := r.client.dynamic.Get().
+			Resource(schema.Plural).
+			Do().Into(list); err != nil {
+			glog.Warningf("failed to list %s: %v", schema.Plural, err)
+			continue
+		}
+
+		for _, item := range list.GetItems() {
+			entry := statusEntry{schema: schema, object: item}
+			entry.config, entry.err = convertObject(schema, item)
+			if entry.err == nil {
+				entry.err = r.client.descriptor.ValidateConfig(schema.Type, entry.config.Spec)
+			}
+			if entry.err == nil && schema.Type == model.RouteRule.Type {
+				rules[entry.config.Key()] = entry.config.Spec.(*proxyconfig.RouteRule)
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	// rules that are never applied since a rule with the same match takes precedence
+	shadowed := make(map[string]string)
+	for _, conflict := range model.RouteRuleConflicts(rules) {
+		if conflict.Duplicate {
+			shadowed[conflict.Keys[1]] = conflict.Keys[0]
+		}
+	}
+
+	counts := r.usage.ProxyCounts()
+	for _, entry := range entries {
+		status := configStatus(entry, counts, shadowed)
+		meta := entry.object.GetObjectMeta()
+		if current, exists := model.ParseConfigStatus(meta.Annotations); exists && *current == status {
+			continue
+		}
+
+		annotations := make(map[string]string, len(meta.Annotations)+1)
+		for k, v := range meta.Annotations {
+			annotations[k] = v
+		}
+		annotations[model.StatusAnnotation] = status.String()
+		meta.Annotations = annotations
+		entry.object.SetObjectMeta(meta)
+
+		// write the object as is since rejected objects fail the client validation
+		if err := r.client.dynamic.Put().
+			Namespace(meta.Namespace).
+			Resource(entry.schema.Plural).
+			Name(meta.Name).
+			Body(entry.object).
+			Do().Error(); err != nil {
+			glog.V(2).Infof("failed to update status of %s %s/%s: %v",
+				entry.schema.Type, meta.Namespace, meta.Name, err)
+		}
+	}
+}
+
+func configStatus(entry statusEntry, counts map[string]int, shadowed map[string]string) model.ConfigStatus {
+	meta := entry.object.GetObjectMeta()
+	if entry.err != nil {
+		return model.ConfigStatus{
+			State:              model.ConfigRejected,
+			Message:            entry.err.Error(),
+			ObservedGeneration: meta.Generation,
+		}
+	}
+
+	key := entry.config.Key()
+	out := model.ConfigStatus{
+		State:              model.ConfigAccepted,
+		ObservedGeneration: meta.Generation,
+		Proxies:            counts[key],
+	}
+	if other, exists := shadowed[key]; exists {
+		out.Message = fmt.Sprintf("shadowed by %s with the same precedence and match condition", other)
+	}
+	return out
+}
@@ -0,0 +1,108 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/mock"
+)
+
+func TestConfigStatus(t *testing.T) {
+	meta := meta_v1.ObjectMeta{Name: "weighted", Namespace: "default", Generation: 3}
+	config := &model.Config{
+		ConfigMeta: model.ConfigMeta{Type: model.RouteRule.Type, Name: "weighted", Namespace: "default"},
+		Spec:       mock.ExampleRouteRule,
+	}
+	counts := map[string]int{config.Key(): 2, "route-rule/default/other": 5}
+
+	cases := []struct {
+		name     string
+		entry    statusEntry
+		shadowed map[string]string
+		want     model.ConfigStatus
+	}{
+		{
+			name:  "accepted",
+			entry: statusEntry{object: &RouteRule{ObjectMeta: meta}, config: config},
+			want:  model.ConfigStatus{State: model.ConfigAccepted, ObservedGeneration: 3, Proxies: 2},
+		},
+		{
+			name:     "shadowed",
+			entry:    statusEntry{object: &RouteRule{ObjectMeta: meta}, config: config},
+			shadowed: map[string]string{config.Key(): "route-rule/default/other"},
+			want: model.ConfigStatus{
+				State:              model.ConfigAccepted,
+				Message:            "shadowed by route-rule/default/other with the same precedence and match condition",
+				ObservedGeneration: 3,
+				Proxies:            2,
+			},
+		},
+		{
+			name:  "rejected",
+			entry: statusEntry{object: &RouteRule{ObjectMeta: meta}, err: errors.New("invalid")},
+			want:  model.ConfigStatus{State: model.ConfigRejected, Message: "invalid", ObservedGeneration: 3},
+		},
+	}
+
+	for _, c := range cases {
+		got := configStatus(c.entry, counts, c.shadowed)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: configStatus() => got %#v, want %#v", c.name, got, c.want)
+		}
+
+		annotations := map[string]string{model.StatusAnnotation: got.String()}
+		if parsed, ok := model.ParseConfigStatus(annotations); !ok || *parsed != got {
+			t.Errorf("%s: ParseConfigStatus() => got %#v, want %#v", c.name, parsed, got)
+		}
+	}
+}
+
+func TestStatusUpdate(t *testing.T) {
+	spec := map[string]interface{}{"destination": "world.default.svc.cluster.local"}
+	meta := meta_v1.ObjectMeta{Name: "weighted", Namespace: "default", ResourceVersion: "1",
+		Annotations: map[string]string{"owner": "ops"}}
+	old := &RouteRule{ObjectMeta: meta, Spec: spec}
+
+	status := meta
+	status.ResourceVersion = "2"
+	status.Annotations = map[string]string{"owner": "ops", model.StatusAnnotation: "{}"}
+
+	annotated := status
+	annotated.Annotations = map[string]string{"owner": "dev", model.StatusAnnotation: "{}"}
+
+	changed := map[string]interface{}{"destination": "hello.default.svc.cluster.local"}
+
+	cases := []struct {
+		name string
+		cur  *RouteRule
+		want bool
+	}{
+		{"status", &RouteRule{ObjectMeta: status, Spec: spec}, true},
+		{"annotations", &RouteRule{ObjectMeta: annotated, Spec: spec}, false},
+		{"spec", &RouteRule{ObjectMeta: status, Spec: changed}, false},
+		{"none", &RouteRule{ObjectMeta: meta, Spec: spec}, false},
+	}
+	for _, c := range cases {
+		if got := statusUpdate(old, c.cur); got != c.want {
+			t.Errorf("%s: statusUpdate() => got %t, want %t", c.name, got, c.want)
+		}
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"io"
 	"os"
 	"strings"
+	"text/tabwriter"
 
 	"github.com/golang/glog"
 	multierror "github.com/hashicorp/go-multierror"
@@ -51,6 +52,9 @@ var (
 	// output format (yaml or short)
 	outputFormat string
 
+	// reject conflicting route rules
+	strict bool
+
 	rootCmd = &cobra.Command{
 		Use:               "istioctl",
 		Short:             "Istio control interface",
@@ -92,19 +96,24 @@ istioctl mixer command documentation.
 			if len(varr) == 0 {
 				return errors.New("nothing to create")
 			}
-			for _, config := range varr {
-				if config.Namespace == "" {
-					config.Namespace = namespace
+			for i := range varr {
+				if varr[i].Namespace == "" {
+					varr[i].Namespace = namespace
 				}
 
-				if config.IstioNamespace == "" {
-					config.IstioNamespace = istioNamespace
+				if varr[i].IstioNamespace == "" {
+					varr[i].IstioNamespace = istioNamespace
 				}
+			}
 
-				configClient, err := newClient()
-				if err != nil {
-					return err
-				}
+			configClient, err := newClient()
+			if err != nil {
+				return err
+			}
+			if err = checkConflicts(configClient, varr); err != nil {
+				return err
+			}
+			for _, config := range varr {
 				rev, err := configClient.Create(config)
 				if err != nil {
 					return err
@@ -134,28 +143,33 @@ istioctl mixer command documentation.
 			if len(varr) == 0 {
 				return errors.New("nothing to replace")
 			}
-			for _, config := range varr {
-				if config.Namespace == "" {
-					config.Namespace = namespace
+			configClient, err := newClient()
+			if err != nil {
+				return err
+			}
+			for i := range varr {
+				if varr[i].Namespace == "" {
+					varr[i].Namespace = namespace
 				}
 
-				if config.IstioNamespace == "" {
-					config.IstioNamespace = istioNamespace
+				if varr[i].IstioNamespace == "" {
+					varr[i].IstioNamespace = istioNamespace
 
 				}
 
-				configClient, err := newClient()
-				if err != nil {
-					return err
-				}
 				// fill up revision
-				if config.ResourceVersion == "" {
-					current, exists := configClient.Get(config.Type, config.Name, config.Namespace)
+				if varr[i].ResourceVersion == "" {
+					current, exists := configClient.Get(varr[i].Type, varr[i].Name, varr[i].Namespace)
 					if exists {
-						config.ResourceVersion = current.ResourceVersion
+						varr[i].ResourceVersion = current.ResourceVersion
 					}
 				}
+			}
+			if err = checkConflicts(configClient, varr); err != nil {
+				return err
+			}
 
+			for _, config := range varr {
 				newRev, err := configClient.Update(config)
 				if err != nil {
 					return err
@@ -180,6 +194,9 @@ istioctl mixer command documentation.
 
 		# Get a specific rule named productpage-default
 		istioctl get route-rule productpage-default
+
+		# List all route rules with their status and the number of proxies applying them
+		istioctl get route-rules -o wide
 		`,
 		RunE: func(c *cobra.Command, args []string) error {
 			configClient, err := newClient()
@@ -219,12 +236,13 @@ istioctl mixer command documentation.
 			var outputters = map[string](func(*crd.Client, []model.Config)){
 				"yaml":  printYamlOutput,
 				"short": printShortOutput,
+				"wide":  printWideOutput,
 			}
 
 			if outputFunc, ok := outputters[outputFormat]; ok {
 				outputFunc(configClient, configs)
 			} else {
-				return fmt.Errorf("unknown output format %v. Types are yaml|short", outputFormat)
+				return fmt.Errorf("unknown output format %v. Types are yaml|short|wide", outputFormat)
 			}
 
 			return nil
@@ -330,8 +348,12 @@ func init() {
 	putCmd.PersistentFlags().AddFlag(postCmd.PersistentFlags().Lookup("file"))
 	deleteCmd.PersistentFlags().AddFlag(postCmd.PersistentFlags().Lookup("file"))
 
+	postCmd.PersistentFlags().BoolVar(&strict, "strict", false,
+		"Reject route rules that duplicate or overlap each other or existing rules with the same precedence")
+	putCmd.PersistentFlags().AddFlag(postCmd.PersistentFlags().Lookup("strict"))
+
 	getCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "short",
-		"Output format. One of:yaml|short")
+		"Output format. One of:yaml|short|wide")
 
 	cmd.AddFlags(rootCmd)
 
@@ -404,6 +426,21 @@ func readInputs() ([]model.Config, error) {
 	return varr, nil
 }
 
+// checkConflicts warns about the input route rules that have the same
+// precedence as each other or as the route rules in the cluster, and
+// overlapping match conditions. The order of application of such rules depends
+// on their names, so the strict mode rejects the whole input instead.
+func checkConflicts(configClient model.ConfigStore, configs []model.Config) error {
+	conflicts := model.RouteRuleConflictsWith(configClient, configs...)
+	if strict {
+		return model.ConflictsError(conflicts)
+	}
+	for _, conflict := range conflicts {
+		fmt.Fprintf(os.Stderr, "Warning: conflicting route rules: %v\n", conflict)
+	}
+	return nil
+}
+
 // Print a simple list of names
 func printShortOutput(_ *crd.Client, configList []model.Config) {
 	for _, c := range configList {
@@ -411,6 +448,24 @@ func printShortOutput(_ *crd.Client, configList []model.Config) {
 	}
 }
 
+// Print a table with the status reported by Pilot
+func printWideOutput(_ *crd.Client, configList []model.Config) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tNAMESPACE\tSTATE\tGENERATION\tPROXIES\tMESSAGE")
+	for _, c := range configList {
+		status, exists := model.ParseConfigStatus(c.Annotations)
+		if !exists {
+			fmt.Fprintf(w, "%s\t%s\tUnknown\t-\t-\t\n", c.Name, c.Namespace)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\n", c.Name, c.Namespace,
+			status.State, status.ObservedGeneration, status.Proxies, status.Message)
+	}
+	if err := w.Flush(); err != nil {
+		glog.Warning(err)
+	}
+}
+
 // Print as YAML
 func printYamlOutput(configClient *crd.Client, configList []model.Config) {
 	for _, c := range configList {
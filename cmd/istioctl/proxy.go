@@ -0,0 +1,153 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/rest"
+
+	"istio.io/pilot/adapter/config/crd"
+	"istio.io/pilot/proxy/envoy"
+)
+
+var (
+	istioPilotService string
+
+	proxyStatusCmd = &cobra.Command{
+		Use:   "proxy-status [<service node or IP address>]",
+		Short: "Retrieve the configuration state of the proxies",
+		Long: `
+Lists the proxies that recently requested configuration from Pilot, with the
+time since the last request for each discovery type. A proxy is STALE if the
+configuration it last received differs from the current configuration.
+`,
+		Example: `
+# Retrieve the state of all proxies
+istioctl proxy-status
+
+# Retrieve the state of the proxy with the IP address
+istioctl proxy-status 10.1.1.0
+`,
+		RunE: func(c *cobra.Command, args []string) error {
+			if len(args) > 1 {
+				return fmt.Errorf("too many arguments: %v", args)
+			}
+
+			restconfig, err := crd.CreateRESTConfig(kubeconfig)
+			if err != nil {
+				return err
+			}
+			client, err := rest.RESTClientFor(restconfig)
+			if err != nil {
+				return err
+			}
+			requester := &k8sRESTRequester{
+				client:    client,
+				namespace: istioNamespace,
+				service:   istioPilotService,
+			}
+
+			status, body, err := requester.Request(http.MethodGet, "v1/debug/proxies", nil)
+			if err != nil {
+				return err
+			}
+			if status != http.StatusOK {
+				return fmt.Errorf("failed to retrieve the proxies with status %v: %s", status, string(body))
+			}
+
+			var proxies []envoy.ProxyStatus
+			if err = json.Unmarshal(body, &proxies); err != nil {
+				return fmt.Errorf("failed processing response: %v", err)
+			}
+			if len(args) == 1 {
+				filtered := make([]envoy.ProxyStatus, 0, 1)
+				for _, p := range proxies {
+					if p.ServiceNode == args[0] || p.IPAddress == args[0] {
+						filtered = append(filtered, p)
+					}
+				}
+				proxies = filtered
+			}
+
+			printProxyStatus(os.Stdout, proxies, time.Now())
+			return nil
+		},
+	}
+)
+
+// printProxyStatus writes a table of the proxies with a column per discovery
+// type. The route configurations are summarized in a single column.
+func printProxyStatus(writer io.Writer, proxies []envoy.ProxyStatus, now time.Time) {
+	w := tabwriter.NewWriter(writer, 0, 8, 1, ' ', 0)
+	fmt.Fprintln(w, "PROXY\tTYPE\tCDS\tLDS\tRDS\tSTATUS")
+	for _, p := range proxies {
+		columns := map[string][]*envoy.ProxyFetch{}
+		resources := make([]string, 0, len(p.Fetches))
+		for resource := range p.Fetches {
+			resources = append(resources, resource)
+		}
+		sort.Strings(resources)
+		for _, resource := range resources {
+			typ := strings.Split(resource, "/")[0]
+			columns[typ] = append(columns[typ], p.Fetches[resource])
+		}
+
+		state := "SYNCED"
+		if p.Stale {
+			state = "STALE"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", p.ServiceNode, p.Type,
+			fetchSummary(columns["cds"], now), fetchSummary(columns["lds"], now), fetchSummary(columns["rds"], now),
+			state)
+	}
+	w.Flush() // nolint: errcheck
+}
+
+// fetchSummary shows the time since the latest fetch, marked if any is stale
+func fetchSummary(fetches []*envoy.ProxyFetch, now time.Time) string {
+	if len(fetches) == 0 {
+		return "-"
+	}
+	latest := time.Time{}
+	stale := false
+	for _, fetch := range fetches {
+		if fetch.LastFetch.After(latest) {
+			latest = fetch.LastFetch
+		}
+		stale = stale || fetch.Stale
+	}
+	out := (now.Sub(latest) / time.Second * time.Second).String()
+	if stale {
+		out += " (stale)"
+	}
+	return out
+}
+
+func init() {
+	proxyStatusCmd.PersistentFlags().StringVar(&istioPilotService, "pilotService", "istio-pilot:8080",
+		"Name and port of the Istio Pilot discovery service in the Istio system namespace")
+
+	rootCmd.AddCommand(proxyStatusCmd)
+}
@@ -16,10 +16,13 @@ package main
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
 
+	"istio.io/pilot/cmd"
 	"istio.io/pilot/platform/kube"
 )
 
@@ -51,20 +54,82 @@ var (
 			if err != nil {
 				return err
 			}
-			return kube.RegisterEndpoint(client, namespace, svcName, ip, portsList, labels, annotations)
+			if err = kube.RegisterEndpoint(client, namespace, svcName, ip, portsList, labels, annotations); err != nil {
+				return err
+			}
+			if ttl <= 0 {
+				return nil
+			}
+			return heartbeat(client, svcName, ip, portsList)
+		},
+	}
+
+	deregisterCmd = &cobra.Command{
+		Use:   "deregister <svcname> <ip>",
+		Short: "Deregisters a service instance (e.g. VM) leaving the mesh",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(c *cobra.Command, args []string) error {
+			svcName := args[0]
+			ip := args[1]
+			glog.Infof("Deregistering for service '%s' ip '%s'", svcName, ip)
+			_, client, err := kube.CreateInterface(kubeconfig)
+			if err != nil {
+				return err
+			}
+			return kube.DeregisterEndpoint(client, namespace, svcName, ip)
 		},
 	}
+
 	labels      []string
 	annotations []string
 	svcAcctAnn  string
+	ttl         time.Duration
 )
 
+// heartbeat refreshes the registration before it expires until a signal is
+// received. The registration is restored if it was reaped in the meantime.
+func heartbeat(client kubernetes.Interface, svcName, ip string, portsList []kube.NamedPort) error {
+	if err := kube.HeartbeatEndpoint(client, namespace, svcName, ip, ttl); err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	go cmd.WaitSignal(stop)
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	glog.Infof("Refreshing the registration every %v until interrupted", ttl/3)
+	for {
+		select {
+		case <-stop:
+			glog.Infof("Stopped refreshing, the registration expires after %v", ttl)
+			return nil
+		case <-ticker.C:
+			err := kube.HeartbeatEndpoint(client, namespace, svcName, ip, ttl)
+			if err == nil {
+				continue
+			}
+			glog.Warningf("Heartbeat failed (%v), registering again", err)
+			if err = kube.RegisterEndpoint(client, namespace, svcName, ip, portsList, labels, annotations); err != nil {
+				glog.Warningf("Registration failed: %v", err)
+				continue
+			}
+			if err = kube.HeartbeatEndpoint(client, namespace, svcName, ip, ttl); err != nil {
+				glog.Warningf("Heartbeat failed: %v", err)
+			}
+		}
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(registerCmd)
+	rootCmd.AddCommand(deregisterCmd)
 	registerCmd.PersistentFlags().StringSliceVarP(&labels, "labels", "l",
 		nil, "List of labels to apply if creating a service/endpoint; e.g. -l env=prod,vers=2")
 	registerCmd.PersistentFlags().StringSliceVarP(&annotations, "annotations", "a",
 		nil, "List of string annotations to apply if creating a service/endpoint; e.g. -a foo=bar,test,x=y")
 	registerCmd.PersistentFlags().StringVarP(&svcAcctAnn, "serviceaccount", "s",
 		"default", "Service account to link to the service")
+	registerCmd.PersistentFlags().DurationVar(&ttl, "ttl", 0,
+		"Time to live of the registration, refreshed by heartbeats until interrupted. "+
+			"0 registers without expiration")
 }
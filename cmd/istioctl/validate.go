@@ -0,0 +1,157 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/platform/kube"
+	"istio.io/pilot/proxy"
+)
+
+var (
+	live         bool
+	domainSuffix string
+
+	validateCmd = &cobra.Command{
+		Use:   "validate",
+		Short: "Validate policies and rules",
+		Long: `
+Validate checks that the configuration objects are well-formed. With the
+--live option, the configuration in the cluster, combined with the objects
+from the input file if one is provided, is also checked against the running
+services: references to missing services and ports, weighted routes that
+select no instances, and rules sharing a precedence are reported.
+`,
+		Example: `
+		# Validate the rules in a file without contacting the cluster
+		istioctl validate -f example-routing.yaml
+
+		# Check the rules in the cluster against the running services
+		istioctl validate --live
+
+		# Check the outcome of applying the rules in a file to the cluster
+		istioctl validate --live -f example-routing.yaml
+		`,
+		RunE: func(c *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				c.Println(c.UsageString())
+				return fmt.Errorf("validate takes no arguments")
+			}
+
+			// reading the inputs checks each object in isolation
+			var varr []model.Config
+			if file != "" || !live {
+				var err error
+				if varr, err = readInputs(); err != nil {
+					return err
+				}
+			}
+
+			if !live {
+				fmt.Printf("Validated %d configs\n", len(varr))
+				return nil
+			}
+
+			diagnostics, err := analyzeLive(varr)
+			if err != nil {
+				return err
+			}
+			for _, d := range diagnostics {
+				fmt.Println(d)
+			}
+			if diagnostics.HasErrors() {
+				return errors.New("configuration has errors")
+			}
+			return nil
+		},
+	}
+)
+
+// analyzeLive checks the cluster configuration with the overlay objects applied
+// against the services in the cluster
+func analyzeLive(overlay []model.Config) (model.Diagnostics, error) {
+	configClient, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+
+	store := memory.Make(model.IstioConfigTypes)
+	for _, typ := range configClient.ConfigDescriptor().Types() {
+		configs, err := configClient.List(typ, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, config := range configs {
+			if _, err = store.Create(config); err != nil {
+				return nil, multierror.Prefix(err, fmt.Sprintf("cannot load %s: ", config.Key()))
+			}
+		}
+	}
+
+	for _, config := range overlay {
+		if config.Namespace == "" {
+			config.Namespace = namespace
+		}
+		if current, exists := store.Get(config.Type, config.Name, config.Namespace); exists {
+			config.ResourceVersion = current.ResourceVersion
+			_, err = store.Update(config)
+		} else {
+			_, err = store.Create(config)
+		}
+		if err != nil {
+			return nil, multierror.Prefix(err, fmt.Sprintf("cannot apply %s: ", config.Key()))
+		}
+	}
+
+	_, client, err := kube.CreateInterface(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	mesh := proxy.DefaultMeshConfig()
+	controller := kube.NewController(client, &mesh, kube.ControllerOptions{
+		ResyncPeriod: time.Minute,
+		DomainSuffix: domainSuffix,
+	})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go controller.Run(stop)
+	if err = wait.Poll(500*time.Millisecond, 60*time.Second, func() (bool, error) {
+		return controller.HasSynced(), nil
+	}); err != nil {
+		return nil, multierror.Prefix(err, "failed to synchronize with the service registry.")
+	}
+
+	return model.AnalyzeConfig(model.MakeIstioStore(store), controller), nil
+}
+
+func init() {
+	validateCmd.PersistentFlags().AddFlag(postCmd.PersistentFlags().Lookup("file"))
+	validateCmd.PersistentFlags().BoolVar(&live, "live", false,
+		"Check the configuration against the services running in the cluster")
+	validateCmd.PersistentFlags().StringVar(&domainSuffix, "domain", "cluster.local",
+		"DNS domain suffix of the services in the cluster")
+
+	rootCmd.AddCommand(validateCmd)
+}
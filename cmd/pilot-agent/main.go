@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/spf13/cobra"
@@ -32,6 +33,11 @@ import (
 var (
 	configpath      string
 	meshconfig      string
+	healthPort      int
+	drainDelay      time.Duration
+	backend         string
+	binaryPath      string
+	binaryArgs      []string
 	role            proxy.Node
 	serviceregistry platform.ServiceRegistry
 
@@ -91,17 +97,50 @@ var (
 
 			}
 
-			watcher, err := envoy.NewWatcher(mesh, role, configpath)
+			var proxyBackend envoy.Backend
+			switch backend {
+			case envoy.BinaryBackendName:
+				if proxyBackend, err = envoy.NewBinaryBackend(binaryPath, binaryArgs); err != nil {
+					return err
+				}
+			case envoy.DryRunBackendName:
+				proxyBackend = envoy.NewDryRunBackend()
+			default:
+				return fmt.Errorf("unknown proxy backend %q", backend)
+			}
+
+			// the health server reports the exhausted retry budget, so that
+			// the proxy is restarted by the liveness probe instead of exiting,
+			// and the draining state, so that the proxy endpoint is removed
+			// before the drain
+			endpointRemovalDelay := time.Duration(0)
+			if healthPort > 0 {
+				endpointRemovalDelay = drainDelay
+			}
+			watcher, err := envoy.NewWatcher(mesh, role, configpath, proxyBackend, healthPort == 0,
+				endpointRemovalDelay)
 			if err != nil {
 				return err
 			}
 			ctx, cancel := context.WithCancel(context.Background())
-			go watcher.Run(ctx)
+			done := make(chan struct{})
+			go func() {
+				watcher.Run(ctx)
+				close(done)
+			}()
+
+			// keep serving the health checks while the proxy drains
+			healthCtx, healthCancel := context.WithCancel(context.Background())
+			defer healthCancel()
+			if healthPort > 0 {
+				go envoy.NewHealthServer(healthPort, mesh, watcher, backend == envoy.DryRunBackendName).Run(healthCtx)
+			}
 
 			stop := make(chan struct{})
 			cmd.WaitSignal(stop)
 			<-stop
 			cancel()
+			<-done
 			return nil
 		},
 	}
@@ -122,6 +161,18 @@ func init() {
 		"Proxy unique ID. If not provided uses ${POD_NAME}.${POD_NAMESPACE} from environment variables")
 	proxyCmd.PersistentFlags().StringVar(&role.Domain, "domain", "",
 		"DNS domain suffix. If not provided uses ${POD_NAMESPACE}.svc.cluster.local")
+	proxyCmd.PersistentFlags().StringVar(&backend, "backend", envoy.BinaryBackendName,
+		fmt.Sprintf("Proxy backend, options are {%s, %s}", envoy.BinaryBackendName, envoy.DryRunBackendName))
+	proxyCmd.PersistentFlags().StringVar(&binaryPath, "binaryPath", envoy.BinaryPath,
+		"Path to the proxy binary for the binary backend")
+	proxyCmd.PersistentFlags().StringSliceVar(&binaryArgs, "binaryArgs", nil,
+		"Argument templates for the binary backend (e.g. \"-c,{{.ConfigFile}},--restart-epoch,{{.Epoch}}\"). "+
+			"Defaults to the Envoy arguments")
+	proxyCmd.PersistentFlags().IntVar(&healthPort, "healthPort", 15020,
+		"Health, readiness and status port of the proxy agent (/healthz, /ready, /status); 0 disables the server")
+	proxyCmd.PersistentFlags().DurationVar(&drainDelay, "drainDelay", 5*time.Second,
+		"Delay between failing the readiness checks and draining the proxy on termination, "+
+			"for the removal of the proxy endpoint")
 
 	cmd.AddFlags(rootCmd)
 
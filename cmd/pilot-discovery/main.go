@@ -15,14 +15,17 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/golang/glog"
 	multierror "github.com/hashicorp/go-multierror"
 	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
 
 	proxyconfig "istio.io/api/proxy/v1/config"
 	"istio.io/pilot/adapter/config/aggregate"
@@ -32,6 +35,7 @@ import (
 	"istio.io/pilot/model"
 	"istio.io/pilot/platform"
 	"istio.io/pilot/platform/consul"
+	"istio.io/pilot/platform/eureka"
 	"istio.io/pilot/platform/kube"
 	"istio.io/pilot/proxy"
 	"istio.io/pilot/proxy/envoy"
@@ -40,8 +44,28 @@ import (
 
 // ConsulArgs store the args related to Consul configuration
 type ConsulArgs struct {
-	config    string
-	serverURL string
+	config      string
+	serverURL   string
+	datacenters []string
+}
+
+// EurekaArgs store the args related to Eureka configuration
+type EurekaArgs struct {
+	serverURLs []string
+}
+
+// ClusterArgs store the args related to multi-cluster Kubernetes configuration
+type ClusterArgs struct {
+	name        string
+	kubeconfigs []string
+	secret      string
+}
+
+// serviceRegistry is a service catalog with notifications
+type serviceRegistry interface {
+	model.Controller
+	model.ServiceDiscovery
+	model.ServiceAccounts
 }
 
 type args struct {
@@ -54,6 +78,19 @@ type args struct {
 
 	serviceregistry platform.ServiceRegistry
 	consulargs      ConsulArgs
+	eurekaargs      EurekaArgs
+	clusterargs     ClusterArgs
+
+	// admission webhook is disabled by default
+	admissionOptions crd.AdmissionOptions
+
+	// period for reporting the status of the configuration objects
+	statusPeriod time.Duration
+
+	// period for removing the expired VM endpoint addresses
+	vmReaperPeriod time.Duration
+
+	isolation model.Isolation
 }
 
 var (
@@ -82,10 +119,12 @@ var (
 			var serviceController model.Controller
 			var configController model.ConfigStoreCache
 			environment := proxy.Environment{
-				Mesh: mesh,
+				Mesh:      mesh,
+				Isolation: flags.isolation,
 			}
 
 			stop := make(chan struct{})
+			var configClient *crd.Client
 
 			// Set up values for input to discovery service in different platforms
 			if flags.serviceregistry == platform.KubernetesRegistry || flags.serviceregistry == "" {
@@ -102,7 +141,7 @@ var (
 				glog.V(2).Infof("version %s", version.Line())
 				glog.V(2).Infof("flags %s", spew.Sdump(flags))
 
-				configClient, err := crd.NewClient(flags.kubeconfig, model.ConfigDescriptor{
+				configClient, err = crd.NewClient(flags.kubeconfig, model.ConfigDescriptor{
 					model.RouteRule,
 					model.DestinationPolicy,
 				})
@@ -114,7 +153,21 @@ var (
 					return multierror.Prefix(err, "failed to register custom resources.")
 				}
 
-				kubeController := kube.NewController(client, mesh, flags.controllerOptions)
+				var kubeController serviceRegistry
+				clusters, err := remoteClusters(client, flags.controllerOptions.Namespace)
+				if err != nil {
+					return multierror.Prefix(err, "failed to connect to the remote clusters.")
+				}
+				var localController *kube.Controller
+				if len(clusters) > 0 {
+					clusters = append([]kube.Cluster{{Name: flags.clusterargs.name, Client: client}}, clusters...)
+					multiClusterController := kube.NewMultiClusterController(clusters, mesh, flags.controllerOptions)
+					localController, _ = multiClusterController.ClusterController(flags.clusterargs.name)
+					kubeController = multiClusterController
+				} else {
+					localController = kube.NewController(client, mesh, flags.controllerOptions)
+					kubeController = localController
+				}
 				if mesh.IngressControllerMode == proxyconfig.ProxyMeshConfig_OFF {
 					configController = crd.NewController(configClient, flags.controllerOptions)
 				} else {
@@ -129,22 +182,29 @@ var (
 
 				environment.ServiceDiscovery = kubeController
 				environment.ServiceAccounts = kubeController
-				environment.IstioConfigStore = model.MakeIstioStore(configController)
+				environment.IstioConfigStore = model.MakeIsolatedIstioStore(configController, flags.isolation, kubeController)
 				environment.SecretRegistry = kube.MakeSecretRegistry(client)
 				serviceController = kubeController
 				ingressSyncer := ingress.NewStatusSyncer(mesh, client, flags.controllerOptions)
 
 				go ingressSyncer.Run(stop)
+
+				if flags.vmReaperPeriod > 0 {
+					// reap the namespaces watched by the local controller, including
+					// the ones followed by the namespace selector as they change
+					reaper := kube.NewEndpointReaper(client)
+					go reaper.Run(localController.WatchedNamespaces, flags.vmReaperPeriod, stop)
+				}
 			} else if flags.serviceregistry == platform.ConsulRegistry {
-				glog.V(2).Infof("Consul url: %v", flags.consulargs.serverURL)
+				glog.V(2).Infof("Consul url: %v, datacenters: %v", flags.consulargs.serverURL, flags.consulargs.datacenters)
 
 				consulController, err := consul.NewController(
-					flags.consulargs.serverURL, "dc1", 2*time.Second)
+					flags.consulargs.serverURL, flags.consulargs.datacenters, 2*time.Second)
 				if err != nil {
 					return fmt.Errorf("failed to create Consul controller: %v", err)
 				}
 
-				configClient, err := crd.NewClient(flags.kubeconfig, model.ConfigDescriptor{
+				configClient, err = crd.NewClient(flags.kubeconfig, model.ConfigDescriptor{
 					model.RouteRule,
 					model.DestinationPolicy,
 				})
@@ -160,8 +220,36 @@ var (
 
 				environment.ServiceDiscovery = consulController
 				environment.ServiceAccounts = consulController
-				environment.IstioConfigStore = model.MakeIstioStore(configController)
+				environment.IstioConfigStore = model.MakeIsolatedIstioStore(configController, flags.isolation, consulController)
 				serviceController = consulController
+			} else if flags.serviceregistry == platform.EurekaRegistry {
+				glog.V(2).Infof("Eureka urls: %v", flags.eurekaargs.serverURLs)
+				if len(flags.eurekaargs.serverURLs) == 0 {
+					return errors.New("missing Eureka server URLs")
+				}
+
+				eurekaClient := eureka.NewClient(flags.eurekaargs.serverURLs...)
+				eurekaDiscovery := eureka.NewServiceDiscovery(eurekaClient)
+
+				var err error
+				configClient, err = crd.NewClient(flags.kubeconfig, model.ConfigDescriptor{
+					model.RouteRule,
+					model.DestinationPolicy,
+				})
+				if err != nil {
+					return multierror.Prefix(err, "failed to open a config client.")
+				}
+
+				if err = configClient.RegisterResources(); err != nil {
+					return multierror.Prefix(err, "failed to register custom resources.")
+				}
+
+				configController = crd.NewController(configClient, flags.controllerOptions)
+
+				environment.ServiceDiscovery = eurekaDiscovery
+				environment.ServiceAccounts = eurekaDiscovery
+				environment.IstioConfigStore = model.MakeIsolatedIstioStore(configController, flags.isolation, eurekaDiscovery)
+				serviceController = eureka.NewController(eurekaClient, 2*time.Second)
 			}
 
 			// Set up discovery service
@@ -174,6 +262,18 @@ var (
 				return fmt.Errorf("failed to create discovery service: %v", err)
 			}
 
+			if flags.admissionOptions.Port != 0 {
+				admission := crd.NewAdmissionServer(model.ConfigDescriptor{
+					model.RouteRule,
+					model.DestinationPolicy,
+				}, configController, flags.admissionOptions)
+				go admission.Run(stop)
+			}
+
+			if configClient != nil && flags.statusPeriod > 0 {
+				go crd.NewStatusReporter(configClient, discovery, flags.statusPeriod).Run(stop)
+			}
+
 			go serviceController.Run(stop)
 			go configController.Run(stop)
 			go discovery.Run()
@@ -183,19 +283,59 @@ var (
 	}
 )
 
+// remoteClusters creates the clients of the remote clusters from the kubeconfig
+// files and the kubeconfigs in the cluster secret. The clusters whose
+// kubeconfig fails to load are skipped with a warning, so that discovery
+// starts with the other clusters.
+func remoteClusters(client kubernetes.Interface, namespace string) ([]kube.Cluster, error) {
+	var clusters []kube.Cluster
+	for _, spec := range flags.clusterargs.kubeconfigs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("cluster kubeconfig %q is not of the form name=path", spec)
+		}
+		_, clusterClient, err := kube.CreateInterface(parts[1])
+		if err != nil {
+			glog.Warningf("Skipping cluster %q: %v", parts[0], err)
+			continue
+		}
+		clusters = append(clusters, kube.Cluster{Name: parts[0], Client: clusterClient})
+	}
+
+	if flags.clusterargs.secret != "" {
+		secretClusters, err := kube.CreateClusters(client, flags.clusterargs.secret, namespace)
+		if err != nil {
+			return nil, err
+		}
+		clusters = append(clusters, secretClusters...)
+	}
+	return clusters, nil
+}
+
 func init() {
 	discoveryCmd.PersistentFlags().StringVar((*string)(&flags.serviceregistry), "serviceregistry",
 		string(platform.KubernetesRegistry),
-		fmt.Sprintf("Select the platform for service registry, options are {%s, %s}",
-			string(platform.KubernetesRegistry), string(platform.ConsulRegistry)))
+		fmt.Sprintf("Select the platform for service registry, options are {%s, %s, %s}",
+			string(platform.KubernetesRegistry), string(platform.ConsulRegistry), string(platform.EurekaRegistry)))
 	discoveryCmd.PersistentFlags().StringVar(&flags.kubeconfig, "kubeconfig", "",
 		"Use a Kubernetes configuration file instead of in-cluster configuration")
 	discoveryCmd.PersistentFlags().StringVar(&flags.meshconfig, "meshConfig", "/etc/istio/config/mesh",
 		fmt.Sprintf("File name for Istio mesh configuration"))
+	discoveryCmd.PersistentFlags().StringVar(&flags.clusterargs.name, "clusterName", "local",
+		"Name of the cluster of the Kubernetes configuration, tagging its instances with remote clusters")
+	discoveryCmd.PersistentFlags().StringSliceVar(&flags.clusterargs.kubeconfigs, "clusterKubeconfigs", nil,
+		"Comma separated list of name=path Kubernetes configuration files of remote clusters to watch for services")
+	discoveryCmd.PersistentFlags().StringVar(&flags.clusterargs.secret, "clusterSecret", "",
+		"Secret in the controller namespace with the Kubernetes configurations of remote clusters keyed by name")
 	discoveryCmd.PersistentFlags().StringVarP(&flags.controllerOptions.Namespace, "namespace", "n", "",
 		"Select a namespace for the controller loop. If not set, uses ${POD_NAMESPACE} environment variable")
 	discoveryCmd.PersistentFlags().StringVarP(&flags.controllerOptions.AppNamespace, "app namespace", "a", "",
-		"Restrict the applications namespace that controller manages, do n")
+		"Restrict the applications namespace that controller manages. If not set, controller watches all namespaces")
+	discoveryCmd.PersistentFlags().StringSliceVar(&flags.controllerOptions.WatchedNamespaces, "watchedNamespaces", nil,
+		"Comma separated list of the namespaces to watch for services, overriding the app namespace")
+	discoveryCmd.PersistentFlags().StringVar(&flags.controllerOptions.NamespaceSelector, "namespaceSelector", "",
+		"Label selector of the namespaces to watch for services, followed as namespaces change. "+
+			"Requires permissions to list and watch namespaces")
 	discoveryCmd.PersistentFlags().DurationVar(&flags.controllerOptions.ResyncPeriod, "resync", time.Second,
 		"Controller resync interval")
 	discoveryCmd.PersistentFlags().StringVar(&flags.controllerOptions.DomainSuffix, "domain", "cluster.local",
@@ -211,6 +351,29 @@ func init() {
 		"Consul Config file for discovery")
 	discoveryCmd.PersistentFlags().StringVar(&flags.consulargs.serverURL, "consulserverURL", "",
 		"URL for the consul server")
+	discoveryCmd.PersistentFlags().StringSliceVar(&flags.consulargs.datacenters, "consulDatacenters", []string{"dc1"},
+		"Comma separated list of the consul datacenters to watch for services")
+	discoveryCmd.PersistentFlags().StringSliceVar(&flags.eurekaargs.serverURLs, "eurekaURLs", nil,
+		"Comma separated list of the Eureka server URLs, tried in order")
+
+	discoveryCmd.PersistentFlags().IntVar(&flags.admissionOptions.Port, "admissionPort", 0,
+		"Validating admission webhook port for Istio configuration, disabled if zero")
+	discoveryCmd.PersistentFlags().StringVar(&flags.admissionOptions.CertFile, "admissionCert", "",
+		"File containing the x509 certificate of the admission webhook")
+	discoveryCmd.PersistentFlags().StringVar(&flags.admissionOptions.KeyFile, "admissionKey", "",
+		"File containing the x509 private key of the admission webhook")
+	discoveryCmd.PersistentFlags().BoolVar(&flags.admissionOptions.StrictConflicts, "admissionStrictConflicts", false,
+		"Reject the route rules that add a conflict with the stored route rules instead of logging a warning")
+	discoveryCmd.PersistentFlags().DurationVar(&flags.statusPeriod, "statusPeriod", 0,
+		"Interval for reporting the status of the configuration objects, disabled if zero. "+
+			"Enable it on a single Pilot replica, granted the permission to update the config.istio.io resources")
+	discoveryCmd.PersistentFlags().DurationVar(&flags.vmReaperPeriod, "vmReaperPeriod", 0,
+		"Interval for removing the VM endpoint addresses whose registration TTL expired, disabled if zero. "+
+			"Requires permissions to update endpoints")
+	discoveryCmd.PersistentFlags().BoolVar(&flags.isolation.Enabled, "isolation", false,
+		"Restrict route rules and services visible to sidecars to their own namespace")
+	discoveryCmd.PersistentFlags().StringSliceVar(&flags.isolation.ExportedNamespaces, "exportedNamespaces",
+		[]string{kube.IstioNamespace}, "Namespaces whose route rules and services are visible mesh-wide with isolation")
 
 	cmd.AddFlags(rootCmd)
 
@@ -0,0 +1,216 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+)
+
+// Severity ranks the importance of a configuration analysis finding
+type Severity int
+
+const (
+	// SeverityWarning marks configuration that is legal but likely unintended
+	SeverityWarning Severity = iota
+
+	// SeverityError marks configuration that cannot take effect
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return "unknown"
+}
+
+// MarshalJSON encodes the severity as a string
+func (s Severity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// Diagnostic is a finding produced by the cross-object semantic analysis of
+// the configuration against the service registry. Unlike the per-object
+// validation functions, diagnostics depend on the state of the mesh at the
+// time of the analysis.
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+
+	// Type is the configuration type of the offending object
+	Type string `json:"type"`
+
+	// Key identifies the offending object: the configuration key for rules,
+	// and the destination service for destination policies
+	Key string `json:"key"`
+
+	Message string `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.Severity, d.Key, d.Message)
+}
+
+// Diagnostics is a collection of analysis findings
+type Diagnostics []Diagnostic
+
+// HasErrors returns true if any of the findings is an error
+func (ds Diagnostics) HasErrors() bool {
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func (ds *Diagnostics) add(severity Severity, typ, key, format string, args ...interface{}) {
+	*ds = append(*ds, Diagnostic{
+		Severity: severity,
+		Type:     typ,
+		Key:      key,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// AnalyzeConfig checks the Istio configuration objects against each other and
+// against the services and instances in the registry. The checks cover
+// dangling destinations and sources, ingress ports that are not declared on the
+// destination service, weighted routes and policies whose tags do not select any
+// running instance, and conflicting route rules with the same precedence and
+// overlapping match conditions. The findings are sorted by the object key.
+func AnalyzeConfig(config IstioConfigStore, discovery ServiceDiscovery) Diagnostics {
+	out := make(Diagnostics, 0)
+	analyzeRouteRules(config.RouteRules(), discovery, &out)
+	analyzeIngressRules(config.IngressRules(), discovery, &out)
+	analyzeDestinationPolicies(config.DestinationPolicies(), discovery, &out)
+
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Key != out[j].Key {
+			return out[i].Key < out[j].Key
+		}
+		return out[i].Message < out[j].Message
+	})
+	return out
+}
+
+func analyzeRouteRules(rules map[string]*proxyconfig.RouteRule, discovery ServiceDiscovery, out *Diagnostics) {
+	for key, rule := range rules {
+		if rule.Match != nil && rule.Match.Source != "" {
+			if _, exists := discovery.GetService(rule.Match.Source); !exists {
+				out.add(SeverityWarning, RouteRule.Type, key, "source service %q not found", rule.Match.Source)
+			}
+		}
+
+		service, exists := discovery.GetService(rule.Destination)
+		if !exists {
+			out.add(SeverityError, RouteRule.Type, key, "destination service %q not found", rule.Destination)
+			continue
+		}
+
+		for _, route := range rule.Route {
+			target := service
+			if route.Destination != "" && route.Destination != rule.Destination {
+				if target, exists = discovery.GetService(route.Destination); !exists {
+					out.add(SeverityError, RouteRule.Type, key, "route destination service %q not found",
+						route.Destination)
+					continue
+				}
+			}
+
+			// untagged routes select all instances, while zero weight routes and
+			// external services receive no traffic from the instances
+			if len(route.Tags) == 0 || target.External() || (route.Weight == 0 && len(rule.Route) > 1) {
+				continue
+			}
+			if len(discovery.Instances(target.Hostname, target.Ports.GetNames(), TagsList{route.Tags})) == 0 {
+				out.add(SeverityWarning, RouteRule.Type, key,
+					"route to %q with tags %q and weight %d does not match any running instances",
+					target.Hostname, Tags(route.Tags).String(), route.Weight)
+			}
+		}
+	}
+
+	for _, conflict := range RouteRuleConflicts(rules) {
+		for _, key := range conflict.Keys {
+			out.add(SeverityWarning, RouteRule.Type, key, "%v, the tie is broken by the rule key", conflict)
+		}
+	}
+}
+
+func analyzeIngressRules(rules map[string]*proxyconfig.IngressRule, discovery ServiceDiscovery, out *Diagnostics) {
+	for key, rule := range rules {
+		service, exists := discovery.GetService(rule.Destination)
+		if !exists {
+			out.add(SeverityError, IngressRule.Type, key, "destination service %q not found", rule.Destination)
+			continue
+		}
+
+		var port *Port
+		switch p := rule.GetDestinationServicePort().(type) {
+		case *proxyconfig.IngressRule_DestinationPort:
+			if port, exists = service.Ports.GetByPort(int(p.DestinationPort)); !exists {
+				out.add(SeverityError, IngressRule.Type, key, "destination port %d not found on service %q",
+					p.DestinationPort, service.Hostname)
+				continue
+			}
+		case *proxyconfig.IngressRule_DestinationPortName:
+			if port, exists = service.Ports.Get(p.DestinationPortName); !exists {
+				out.add(SeverityError, IngressRule.Type, key, "destination port %q not found on service %q",
+					p.DestinationPortName, service.Hostname)
+				continue
+			}
+		default:
+			out.add(SeverityError, IngressRule.Type, key, "missing destination port")
+			continue
+		}
+
+		if !port.Protocol.IsHTTP() {
+			out.add(SeverityError, IngressRule.Type, key, "unsupported protocol %q for port %d on service %q",
+				port.Protocol, port.Port, service.Hostname)
+		}
+	}
+}
+
+func analyzeDestinationPolicies(policies []*proxyconfig.DestinationPolicy, discovery ServiceDiscovery,
+	out *Diagnostics) {
+	for _, policy := range policies {
+		key := policy.Destination
+		service, exists := discovery.GetService(policy.Destination)
+		if !exists {
+			out.add(SeverityError, DestinationPolicy.Type, key, "destination service %q not found", policy.Destination)
+			continue
+		}
+		if service.External() {
+			continue
+		}
+
+		for _, version := range policy.Policy {
+			if len(version.Tags) == 0 {
+				continue
+			}
+			if len(discovery.Instances(service.Hostname, service.Ports.GetNames(), TagsList{version.Tags})) == 0 {
+				out.add(SeverityWarning, DestinationPolicy.Type, key,
+					"policy for tags %q does not match any running instances", Tags(version.Tags).String())
+			}
+		}
+	}
+}
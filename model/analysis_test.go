@@ -0,0 +1,196 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/mock"
+)
+
+type finding struct {
+	severity model.Severity
+	key      string
+}
+
+func TestAnalyzeConfig(t *testing.T) {
+	cases := []struct {
+		name    string
+		configs []model.Config
+		want    []finding
+	}{
+		{
+			name: "valid configuration",
+			configs: []model.Config{
+				makeConfig(model.RouteRule, "weighted", mock.ExampleRouteRule),
+				makeConfig(model.IngressRule, "ingress", mock.ExampleIngressRule),
+			},
+			want: []finding{
+				// mock discovery has versions v0 and v1 only
+				{model.SeverityWarning, "route-rule/default/weighted"},
+			},
+		},
+		{
+			name: "dangling destinations",
+			configs: []model.Config{
+				makeConfig(model.RouteRule, "dangling", &proxyconfig.RouteRule{
+					Destination: "missing.default.svc.cluster.local",
+				}),
+				makeConfig(model.IngressRule, "dangling", &proxyconfig.IngressRule{
+					Name:        "dangling",
+					Destination: "missing.default.svc.cluster.local",
+				}),
+				makeConfig(model.DestinationPolicy, "missing", &proxyconfig.DestinationPolicy{
+					Destination: "missing.default.svc.cluster.local",
+				}),
+			},
+			want: []finding{
+				{model.SeverityError, "ingress-rule/default/dangling"},
+				{model.SeverityError, "missing.default.svc.cluster.local"},
+				{model.SeverityError, "route-rule/default/dangling"},
+			},
+		},
+		{
+			name: "unknown ingress ports",
+			configs: []model.Config{
+				makeConfig(model.IngressRule, "by-number", &proxyconfig.IngressRule{
+					Name:                   "by-number",
+					Destination:            mock.WorldService.Hostname,
+					DestinationServicePort: &proxyconfig.IngressRule_DestinationPort{DestinationPort: 8888},
+				}),
+				makeConfig(model.IngressRule, "by-name", &proxyconfig.IngressRule{
+					Name:                   "by-name",
+					Destination:            mock.WorldService.Hostname,
+					DestinationServicePort: &proxyconfig.IngressRule_DestinationPortName{DestinationPortName: "grpc"},
+				}),
+				makeConfig(model.IngressRule, "tcp", &proxyconfig.IngressRule{
+					Name:                   "tcp",
+					Destination:            mock.WorldService.Hostname,
+					DestinationServicePort: &proxyconfig.IngressRule_DestinationPortName{DestinationPortName: "custom"},
+				}),
+			},
+			want: []finding{
+				{model.SeverityError, "ingress-rule/default/by-name"},
+				{model.SeverityError, "ingress-rule/default/by-number"},
+				{model.SeverityError, "ingress-rule/default/tcp"},
+			},
+		},
+		{
+			name: "shared precedence",
+			configs: []model.Config{
+				makeConfig(model.RouteRule, "a", &proxyconfig.RouteRule{
+					Destination: mock.WorldService.Hostname,
+					Precedence:  2,
+				}),
+				makeConfig(model.RouteRule, "b", &proxyconfig.RouteRule{
+					Destination: mock.WorldService.Hostname,
+					Precedence:  2,
+				}),
+				makeConfig(model.RouteRule, "c", &proxyconfig.RouteRule{
+					Destination: mock.WorldService.Hostname,
+					Precedence:  3,
+				}),
+			},
+			want: []finding{
+				{model.SeverityWarning, "route-rule/default/a"},
+				{model.SeverityWarning, "route-rule/default/b"},
+			},
+		},
+		{
+			name: "unreachable policy",
+			configs: []model.Config{
+				makeConfig(model.DestinationPolicy, "world", &proxyconfig.DestinationPolicy{
+					Destination: mock.WorldService.Hostname,
+					Policy: []*proxyconfig.DestinationVersionPolicy{
+						{Tags: map[string]string{"version": "v1"}},
+						{Tags: map[string]string{"version": "v3"}},
+					},
+				}),
+			},
+			want: []finding{
+				{model.SeverityWarning, mock.WorldService.Hostname},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		store := memory.Make(model.IstioConfigTypes)
+		for _, config := range c.configs {
+			if _, err := store.Create(config); err != nil {
+				t.Fatalf("%s: %v", c.name, err)
+			}
+		}
+
+		diagnostics := model.AnalyzeConfig(model.MakeIstioStore(store), mock.Discovery)
+		got := make([]finding, 0, len(diagnostics))
+		for _, d := range diagnostics {
+			got = append(got, finding{d.Severity, d.Key})
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: AnalyzeConfig() => got %v, want %v (%v)", c.name, got, c.want, diagnostics)
+		}
+		if diagnostics.HasErrors() != (c.want[0].severity == model.SeverityError) {
+			t.Errorf("%s: HasErrors() => got %t", c.name, diagnostics.HasErrors())
+		}
+	}
+}
+
+func TestRouteRuleConflictsWith(t *testing.T) {
+	rule := func(name, destination string, precedence int32) model.Config {
+		return makeConfig(model.RouteRule, name, &proxyconfig.RouteRule{
+			Destination: destination + ".default.svc.cluster.local",
+			Precedence:  precedence,
+		})
+	}
+
+	store := memory.Make(model.IstioConfigTypes)
+	stored := rule("stored", "a", 1)
+	if _, err := store.Create(stored); err != nil {
+		t.Fatal(err)
+	}
+
+	inputs := []model.Config{rule("input-1", "b", 1), rule("input-2", "b", 1), rule("input-3", "a", 2)}
+	conflicts := model.RouteRuleConflictsWith(store, inputs...)
+	if len(conflicts) != 1 || conflicts[0].Keys != [2]string{inputs[0].Key(), inputs[1].Key()} {
+		t.Errorf("RouteRuleConflictsWith() => %v, want the conflict between the inputs", conflicts)
+	}
+
+	// updating the stored rule does not conflict with itself
+	if conflicts = model.RouteRuleConflictsWith(store, stored); len(conflicts) != 0 {
+		t.Errorf("RouteRuleConflictsWith() => %v, want none", conflicts)
+	}
+
+	conflicts = model.RouteRuleConflictsWith(store, rule("input", "a", 1))
+	if len(conflicts) != 1 || !conflicts[0].Duplicate {
+		t.Errorf("RouteRuleConflictsWith() => %v, want a duplicate of the stored rule", conflicts)
+	}
+}
+
+func makeConfig(schema model.ProtoSchema, name string, spec proto.Message) model.Config {
+	return model.Config{
+		ConfigMeta: model.ConfigMeta{
+			Type:      schema.Type,
+			Name:      name,
+			Namespace: "default",
+		},
+		Spec: spec,
+	}
+}
@@ -244,6 +244,10 @@ type IstioConfigStore interface {
 
 	// DestinationPolicy returns a policy for a service version.
 	DestinationPolicy(destination string, tags Tags) *proxyconfig.DestinationVersionPolicy
+
+	// DestinationPolicyConfig returns the destination policy configuration
+	// object applied to the destination service, if any.
+	DestinationPolicyConfig(destination string) (*Config, bool)
 }
 
 const (
@@ -310,11 +314,20 @@ var (
 // from the generic config registry
 type istioConfigStore struct {
 	ConfigStore
+	isolation Isolation
+	discovery ServiceDiscovery
 }
 
 // MakeIstioStore creates a wrapper around a store
 func MakeIstioStore(store ConfigStore) IstioConfigStore {
-	return &istioConfigStore{store}
+	return &istioConfigStore{ConfigStore: store}
+}
+
+// MakeIsolatedIstioStore creates a wrapper around a store that ignores the
+// route rules for destinations not visible in the namespaces of the rules.
+// The namespaces of the destinations are resolved with the service discovery.
+func MakeIsolatedIstioStore(store ConfigStore, isolation Isolation, discovery ServiceDiscovery) IstioConfigStore {
+	return &istioConfigStore{ConfigStore: store, isolation: isolation, discovery: discovery}
 }
 
 func (i istioConfigStore) RouteRules() map[string]*proxyconfig.RouteRule {
@@ -325,12 +338,36 @@ func (i istioConfigStore) RouteRules() map[string]*proxyconfig.RouteRule {
 	}
 	for _, r := range rs {
 		if rule, ok := r.Spec.(*proxyconfig.RouteRule); ok {
+			if !i.isolation.Visible(r.Namespace, DestinationNamespace(i.discovery, rule.Destination)) {
+				glog.V(2).Infof("RouteRules => ignoring %s for destination %s outside of the namespace",
+					r.Key(), rule.Destination)
+				continue
+			}
 			out[r.Key()] = rule
 		}
 	}
 	return out
 }
 
+// MatchSource checks that the rule match predicate applies to the source service instances
+func MatchSource(rule *proxyconfig.RouteRule, instances []*ServiceInstance) bool {
+	if rule.Match == nil || rule.Match.Source == "" {
+		return true
+	}
+	for _, instance := range instances {
+		// must match the source field if it is set
+		if rule.Match.Source != instance.Service.Hostname {
+			continue
+		}
+		// must match the tags field - the rule tags are a subset of the instance tags
+		var tags Tags = rule.Match.SourceTags
+		if tags.SubsetOf(instance.Tags) {
+			return true
+		}
+	}
+	return false
+}
+
 func (i *istioConfigStore) RouteRulesBySource(instances []*ServiceInstance) []*proxyconfig.RouteRule {
 	type config struct {
 		Key  string
@@ -338,26 +375,9 @@ func (i *istioConfigStore) RouteRulesBySource(instances []*ServiceInstance) []*p
 	}
 	rules := make([]config, 0)
 	for key, rule := range i.RouteRules() {
-		// validate that rule match predicate applies to source service instances
-		if rule.Match != nil && rule.Match.Source != "" {
-			found := false
-			for _, instance := range instances {
-				// must match the source field if it is set
-				if rule.Match.Source != instance.Service.Hostname {
-					continue
-				}
-				// must match the tags field - the rule tags are a subset of the instance tags
-				var tags Tags = rule.Match.SourceTags
-				if tags.SubsetOf(instance.Tags) {
-					found = true
-					break
-				}
-			}
-			if !found {
-				continue
-			}
+		if MatchSource(rule, instances) {
+			rules = append(rules, config{Key: key, Spec: rule})
 		}
-		rules = append(rules, config{Key: key, Spec: rule})
 	}
 	// sort by high precedence first, key string second (keys are unique)
 	sort.Slice(rules, func(i, j int) bool {
@@ -433,7 +453,7 @@ func (i *istioConfigStore) DestinationPolicies() []*proxyconfig.DestinationPolic
 	return out
 }
 
-func (i *istioConfigStore) DestinationPolicy(destination string, tags Tags) *proxyconfig.DestinationVersionPolicy {
+func (i *istioConfigStore) DestinationPolicyConfig(destination string) (*Config, bool) {
 	names := strings.Split(destination, ".")
 	name, namespace := "", ""
 	if len(names) > 0 {
@@ -442,8 +462,11 @@ func (i *istioConfigStore) DestinationPolicy(destination string, tags Tags) *pro
 	if len(names) > 1 {
 		namespace = names[1]
 	}
+	return i.Get(DestinationPolicy.Type, name, namespace)
+}
 
-	config, exists := i.Get(DestinationPolicy.Type, name, namespace)
+func (i *istioConfigStore) DestinationPolicy(destination string, tags Tags) *proxyconfig.DestinationVersionPolicy {
+	config, exists := i.DestinationPolicyConfig(destination)
 	if exists {
 		for _, policy := range config.Spec.(*proxyconfig.DestinationPolicy).Policy {
 			if tags.Equals(policy.Tags) {
@@ -0,0 +1,209 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	multierror "github.com/hashicorp/go-multierror"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+)
+
+// RouteRuleConflict describes a pair of route rules for the same destination
+// with the same precedence and overlapping match conditions. The order in
+// which such rules are applied is decided by their configuration keys, so
+// renaming either rule or moving it to another namespace may change which
+// rule takes effect.
+type RouteRuleConflict struct {
+	Destination string `json:"destination"`
+	Precedence  int32  `json:"precedence"`
+
+	// Keys are the configuration keys of the rules, in the order in which the
+	// rules are applied
+	Keys [2]string `json:"keys"`
+
+	// Duplicate is set if the match conditions are identical, in which case
+	// the second rule is never applied
+	Duplicate bool `json:"duplicate"`
+}
+
+func (c RouteRuleConflict) String() string {
+	kind := "overlap"
+	if c.Duplicate {
+		kind = "duplicate"
+	}
+	return fmt.Sprintf("%s %s and %s for destination %q at precedence %d", kind, c.Keys[0], c.Keys[1],
+		c.Destination, c.Precedence)
+}
+
+// RouteRuleConflicts returns the conflicts between the route rules keyed by the
+// configuration key. The conflicts are sorted by the keys of the rules.
+func RouteRuleConflicts(rules map[string]*proxyconfig.RouteRule) []RouteRuleConflict {
+	keys := make([]string, 0, len(rules))
+	for key := range rules {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	out := make([]RouteRuleConflict, 0)
+	for i, first := range keys {
+		a := rules[first]
+		for _, second := range keys[i+1:] {
+			b := rules[second]
+			if a.Destination != b.Destination || a.Precedence != b.Precedence {
+				continue
+			}
+			if !matchOverlaps(a.Match, b.Match) {
+				continue
+			}
+			out = append(out, RouteRuleConflict{
+				Destination: a.Destination,
+				Precedence:  a.Precedence,
+				Keys:        [2]string{first, second},
+				Duplicate:   matchEquals(a.Match, b.Match),
+			})
+		}
+	}
+	return out
+}
+
+// RouteRuleConflictsWith returns the conflicts that the route rules would have
+// with each other and with the route rules in the store if they were all
+// created or updated
+func RouteRuleConflictsWith(store ConfigStore, configs ...Config) []RouteRuleConflict {
+	rules := MakeIstioStore(store).RouteRules()
+	keys := make(map[string]bool)
+	for _, config := range configs {
+		rule, ok := config.Spec.(*proxyconfig.RouteRule)
+		if !ok || config.Type != RouteRule.Type {
+			continue
+		}
+		key := config.Key()
+		rules[key] = rule
+		keys[key] = true
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	out := make([]RouteRuleConflict, 0)
+	for _, conflict := range RouteRuleConflicts(rules) {
+		if keys[conflict.Keys[0]] || keys[conflict.Keys[1]] {
+			out = append(out, conflict)
+		}
+	}
+	return out
+}
+
+// NewRouteRuleConflicts returns the conflicts that the route rule would add
+// if it were created or updated. The conflicts that the version of the rule in
+// the store already has are left out, so that a conflicting rule can still be
+// updated, e.g. to resolve the conflict.
+func NewRouteRuleConflicts(store ConfigStore, config Config) []RouteRuleConflict {
+	conflicts := RouteRuleConflictsWith(store, config)
+	current, exists := store.Get(config.Type, config.Name, config.Namespace)
+	if !exists || len(conflicts) == 0 {
+		return conflicts
+	}
+
+	existing := make(map[[2]string]bool)
+	for _, conflict := range RouteRuleConflictsWith(store, *current) {
+		existing[conflict.Keys] = true
+	}
+	out := make([]RouteRuleConflict, 0, len(conflicts))
+	for _, conflict := range conflicts {
+		if !existing[conflict.Keys] {
+			out = append(out, conflict)
+		}
+	}
+	return out
+}
+
+// ConflictsError converts the conflicts into an error
+func ConflictsError(conflicts []RouteRuleConflict) (errs error) {
+	for _, conflict := range conflicts {
+		errs = multierror.Append(errs, fmt.Errorf("conflicting route rules: %v", conflict))
+	}
+	return
+}
+
+func matchEquals(a, b *proxyconfig.MatchCondition) bool {
+	if a == nil {
+		a = &proxyconfig.MatchCondition{}
+	}
+	if b == nil {
+		b = &proxyconfig.MatchCondition{}
+	}
+	return proto.Equal(a, b)
+}
+
+// matchOverlaps returns false only if the match conditions provably select
+// disjoint requests. Conditions that cannot be compared, such as regular
+// expressions and L4 attributes, are assumed to overlap.
+func matchOverlaps(a, b *proxyconfig.MatchCondition) bool {
+	if a == nil || b == nil {
+		return true
+	}
+
+	if a.Source != "" && b.Source != "" && a.Source != b.Source {
+		return false
+	}
+
+	for name, value := range a.SourceTags {
+		if other, exists := b.SourceTags[name]; exists && other != value {
+			return false
+		}
+	}
+
+	for name, match := range a.HttpHeaders {
+		if other, exists := b.HttpHeaders[name]; exists && !stringMatchOverlaps(match, other) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func stringMatchOverlaps(a, b *proxyconfig.StringMatch) bool {
+	// reduce exact matches to prefix matches with a flag
+	prefix := func(match *proxyconfig.StringMatch) (string, bool, bool) {
+		switch m := match.GetMatchType().(type) {
+		case *proxyconfig.StringMatch_Exact:
+			return m.Exact, true, true
+		case *proxyconfig.StringMatch_Prefix:
+			return m.Prefix, false, true
+		}
+		return "", false, false
+	}
+
+	pa, exactA, okA := prefix(a)
+	pb, exactB, okB := prefix(b)
+	switch {
+	case !okA || !okB:
+		return true
+	case exactA && exactB:
+		return pa == pb
+	case exactA:
+		return strings.HasPrefix(pa, pb)
+	case exactB:
+		return strings.HasPrefix(pb, pa)
+	default:
+		return strings.HasPrefix(pa, pb) || strings.HasPrefix(pb, pa)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"reflect"
+	"testing"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+)
+
+func TestRouteRuleConflicts(t *testing.T) {
+	exact := func(value string) *proxyconfig.StringMatch {
+		return &proxyconfig.StringMatch{MatchType: &proxyconfig.StringMatch_Exact{Exact: value}}
+	}
+	prefix := func(value string) *proxyconfig.StringMatch {
+		return &proxyconfig.StringMatch{MatchType: &proxyconfig.StringMatch_Prefix{Prefix: value}}
+	}
+	regex := func(value string) *proxyconfig.StringMatch {
+		return &proxyconfig.StringMatch{MatchType: &proxyconfig.StringMatch_Regex{Regex: value}}
+	}
+	headers := func(name string, match *proxyconfig.StringMatch) *proxyconfig.MatchCondition {
+		return &proxyconfig.MatchCondition{HttpHeaders: map[string]*proxyconfig.StringMatch{name: match}}
+	}
+
+	cases := []struct {
+		name string
+		a, b *proxyconfig.MatchCondition
+		// nil for no conflict
+		duplicate *bool
+	}{
+		{"no match", nil, nil, newBool(true)},
+		{"empty match", nil, &proxyconfig.MatchCondition{}, newBool(true)},
+		{"catch all", nil, headers(HeaderURI, prefix("/a")), newBool(false)},
+		{"same source", &proxyconfig.MatchCondition{Source: "a"}, &proxyconfig.MatchCondition{Source: "a"},
+			newBool(true)},
+		{"different sources", &proxyconfig.MatchCondition{Source: "a"}, &proxyconfig.MatchCondition{Source: "b"},
+			nil},
+		{"different source tags",
+			&proxyconfig.MatchCondition{SourceTags: map[string]string{"version": "v1"}},
+			&proxyconfig.MatchCondition{SourceTags: map[string]string{"version": "v2"}},
+			nil},
+		{"unrelated source tags",
+			&proxyconfig.MatchCondition{SourceTags: map[string]string{"version": "v1"}},
+			&proxyconfig.MatchCondition{SourceTags: map[string]string{"app": "a"}},
+			newBool(false)},
+		{"exact uris", headers(HeaderURI, exact("/a")), headers(HeaderURI, exact("/b")), nil},
+		{"exact uri within prefix", headers(HeaderURI, exact("/a/b")), headers(HeaderURI, prefix("/a")),
+			newBool(false)},
+		{"exact uri outside prefix", headers(HeaderURI, exact("/b")), headers(HeaderURI, prefix("/a")), nil},
+		{"nested prefixes", headers(HeaderURI, prefix("/a")), headers(HeaderURI, prefix("/a/b")), newBool(false)},
+		{"disjoint prefixes", headers(HeaderURI, prefix("/a")), headers(HeaderURI, prefix("/b")), nil},
+		{"regex", headers(HeaderURI, regex("/b.*")), headers(HeaderURI, prefix("/a")), newBool(false)},
+		{"different headers", headers(HeaderURI, exact("/a")), headers("cookie", exact("user=a")),
+			newBool(false)},
+	}
+
+	for _, c := range cases {
+		rules := map[string]*proxyconfig.RouteRule{
+			"b": {Destination: "world", Precedence: 1, Match: c.b},
+			"a": {Destination: "world", Precedence: 1, Match: c.a},
+			"c": {Destination: "world", Precedence: 2, Match: c.a},
+			"d": {Destination: "hello", Precedence: 1, Match: c.a},
+		}
+		want := []RouteRuleConflict{}
+		if c.duplicate != nil {
+			want = append(want, RouteRuleConflict{
+				Destination: "world",
+				Precedence:  1,
+				Keys:        [2]string{"a", "b"},
+				Duplicate:   *c.duplicate,
+			})
+		}
+		if got := RouteRuleConflicts(rules); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: RouteRuleConflicts() => got %v, want %v", c.name, got, want)
+		}
+	}
+}
+
+func newBool(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,73 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+// Isolation restricts the scope of the configuration and the services to
+// namespaces. With isolation enabled, route rules apply only to destination
+// services in the namespace of the rule, and sidecar proxies only receive
+// configuration for the services in their own namespace. The rules and the
+// services in the exported namespaces are visible mesh-wide.
+//
+// The namespace of a service is supplied by its service registry. The
+// services without a namespace, e.g. from registries without namespaces, and
+// the route rules for them are visible mesh-wide.
+type Isolation struct {
+	// Enabled turns on the namespace isolation
+	Enabled bool
+
+	// ExportedNamespaces lists the namespaces visible to all namespaces
+	ExportedNamespaces []string
+}
+
+// Visible checks if the objects in the source namespace are visible in the
+// target namespace
+func (iso Isolation) Visible(source, target string) bool {
+	if !iso.Enabled || source == target || source == "" || target == "" {
+		return true
+	}
+	for _, namespace := range iso.ExportedNamespaces {
+		if namespace == source {
+			return true
+		}
+	}
+	return false
+}
+
+// VisibleServices filters the services visible in the namespace
+func (iso Isolation) VisibleServices(namespace string, services []*Service) []*Service {
+	if !iso.Enabled {
+		return services
+	}
+	out := make([]*Service, 0, len(services))
+	for _, service := range services {
+		if iso.Visible(service.Namespace, namespace) {
+			out = append(out, service)
+		}
+	}
+	return out
+}
+
+// DestinationNamespace returns the namespace of the destination service in
+// the service registry, or an empty namespace for the services unknown to the
+// registry
+func DestinationNamespace(discovery ServiceDiscovery, hostname string) string {
+	if discovery == nil {
+		return ""
+	}
+	if service, exists := discovery.GetService(hostname); exists {
+		return service.Namespace
+	}
+	return ""
+}
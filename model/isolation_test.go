@@ -0,0 +1,106 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model_test
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/mock"
+)
+
+func TestIsolatedRouteRules(t *testing.T) {
+	store := memory.Make(model.IstioConfigTypes)
+	rules := []struct {
+		name, namespace, destination string
+	}{
+		{"own", "a", "hello.a.svc.cluster.local"},
+		{"hijack", "a", "hello.b.svc.cluster.local"},
+		{"exported", "istio-system", "hello.b.svc.cluster.local"},
+		{"consul", "a", "hello.service.dc1.consul"},
+	}
+	for _, rule := range rules {
+		config := makeConfig(model.RouteRule, rule.name, &proxyconfig.RouteRule{Destination: rule.destination})
+		config.Namespace = rule.namespace
+		if _, err := store.Create(config); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the consul service has no namespace
+	discovery := mock.NewDiscovery(map[string]*model.Service{
+		"hello.a.svc.cluster.local": mock.MakeService("hello.a.svc.cluster.local", "10.1.0.1"),
+		"hello.b.svc.cluster.local": mock.MakeService("hello.b.svc.cluster.local", "10.1.0.2"),
+		"hello.service.dc1.consul":  {Hostname: "hello.service.dc1.consul", Address: "10.1.0.3"},
+	}, 1)
+
+	cases := []struct {
+		isolation model.Isolation
+		want      []string
+	}{
+		{
+			isolation: model.Isolation{},
+			want: []string{
+				"route-rule/a/consul",
+				"route-rule/a/hijack",
+				"route-rule/a/own",
+				"route-rule/istio-system/exported",
+			},
+		},
+		{
+			isolation: model.Isolation{Enabled: true},
+			want:      []string{"route-rule/a/consul", "route-rule/a/own"},
+		},
+		{
+			isolation: model.Isolation{Enabled: true, ExportedNamespaces: []string{"istio-system"}},
+			want:      []string{"route-rule/a/consul", "route-rule/a/own", "route-rule/istio-system/exported"},
+		},
+	}
+
+	for _, c := range cases {
+		got := make([]string, 0)
+		for key := range model.MakeIsolatedIstioStore(store, c.isolation, discovery).RouteRules() {
+			got = append(got, key)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("RouteRules() with %+v => got %v, want %v", c.isolation, got, c.want)
+		}
+	}
+}
+
+func TestIsolationVisibleServices(t *testing.T) {
+	services := []*model.Service{
+		mock.HelloService,
+		mock.MakeService("world.other.svc.cluster.local", "10.1.0.1"),
+		mock.MakeService("mixer.istio-system.svc.cluster.local", "10.1.0.2"),
+		{Hostname: "hello.service.dc1.consul", Address: "10.1.0.3"},
+	}
+	isolation := model.Isolation{Enabled: true, ExportedNamespaces: []string{"istio-system"}}
+
+	got := isolation.VisibleServices("default", services)
+	want := []*model.Service{services[0], services[2], services[3]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("VisibleServices() => got %v, want %v", got, want)
+	}
+
+	if got = (model.Isolation{}).VisibleServices("default", services); !reflect.DeepEqual(got, services) {
+		t.Errorf("VisibleServices() without isolation => got %v, want %v", got, services)
+	}
+}
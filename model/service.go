@@ -43,7 +43,9 @@ type Service struct {
 	// Hostname of the service, e.g. "catalog.mystore.com"
 	Hostname string `json:"hostname"`
 
-	// Address specifies the service IPv4 address of the load balancer
+	// Address specifies the service IPv4 address of the load balancer. The
+	// address is empty for headless services, which are reached at the
+	// addresses of their instances.
 	Address string `json:"address,omitempty"`
 
 	// Ports is the set of network ports where the service is listening for
@@ -57,6 +59,11 @@ type Service struct {
 
 	// ServiceAccounts specifies the service accounts that run the service.
 	ServiceAccounts []string `json:"serviceaccounts,omitempty"`
+
+	// Namespace is the namespace of the service in the service registry, e.g.
+	// the Kubernetes namespace. The registries without namespaces leave it
+	// empty, and their services are visible in all namespaces.
+	Namespace string `json:"namespace,omitempty"`
 }
 
 // Port represents a network port where a service is listening for
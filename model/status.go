@@ -0,0 +1,76 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"encoding/json"
+)
+
+const (
+	// StatusAnnotation is the annotation holding the JSON encoded status of a
+	// configuration object
+	StatusAnnotation = IstioAPIGroup + "/status"
+
+	// ConfigAccepted indicates that the configuration object passed validation
+	ConfigAccepted = "Accepted"
+
+	// ConfigRejected indicates that the configuration object failed validation
+	// and is ignored by the proxies
+	ConfigRejected = "Rejected"
+)
+
+// ConfigStatus is the feedback reported back on a configuration object
+type ConfigStatus struct {
+	// State is either accepted or rejected
+	State string `json:"state"`
+
+	// Message explains the rejection, or warns about an accepted object that
+	// does not take effect
+	Message string `json:"message,omitempty"`
+
+	// ObservedGeneration is the generation of the object the status refers to
+	ObservedGeneration int64 `json:"observedGeneration"`
+
+	// Proxies is the number of proxies whose last fetched configuration
+	// includes the object
+	Proxies int `json:"proxies"`
+}
+
+// ParseConfigStatus reads the status from the object annotations
+func ParseConfigStatus(annotations map[string]string) (*ConfigStatus, bool) {
+	value, exists := annotations[StatusAnnotation]
+	if !exists {
+		return nil, false
+	}
+	out := &ConfigStatus{}
+	if err := json.Unmarshal([]byte(value), out); err != nil {
+		return nil, false
+	}
+	return out, true
+}
+
+// String encodes the status as an annotation value
+func (status *ConfigStatus) String() string {
+	out, _ := json.Marshal(status)
+	return string(out)
+}
+
+// ConfigUsage reports the extent to which the configuration objects are
+// applied to the proxies
+type ConfigUsage interface {
+	// ProxyCounts returns the number of proxies with the last fetched
+	// configuration including the object, keyed by the configuration key
+	ProxyCounts() map[string]int
+}
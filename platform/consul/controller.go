@@ -15,6 +15,9 @@
 package consul
 
 import (
+	"errors"
+	"fmt"
+	"sort"
 	"time"
 
 	"github.com/golang/glog"
@@ -25,32 +28,39 @@ import (
 
 // Controller communicates with Consul and monitors for changes
 type Controller struct {
-	client     *api.Client
-	dataCenter string
-	monitor    Monitor
+	client      *api.Client
+	datacenters []string
+	monitor     Monitor
 }
 
-// NewController creates a new Consul controller
-func NewController(addr, datacenter string, interval time.Duration) (*Controller, error) {
+// NewController creates a new Consul controller for the services in the
+// datacenters. The interval is the period for retrying failed watches.
+func NewController(addr string, datacenters []string, interval time.Duration) (*Controller, error) {
+	if len(datacenters) == 0 {
+		return nil, errors.New("missing consul datacenters")
+	}
+
 	conf := api.DefaultConfig()
 	conf.Address = addr
 
 	client, err := api.NewClient(conf)
 	return &Controller{
-		monitor:    NewConsulMonitor(client, interval),
-		client:     client,
-		dataCenter: datacenter,
+		monitor:     NewConsulMonitor(client, datacenters, interval),
+		client:      client,
+		datacenters: datacenters,
 	}, err
 }
 
 // Services list declarations of all services in the system
 func (c *Controller) Services() []*model.Service {
-	data := c.getServices()
-
-	services := make([]*model.Service, 0, len(data))
-	for name := range data {
-		endpoints := c.getCatalogService(name, nil)
-		services = append(services, convertService(endpoints))
+	services := make([]*model.Service, 0)
+	for _, dc := range c.datacenters {
+		for name := range c.getServices(dc) {
+			endpoints, _ := c.getCatalogService(name, dc)
+			if len(endpoints) > 0 {
+				services = append(services, convertService(endpoints))
+			}
+		}
 	}
 
 	return services
@@ -59,13 +69,13 @@ func (c *Controller) Services() []*model.Service {
 // GetService retrieves a service by host name if it exists
 func (c *Controller) GetService(hostname string) (*model.Service, bool) {
 	// Get actual service by name
-	name, err := parseHostname(hostname)
+	name, dc, err := c.parseHostname(hostname)
 	if err != nil {
 		glog.V(2).Infof("parseHostname(%s) => error %v", hostname, err)
 		return nil, false
 	}
 
-	endpoints := c.getCatalogService(name, nil)
+	endpoints, _ := c.getCatalogService(name, dc)
 	if len(endpoints) == 0 {
 		return nil, false
 	}
@@ -73,8 +83,23 @@ func (c *Controller) GetService(hostname string) (*model.Service, bool) {
 	return convertService(endpoints), true
 }
 
-func (c *Controller) getServices() map[string][]string {
-	data, _, err := c.client.Catalog().Services(nil)
+// parseHostname extracts the service name and the datacenter from the
+// hostname and checks that the datacenter is watched
+func (c *Controller) parseHostname(hostname string) (string, string, error) {
+	name, dc, err := parseHostname(hostname)
+	if err != nil {
+		return "", "", err
+	}
+	for _, watched := range c.datacenters {
+		if dc == watched {
+			return name, dc, nil
+		}
+	}
+	return "", "", fmt.Errorf("datacenter %q is not watched", dc)
+}
+
+func (c *Controller) getServices(dc string) map[string][]string {
+	data, _, err := c.client.Catalog().Services(&api.QueryOptions{Datacenter: dc})
 	if err != nil {
 		glog.Warningf("Could not retrieve services from consul: %v", err)
 		return make(map[string][]string)
@@ -83,14 +108,16 @@ func (c *Controller) getServices() map[string][]string {
 	return data
 }
 
-func (c *Controller) getCatalogService(name string, q *api.QueryOptions) []*api.CatalogService {
-	endpoints, _, err := c.client.Catalog().Service(name, "", q)
+// getCatalogService returns the entries of the service in the datacenter and
+// the subset of the entries passing the health checks
+func (c *Controller) getCatalogService(name, dc string) (all, healthy []*api.CatalogService) {
+	entries, _, err := c.client.Health().Service(name, "", false, &api.QueryOptions{Datacenter: dc})
 	if err != nil {
 		glog.Warningf("Could not retrieve service catalogue from consul: %v", err)
-		return []*api.CatalogService{}
+		return []*api.CatalogService{}, []*api.CatalogService{}
 	}
 
-	return endpoints
+	return convertEntries(entries, dc)
 }
 
 // ManagementPorts retries set of health check ports by instance IP.
@@ -105,7 +132,7 @@ func (c *Controller) ManagementPorts(addr string) model.PortList {
 // any of the supplied tags. All instances match an empty tag list.
 func (c *Controller) Instances(hostname string, ports []string, tags model.TagsList) []*model.ServiceInstance {
 	// Get actual service by name
-	name, err := parseHostname(hostname)
+	name, dc, err := c.parseHostname(hostname)
 	if err != nil {
 		glog.V(2).Infof("parseHostname(%s) => error %v", hostname, err)
 		return nil
@@ -116,7 +143,7 @@ func (c *Controller) Instances(hostname string, ports []string, tags model.TagsL
 		portMap[port] = true
 	}
 
-	endpoints := c.getCatalogService(name, nil)
+	_, endpoints := c.getCatalogService(name, dc)
 
 	instances := []*model.ServiceInstance{}
 	for _, endpoint := range endpoints {
@@ -144,13 +171,14 @@ func portMatch(instance *model.ServiceInstance, portMap map[string]bool) bool {
 
 // HostInstances lists service instances for a given set of IPv4 addresses.
 func (c *Controller) HostInstances(addrs map[string]bool) []*model.ServiceInstance {
-	data := c.getServices()
 	out := make([]*model.ServiceInstance, 0)
-	for svcName := range data {
-		endpoints := c.getCatalogService(svcName, nil)
-		for _, endpoint := range endpoints {
-			if addrs[endpoint.ServiceAddress] {
-				out = append(out, convertInstance(endpoint))
+	for _, dc := range c.datacenters {
+		for svcName := range c.getServices(dc) {
+			_, endpoints := c.getCatalogService(svcName, dc)
+			for _, endpoint := range endpoints {
+				if addrs[endpoint.ServiceAddress] {
+					out = append(out, convertInstance(endpoint))
+				}
 			}
 		}
 	}
@@ -165,8 +193,8 @@ func (c *Controller) Run(stop <-chan struct{}) {
 
 // AppendServiceHandler implements a service catalog operation
 func (c *Controller) AppendServiceHandler(f func(*model.Service, model.Event)) error {
-	c.monitor.AppendServiceHandler(func(instances []*api.CatalogService, event model.Event) error {
-		f(convertService(instances), event)
+	c.monitor.AppendServiceHandler(func(service *model.Service, event model.Event) error {
+		f(service, event)
 		return nil
 	})
 	return nil
@@ -174,14 +202,28 @@ func (c *Controller) AppendServiceHandler(f func(*model.Service, model.Event)) e
 
 // AppendInstanceHandler implements a service catalog operation
 func (c *Controller) AppendInstanceHandler(f func(*model.ServiceInstance, model.Event)) error {
-	c.monitor.AppendInstanceHandler(func(instance *api.CatalogService, event model.Event) error {
-		f(convertInstance(instance), event)
+	c.monitor.AppendInstanceHandler(func(instance *model.ServiceInstance, event model.Event) error {
+		f(instance, event)
 		return nil
 	})
 	return nil
 }
 
-// GetIstioServiceAccounts implements model.ServiceAccounts operation TODO
+// GetIstioServiceAccounts returns the Istio service accounts running a service
+// hostname, from the node meta of the healthy service instances
 func (c *Controller) GetIstioServiceAccounts(hostname string, ports []string) []string {
-	return nil
+	saSet := make(map[string]bool)
+	for _, si := range c.Instances(hostname, ports, model.TagsList{}) {
+		if si.ServiceAccount != "" {
+			saSet[si.ServiceAccount] = true
+		}
+	}
+
+	saArray := make([]string, 0, len(saSet))
+	for sa := range saSet {
+		saArray = append(saArray, sa)
+	}
+	sort.Strings(saArray)
+
+	return saArray
 }
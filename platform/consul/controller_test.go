@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 	"time"
 
@@ -52,6 +53,7 @@ var (
 			ServiceTags:    []string{"version|v1"},
 			ServiceAddress: "172.19.0.6",
 			ServicePort:    9080,
+			NodeMeta:       map[string]string{serviceAccountTagName: "reviews"},
 		},
 		{
 			Node:           "istio",
@@ -61,6 +63,7 @@ var (
 			ServiceTags:    []string{"version|v2"},
 			ServiceAddress: "172.19.0.7",
 			ServicePort:    9080,
+			NodeMeta:       map[string]string{serviceAccountTagName: "spiffe://cluster.local/ns/default/sa/reviews"},
 		},
 		{
 			Node:           "istio",
@@ -70,23 +73,72 @@ var (
 			ServiceTags:    []string{"version|v3"},
 			ServiceAddress: "172.19.0.8",
 			ServicePort:    9080,
-			NodeMeta:       map[string]string{protocolTagName: "tcp"},
+			NodeMeta:       map[string]string{protocolTagName: "tcp", zoneTagName: "us-east-1a"},
+		},
+		{
+			Node:           "istio",
+			Address:        "172.19.0.5",
+			ServiceID:      "555-555-555",
+			ServiceName:    "reviews",
+			ServiceTags:    []string{"version|v4"},
+			ServiceAddress: "172.19.0.9",
+			ServicePort:    9080,
+			NodeMeta:       map[string]string{serviceAccountTagName: "unhealthy"},
 		},
 	}
+
+	// failing health checks by the service ID
+	failing = map[string]bool{"555-555-555": true}
 )
 
+// healthEntries converts the catalog entries to health entries with a
+// passing or failing service check
+func healthEntries(endpoints []*api.CatalogService) []*api.ServiceEntry {
+	out := make([]*api.ServiceEntry, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		status := api.HealthPassing
+		if failing[endpoint.ServiceID] {
+			status = api.HealthCritical
+		}
+		out = append(out, &api.ServiceEntry{
+			Node: &api.Node{
+				Node:    endpoint.Node,
+				Address: endpoint.Address,
+				Meta:    endpoint.NodeMeta,
+			},
+			Service: &api.AgentService{
+				ID:      endpoint.ServiceID,
+				Service: endpoint.ServiceName,
+				Tags:    endpoint.ServiceTags,
+				Port:    endpoint.ServicePort,
+				Address: endpoint.ServiceAddress,
+			},
+			Checks: api.HealthChecks{{
+				Node:      endpoint.Node,
+				CheckID:   "service:" + endpoint.ServiceID,
+				Status:    status,
+				ServiceID: endpoint.ServiceID,
+			}},
+		})
+	}
+	return out
+}
+
 func newServer() *httptest.Server {
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/v1/catalog/services" {
+		if dc := r.URL.Query().Get("dc"); dc != "dc1" {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "No path to datacenter %q", dc)
+		} else if r.URL.Path == "/v1/catalog/services" {
 			data, _ := json.Marshal(&services)
 			w.Header().Set("Content-Type", "application/json")
 			fmt.Fprintln(w, string(data))
-		} else if r.URL.Path == "/v1/catalog/service/reviews" {
-			data, _ := json.Marshal(&reviews)
+		} else if r.URL.Path == "/v1/health/service/reviews" {
+			data, _ := json.Marshal(healthEntries(reviews))
 			w.Header().Set("Content-Type", "application/json")
 			fmt.Fprintln(w, string(data))
-		} else if r.URL.Path == "/v1/catalog/service/productpage" {
-			data, _ := json.Marshal(&productpage)
+		} else if r.URL.Path == "/v1/health/service/productpage" {
+			data, _ := json.Marshal(healthEntries(productpage))
 			w.Header().Set("Content-Type", "application/json")
 			fmt.Fprintln(w, string(data))
 		} else {
@@ -98,12 +150,12 @@ func newServer() *httptest.Server {
 func TestInstances(t *testing.T) {
 	ts := newServer()
 	defer ts.Close()
-	controller, err := NewController(ts.URL, "datacenter", 3*time.Second)
+	controller, err := NewController(ts.URL, []string{"dc1"}, 3*time.Second)
 	if err != nil {
 		t.Errorf("could not create Consul Controller: %v", err)
 	}
 
-	hostname := serviceHostname("reviews")
+	hostname := serviceHostname("reviews", "dc1")
 	instances := controller.Instances(hostname, []string{}, model.TagsList{})
 	if len(instances) != 3 {
 		t.Errorf("Instances() returned wrong # of service instances => %q, want 3", len(instances))
@@ -113,6 +165,22 @@ func TestInstances(t *testing.T) {
 			t.Errorf("Instances() returned wrong service instance => %v, want %q",
 				inst.Service.Hostname, hostname)
 		}
+		if inst.Endpoint.Address == "172.19.0.9" {
+			t.Errorf("Instances() returned an instance failing the health checks => %v", inst)
+		}
+		wantAZ := "dc1"
+		if inst.Endpoint.Address == "172.19.0.8" {
+			wantAZ = "us-east-1a"
+		}
+		if inst.AvailabilityZone != wantAZ {
+			t.Errorf("Instances() wrong availability zone for %s => %q, want %q",
+				inst.Endpoint.Address, inst.AvailabilityZone, wantAZ)
+		}
+	}
+
+	instances = controller.Instances(serviceHostname("reviews", "dc2"), []string{}, model.TagsList{})
+	if len(instances) != 0 {
+		t.Errorf("Instances() returned instances in a datacenter that is not watched => %v", instances)
 	}
 
 	filterTagKey := "version"
@@ -152,26 +220,30 @@ func TestInstances(t *testing.T) {
 func TestGetService(t *testing.T) {
 	ts := newServer()
 	defer ts.Close()
-	controller, err := NewController(ts.URL, "datacenter", 3*time.Second)
+	controller, err := NewController(ts.URL, []string{"dc1"}, 3*time.Second)
 	if err != nil {
 		t.Errorf("could not create Consul Controller: %v", err)
 	}
 
-	service, exists := controller.GetService("productpage.service.consul")
+	service, exists := controller.GetService("productpage.service.dc1.consul")
 	if !exists {
-		t.Error("service should exist")
+		t.Fatal("service should exist")
 	}
 
-	if service.Hostname != serviceHostname("productpage") {
+	if service.Hostname != serviceHostname("productpage", "dc1") {
 		t.Errorf("GetService() incorrect service returned => %q, want %q",
-			service.Hostname, serviceHostname("productpage"))
+			service.Hostname, serviceHostname("productpage", "dc1"))
+	}
+
+	if _, exists = controller.GetService("productpage.service.consul"); exists {
+		t.Error("GetService() should require the datacenter in the hostname")
 	}
 }
 
 func TestServices(t *testing.T) {
 	ts := newServer()
 	defer ts.Close()
-	controller, err := NewController(ts.URL, "datacenter", 3*time.Second)
+	controller, err := NewController(ts.URL, []string{"dc1"}, 3*time.Second)
 	if err != nil {
 		t.Errorf("could not create Consul Controller: %v", err)
 	}
@@ -179,7 +251,7 @@ func TestServices(t *testing.T) {
 	services := controller.Services()
 	serviceMap := make(map[string]*model.Service)
 	for _, svc := range services {
-		name, err := parseHostname(svc.Hostname)
+		name, _, err := parseHostname(svc.Hostname)
 		if err != nil {
 			t.Errorf("Services() error parsing hostname: %v", err)
 		}
@@ -194,7 +266,7 @@ func TestServices(t *testing.T) {
 func TestHostInstances(t *testing.T) {
 	ts := newServer()
 	defer ts.Close()
-	controller, err := NewController(ts.URL, "datacenter", 3*time.Second)
+	controller, err := NewController(ts.URL, []string{"dc1"}, 3*time.Second)
 	if err != nil {
 		t.Errorf("could not create Consul Controller: %v", err)
 	}
@@ -204,7 +276,27 @@ func TestHostInstances(t *testing.T) {
 		t.Errorf("HostInstances() returned wrong # of endpoints => %q, want 1", len(services))
 	}
 
-	if services[0].Service.Hostname != serviceHostname("productpage") {
+	if services[0].Service.Hostname != serviceHostname("productpage", "dc1") {
 		t.Errorf("HostInstances() wrong service instance returned => %q, want productpage", services[0])
 	}
 }
+
+func TestGetIstioServiceAccounts(t *testing.T) {
+	ts := newServer()
+	defer ts.Close()
+	controller, err := NewController(ts.URL, []string{"dc1"}, 3*time.Second)
+	if err != nil {
+		t.Errorf("could not create Consul Controller: %v", err)
+	}
+
+	sa := controller.GetIstioServiceAccounts(serviceHostname("reviews", "dc1"), []string{})
+	expected := []string{"spiffe://cluster.local/ns/default/sa/reviews"}
+	if !reflect.DeepEqual(sa, expected) {
+		t.Errorf("GetIstioServiceAccounts() => %v, want %v", sa, expected)
+	}
+
+	sa = controller.GetIstioServiceAccounts(serviceHostname("productpage", "dc1"), []string{})
+	if len(sa) != 0 {
+		t.Errorf("GetIstioServiceAccounts() => %v, want none", sa)
+	}
+}
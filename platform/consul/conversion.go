@@ -16,6 +16,7 @@ package consul
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/golang/glog"
@@ -27,6 +28,26 @@ import (
 const (
 	protocolTagName = "protocol"
 	externalTagName = "external"
+	zoneTagName     = "zone"
+
+	// serviceAccountTagName is the node meta key for the Istio service account
+	// running the service instances on the node, either the name of the
+	// service account, e.g. "productpage", or its complete URI, e.g.
+	// "spiffe://cluster.local/ns/default/sa/productpage"
+	serviceAccountTagName = "serviceaccount"
+
+	// serviceAccountNamespaceTagName is the node meta key for the namespace
+	// of the service account name, defaultNamespace if unset
+	serviceAccountNamespaceTagName = "serviceaccount_namespace"
+
+	// istioURIPrefix is the URI prefix in the Istio service account scheme
+	istioURIPrefix = "spiffe"
+
+	// defaultTrustDomain is the trust domain of the service account names
+	defaultTrustDomain = "cluster.local"
+
+	// defaultNamespace is the namespace of the service account names
+	defaultNamespace = "default"
 )
 
 func convertTags(tags []string) model.Tags {
@@ -82,9 +103,15 @@ func convertService(endpoints []*api.CatalogService) *model.Service {
 	for _, port := range ports {
 		svcPorts = append(svcPorts, port)
 	}
+	sort.Slice(svcPorts, func(i, j int) bool { return svcPorts[i].Port < svcPorts[j].Port })
+
+	dc := ""
+	if len(endpoints) > 0 {
+		dc = endpoints[0].Datacenter
+	}
 
 	out := &model.Service{
-		Hostname:     serviceHostname(name),
+		Hostname:     serviceHostname(name, dc),
 		Ports:        svcPorts,
 		Address:      addr,
 		ExternalName: external,
@@ -102,6 +129,11 @@ func convertInstance(instance *api.CatalogService) *model.ServiceInstance {
 		addr = instance.Address
 	}
 
+	az := instance.NodeMeta[zoneTagName]
+	if az == "" {
+		az = instance.Datacenter
+	}
+
 	return &model.ServiceInstance{
 		Endpoint: model.NetworkEndpoint{
 			Address:     addr,
@@ -110,31 +142,87 @@ func convertInstance(instance *api.CatalogService) *model.ServiceInstance {
 		},
 
 		Service: &model.Service{
-			Hostname: serviceHostname(instance.ServiceName),
+			Hostname: serviceHostname(instance.ServiceName, instance.Datacenter),
 			Address:  instance.ServiceAddress,
 			Ports:    model.PortList{port},
 			// TODO ExternalName come from metadata?
 			ExternalName: instance.NodeMeta[externalTagName],
 		},
-		Tags: tags,
+		Tags:             tags,
+		AvailabilityZone: az,
+		ServiceAccount:   convertServiceAccount(instance.NodeMeta),
+	}
+}
+
+// convertServiceAccount converts the service account in the node meta to an
+// Istio service account URI like the Kubernetes service accounts, e.g.
+// "productpage" to "spiffe://cluster.local/ns/default/sa/productpage". URIs
+// are kept.
+func convertServiceAccount(meta map[string]string) string {
+	name := meta[serviceAccountTagName]
+	if name == "" || strings.HasPrefix(name, istioURIPrefix+"://") {
+		return name
+	}
+	namespace := meta[serviceAccountNamespaceTagName]
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	return fmt.Sprintf("%v://%v/ns/%v/sa/%v", istioURIPrefix, defaultTrustDomain, namespace, name)
+}
+
+// convertEntries converts the health entries of a service in the datacenter
+// to the catalog form, and selects the entries with all health checks passing
+func convertEntries(entries []*api.ServiceEntry, dc string) (all, healthy []*api.CatalogService) {
+	all = make([]*api.CatalogService, 0, len(entries))
+	healthy = make([]*api.CatalogService, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Node == nil || entry.Service == nil {
+			continue
+		}
+		endpoint := &api.CatalogService{
+			ID:             entry.Node.ID,
+			Node:           entry.Node.Node,
+			Address:        entry.Node.Address,
+			Datacenter:     dc,
+			NodeMeta:       entry.Node.Meta,
+			ServiceID:      entry.Service.ID,
+			ServiceName:    entry.Service.Service,
+			ServiceAddress: entry.Service.Address,
+			ServiceTags:    entry.Service.Tags,
+			ServicePort:    entry.Service.Port,
+		}
+		all = append(all, endpoint)
+		if passing(entry.Checks) {
+			healthy = append(healthy, endpoint)
+		}
+	}
+	return
+}
+
+// passing checks that all node and service health checks pass
+func passing(checks api.HealthChecks) bool {
+	for _, check := range checks {
+		if check.Status != api.HealthPassing {
+			return false
+		}
 	}
+	return true
 }
 
-// serviceHostname produces FQDN for a consul service
-func serviceHostname(name string) string {
-	// TODO include datacenter in Hostname?
-	// consul DNS uses "redis.service.us-east-1.consul" -> "[<optional_tag>].<svc>.service.[<optional_datacenter>].consul"
-	return fmt.Sprintf("%s.service.consul", name)
+// serviceHostname produces FQDN for a consul service in the datacenter,
+// following consul DNS "<svc>.service.<datacenter>.consul"
+func serviceHostname(name, dc string) string {
+	return fmt.Sprintf("%s.service.%s.consul", name, dc)
 }
 
-// parseHostname extracts service name from the service hostname
-func parseHostname(hostname string) (name string, err error) {
+// parseHostname extracts service name and datacenter from the service hostname
+func parseHostname(hostname string) (name, dc string, err error) {
 	parts := strings.Split(hostname, ".")
-	if len(parts) < 1 {
-		err = fmt.Errorf("missing service name from the service hostname %q", hostname)
+	if len(parts) != 4 || parts[0] == "" || parts[1] != "service" || parts[2] == "" || parts[3] != "consul" {
+		err = fmt.Errorf("hostname %q does not match <service>.service.<datacenter>.consul", hostname)
 		return
 	}
-	name = parts[0]
+	name, dc = parts[0], parts[2]
 	return
 }
 
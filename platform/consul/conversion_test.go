@@ -82,6 +82,7 @@ func TestConvertInstance(t *testing.T) {
 	consulServiceInst := api.CatalogService{
 		Node:        "istio-node",
 		Address:     "172.19.0.5",
+		Datacenter:  "dc1",
 		ServiceID:   "1111-22-3333-444",
 		ServiceName: name,
 		ServiceTags: []string{
@@ -119,9 +120,13 @@ func TestConvertInstance(t *testing.T) {
 		t.Errorf("convertInstance() => missing or incorrect tag in %q", out.Tags)
 	}
 
-	if out.Service.Hostname != serviceHostname(name) {
+	if out.Service.Hostname != serviceHostname(name, "dc1") {
 		t.Errorf("convertInstance() bad service hostname => %q, want %q",
-			out.Service.Hostname, serviceHostname(name))
+			out.Service.Hostname, serviceHostname(name, "dc1"))
+	}
+
+	if out.AvailabilityZone != "dc1" {
+		t.Errorf("convertInstance() bad availability zone => %q, want %q", out.AvailabilityZone, "dc1")
 	}
 
 	if out.Service.Address != ip {
@@ -142,10 +147,64 @@ func TestConvertInstance(t *testing.T) {
 }
 
 func TestServiceHostname(t *testing.T) {
-	out := serviceHostname("productpage")
+	out := serviceHostname("productpage", "dc1")
+
+	if out != "productpage.service.dc1.consul" {
+		t.Errorf("serviceHostname() => %q, want %q", out, "productpage.service.dc1.consul")
+	}
+}
+
+func TestParseHostname(t *testing.T) {
+	name, dc, err := parseHostname("productpage.service.dc1.consul")
+	if err != nil || name != "productpage" || dc != "dc1" {
+		t.Errorf("parseHostname() => (%q, %q, %v), want (%q, %q, nil)", name, dc, err, "productpage", "dc1")
+	}
+
+	for _, hostname := range []string{
+		"productpage.service.consul",
+		"productpage.default.svc.cluster.local",
+		"productpage.service..consul",
+	} {
+		if _, _, err = parseHostname(hostname); err == nil {
+			t.Errorf("parseHostname(%q) => expected an error", hostname)
+		}
+	}
+}
 
-	if out != "productpage.service.consul" {
-		t.Errorf("serviceHostname() => %q, want %q", out, "productpage.service.consul")
+func TestConvertEntries(t *testing.T) {
+	entries := []*api.ServiceEntry{
+		{
+			Node:    &api.Node{Node: "istio-node", Address: "172.19.0.5"},
+			Service: &api.AgentService{ID: "passing", Service: "productpage", Port: 9080},
+			Checks: api.HealthChecks{
+				{CheckID: "serfHealth", Status: api.HealthPassing},
+				{CheckID: "service:passing", Status: api.HealthPassing, ServiceID: "passing"},
+			},
+		},
+		{
+			Node:    &api.Node{Node: "istio-node", Address: "172.19.0.5"},
+			Service: &api.AgentService{ID: "warning", Service: "productpage", Port: 9081},
+			Checks: api.HealthChecks{
+				{CheckID: "serfHealth", Status: api.HealthPassing},
+				{CheckID: "service:warning", Status: api.HealthWarning, ServiceID: "warning"},
+			},
+		},
+		{
+			Node:    &api.Node{Node: "failing-node", Address: "172.19.0.6"},
+			Service: &api.AgentService{ID: "node", Service: "productpage", Port: 9080},
+			Checks:  api.HealthChecks{{CheckID: "serfHealth", Status: api.HealthCritical}},
+		},
+	}
+
+	all, healthy := convertEntries(entries, "dc1")
+	if len(all) != 3 {
+		t.Errorf("convertEntries() => %d entries, want 3", len(all))
+	}
+	if len(healthy) != 1 || healthy[0].ServiceID != "passing" || healthy[0].Datacenter != "dc1" {
+		t.Errorf("convertEntries() => healthy entries %v, want the passing entry", healthy)
+	}
+	if out := convertService(all); len(out.Ports) != 2 || out.Hostname != serviceHostname("productpage", "dc1") {
+		t.Errorf("convertService() => %v, want 2 ports in dc1", out)
 	}
 }
 
@@ -164,6 +223,7 @@ func TestConvertService(t *testing.T) {
 			ServiceAddress: "172.19.0.11",
 			ServicePort:    9080,
 			NodeMeta:       map[string]string{protocolTagName: "udp"},
+			Datacenter:     "dc1",
 		},
 		{
 			Node:        "istio-node",
@@ -176,14 +236,15 @@ func TestConvertService(t *testing.T) {
 			ServiceAddress: "172.19.0.12",
 			ServicePort:    9080,
 			NodeMeta:       map[string]string{protocolTagName: "udp"},
+			Datacenter:     "dc1",
 		},
 	}
 
 	out := convertService(consulServiceInsts)
 
-	if out.Hostname != serviceHostname(name) {
+	if out.Hostname != serviceHostname(name, "dc1") {
 		t.Errorf("convertService() bad hostname => %q, want %q",
-			out.Hostname, serviceHostname(name))
+			out.Hostname, serviceHostname(name, "dc1"))
 	}
 
 	if out.External() {
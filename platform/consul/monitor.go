@@ -17,6 +17,7 @@ package consul
 import (
 	"reflect"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
@@ -25,9 +26,6 @@ import (
 	"istio.io/pilot/model"
 )
 
-type consulServices map[string][]string
-type consulServiceInstances []*api.CatalogService
-
 // Monitor handles service and instance changes
 type Monitor interface {
 	Start(<-chan struct{})
@@ -36,114 +34,203 @@ type Monitor interface {
 }
 
 // InstanceHandler processes service instance change events
-type InstanceHandler func(instance *api.CatalogService, event model.Event) error
+type InstanceHandler func(instance *model.ServiceInstance, event model.Event) error
 
 // ServiceHandler processes service change events
-type ServiceHandler func(instances []*api.CatalogService, event model.Event) error
+type ServiceHandler func(service *model.Service, event model.Event) error
 
+// consulMonitor watches the catalog with Consul blocking queries. The list of
+// services in each datacenter is watched to start and stop a watch per
+// service, and each service watch compares the health entries with the
+// previous result to emit the events for the service and its instances.
+// Instances are only reported while all their health checks pass.
 type consulMonitor struct {
-	discovery            *api.Client
-	instanceCachedRecord consulServiceInstances
-	serviceCachedRecord  consulServices
-	instanceHandlers     []InstanceHandler
-	serviceHandlers      []ServiceHandler
-	period               time.Duration
+	discovery        *api.Client
+	datacenters      []string
+	instanceHandlers []InstanceHandler
+	serviceHandlers  []ServiceHandler
+	// period to wait before retrying a failed query
+	period time.Duration
+
+	// mutex serializes the handler invocations across the service watches
+	mutex sync.Mutex
 }
 
-// NewConsulMonitor polls for changes in Consul Services and CatalogServices
-func NewConsulMonitor(client *api.Client, period time.Duration) Monitor {
+// NewConsulMonitor watches for changes in Consul Services and CatalogServices
+// in the datacenters
+func NewConsulMonitor(client *api.Client, datacenters []string, period time.Duration) Monitor {
 	return &consulMonitor{
-		discovery:            client,
-		period:               period,
-		instanceCachedRecord: make(consulServiceInstances, 0),
-		serviceCachedRecord:  make(consulServices),
-		instanceHandlers:     make([]InstanceHandler, 0),
-		serviceHandlers:      make([]ServiceHandler, 0),
+		discovery:        client,
+		datacenters:      datacenters,
+		period:           period,
+		instanceHandlers: make([]InstanceHandler, 0),
+		serviceHandlers:  make([]ServiceHandler, 0),
 	}
 }
 
 func (m *consulMonitor) Start(stop <-chan struct{}) {
-	m.run(stop)
+	var wg sync.WaitGroup
+	for _, dc := range m.datacenters {
+		wg.Add(1)
+		go func(dc string) {
+			defer wg.Done()
+			m.run(dc, stop)
+		}(dc)
+	}
+	wg.Wait()
 }
 
-func (m *consulMonitor) run(stop <-chan struct{}) {
-	ticker := time.NewTicker(m.period)
+func (m *consulMonitor) run(dc string, stop <-chan struct{}) {
+	// stop channels of the service watches by the service name
+	watches := make(map[string]chan struct{})
+	defer func() {
+		for _, ch := range watches {
+			close(ch)
+		}
+	}()
+
+	var index uint64
 	for {
-		select {
-		case <-stop:
-			ticker.Stop()
+		svcs, meta, err := m.discovery.Catalog().Services(&api.QueryOptions{Datacenter: dc, WaitIndex: index})
+		if isStopped(stop) {
 			return
-		case <-ticker.C:
-			m.updateServiceRecord()
-			m.updateInstanceRecord()
+		}
+		if err != nil {
+			glog.Warningf("Could not fetch services in %s: %v", dc, err)
+			if !m.backoff(stop) {
+				return
+			}
+			continue
+		}
+		index = nextIndex(index, meta.LastIndex)
+
+		for name := range svcs {
+			if _, exists := watches[name]; !exists {
+				ch := make(chan struct{})
+				watches[name] = ch
+				go m.watchService(name, dc, ch)
+			}
+		}
+		for name, ch := range watches {
+			if _, exists := svcs[name]; !exists {
+				close(ch)
+				delete(watches, name)
+			}
 		}
 	}
 }
 
-func (m *consulMonitor) updateServiceRecord() {
-	svcs, _, err := m.discovery.Catalog().Services(nil)
-	if err != nil {
-		glog.Warningf("Could not fetch services: %v", err)
-		return
-	}
-	newRecord := consulServices(svcs)
-	if !reflect.DeepEqual(newRecord, m.serviceCachedRecord) {
-		// This is only a work-around solution currently
-		// Since Handler functions generally act as a refresher
-		// regardless of the input, thus passing in meaningless
-		// input should make functionalities work
-		//TODO
-		obj := []*api.CatalogService{}
-		var event model.Event
-		for _, f := range m.serviceHandlers {
-			go func(handler ServiceHandler) {
-				if err := handler(obj, event); err != nil {
-					glog.Warningf("Error executing service handler function: %v", err)
-				}
-			}(f)
+// watchService emits the events for a service until the service is removed
+// from the catalog or the monitor stops
+func (m *consulMonitor) watchService(name, dc string, stop <-chan struct{}) {
+	var service *model.Service
+	instances := make(map[string]*model.ServiceInstance)
+	var index uint64
+	for {
+		entries, meta, err := m.discovery.Health().Service(name, "", false,
+			&api.QueryOptions{Datacenter: dc, WaitIndex: index})
+		if isStopped(stop) {
+			break
+		}
+		if err != nil {
+			glog.Warningf("Could not retrieve service catalogue for %s in %s from consul: %v", name, dc, err)
+			if !m.backoff(stop) {
+				break
+			}
+			continue
 		}
-		m.serviceCachedRecord = newRecord
+		index = nextIndex(index, meta.LastIndex)
+		all, healthy := convertEntries(entries, dc)
+		service = m.updateService(service, instances, all, healthy)
 	}
+
+	// the service is gone or the monitor stopped
+	m.updateService(service, instances, nil, nil)
 }
 
-func (m *consulMonitor) updateInstanceRecord() {
-	svcs, _, err := m.discovery.Catalog().Services(nil)
-	if err != nil {
-		glog.Warningf("Could not fetch instances: %v", err)
-		return
+// updateService notifies the handlers of the differences between the cached
+// service and instances and the catalog entries, and updates the instance
+// cache in place. The service is built from all entries and the instances
+// from the healthy entries. It returns the service for the entries, nil if
+// there are no entries.
+func (m *consulMonitor) updateService(previous *model.Service, instances map[string]*model.ServiceInstance,
+	endpoints, healthy []*api.CatalogService) *model.Service {
+	var service *model.Service
+	if len(endpoints) > 0 {
+		service = convertService(endpoints)
 	}
-	for _, tags := range svcs {
-		sort.Strings(tags)
+
+	current := make(map[string]*model.ServiceInstance, len(healthy))
+	for _, endpoint := range healthy {
+		current[instanceKey(endpoint)] = convertInstance(endpoint)
 	}
 
-	instances := make([]*api.CatalogService, 0)
-	for name := range svcs {
-		endpoints, _, err := m.discovery.Catalog().Service(name, "", nil)
-		if err != nil {
-			glog.Warningf("Could not retrieve service catalogue from consul: %v", err)
-			continue
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// services are added before and deleted after their instances
+	switch {
+	case previous == nil && service != nil:
+		m.notifyService(service, model.EventAdd)
+	case previous != nil && service != nil && !reflect.DeepEqual(previous, service):
+		m.notifyService(service, model.EventUpdate)
+	}
+
+	keys := make([]string, 0, len(instances)+len(current))
+	for key := range instances {
+		keys = append(keys, key)
+	}
+	for key := range current {
+		if _, exists := instances[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		old, cur := instances[key], current[key]
+		switch {
+		case old == nil:
+			m.notifyInstance(cur, model.EventAdd)
+			instances[key] = cur
+		case cur == nil:
+			m.notifyInstance(old, model.EventDelete)
+			delete(instances, key)
+		case !reflect.DeepEqual(old, cur):
+			m.notifyInstance(cur, model.EventUpdate)
+			instances[key] = cur
+		}
+	}
+
+	if previous != nil && service == nil {
+		m.notifyService(previous, model.EventDelete)
+	}
+	return service
+}
+
+func (m *consulMonitor) notifyService(service *model.Service, event model.Event) {
+	for _, f := range m.serviceHandlers {
+		if err := f(service, event); err != nil {
+			glog.Warningf("Error executing service handler function: %v", err)
 		}
-		instances = append(instances, endpoints...)
-	}
-
-	newRecord := consulServiceInstances(instances)
-	sort.Sort(newRecord)
-	if !reflect.DeepEqual(newRecord, m.instanceCachedRecord) {
-		// This is only a work-around solution currently
-		// Since Handler functions generally act as a refresher
-		// regardless of the input, thus passing in meaningless
-		// input should make functionalities work
-		// TODO
-		obj := &api.CatalogService{}
-		var event model.Event
-		for _, f := range m.instanceHandlers {
-			go func(handler InstanceHandler) {
-				if err := handler(obj, event); err != nil {
-					glog.Warningf("Error executing instance handler function: %v", err)
-				}
-			}(f)
+	}
+}
+
+func (m *consulMonitor) notifyInstance(instance *model.ServiceInstance, event model.Event) {
+	for _, f := range m.instanceHandlers {
+		if err := f(instance, event); err != nil {
+			glog.Warningf("Error executing instance handler function: %v", err)
 		}
-		m.instanceCachedRecord = newRecord
+	}
+}
+
+// backoff waits for the retry period and returns false if stopped meanwhile
+func (m *consulMonitor) backoff(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return false
+	case <-time.After(m.period):
+		return true
 	}
 }
 
@@ -155,17 +242,25 @@ func (m *consulMonitor) AppendInstanceHandler(h InstanceHandler) {
 	m.instanceHandlers = append(m.instanceHandlers, h)
 }
 
-// Len of the array
-func (a consulServiceInstances) Len() int {
-	return len(a)
+// instanceKey identifies a service instance in the catalog
+func instanceKey(endpoint *api.CatalogService) string {
+	return endpoint.Node + "/" + endpoint.ServiceID
 }
 
-// Swap i and j
-func (a consulServiceInstances) Swap(i, j int) {
-	a[i], a[j] = a[j], a[i]
+// nextIndex returns the wait index for the next blocking query. The index is
+// reset if it goes backwards, e.g. after the Consul state is restored.
+func nextIndex(previous, last uint64) uint64 {
+	if last < previous {
+		return 0
+	}
+	return last
 }
 
-// Less i and j
-func (a consulServiceInstances) Less(i, j int) bool {
-	return a[i].ID < a[j].ID
+func isStopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
 }
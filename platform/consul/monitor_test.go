@@ -0,0 +1,217 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	"istio.io/pilot/model"
+)
+
+// catalog is a Consul catalog server that supports blocking queries
+type catalog struct {
+	mutex    sync.Mutex
+	index    uint64
+	services map[string][]*api.CatalogService
+	// failing health checks by the service ID
+	failing map[string]bool
+	// changed is closed and replaced on every catalog change
+	changed chan struct{}
+	done    chan struct{}
+}
+
+func newCatalog() *catalog {
+	return &catalog{
+		index:    1,
+		services: make(map[string][]*api.CatalogService),
+		failing:  make(map[string]bool),
+		changed:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (c *catalog) set(name string, endpoints ...*api.CatalogService) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(endpoints) == 0 {
+		delete(c.services, name)
+	} else {
+		c.services[name] = endpoints
+	}
+	c.notify()
+}
+
+func (c *catalog) fail(id string, failing bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.failing[id] = failing
+	c.notify()
+}
+
+// notify must be called under the lock
+func (c *catalog) notify() {
+	c.index++
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *catalog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wait, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	for {
+		c.mutex.Lock()
+		index, changed := c.index, c.changed
+		if index > wait {
+			break
+		}
+		c.mutex.Unlock()
+		select {
+		case <-changed:
+		case <-c.done:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	}
+	defer c.mutex.Unlock()
+
+	var data interface{}
+	switch {
+	case r.URL.Path == "/v1/catalog/services":
+		svcs := make(map[string][]string, len(c.services))
+		for name := range c.services {
+			svcs[name] = []string{}
+		}
+		data = svcs
+	case strings.HasPrefix(r.URL.Path, "/v1/health/service/"):
+		entries := healthEntries(c.services[strings.TrimPrefix(r.URL.Path, "/v1/health/service/")])
+		for _, entry := range entries {
+			if c.failing[entry.Service.ID] {
+				entry.Checks[0].Status = api.HealthCritical
+			}
+		}
+		data = entries
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(c.index, 10))
+	_ = json.NewEncoder(w).Encode(data)
+}
+
+type monitorEvent struct {
+	kind  string
+	key   string
+	event model.Event
+}
+
+func makeEndpoint(id, addr string, port int, tags ...string) *api.CatalogService {
+	return &api.CatalogService{
+		Node:           "istio",
+		Address:        "172.19.0.5",
+		ServiceID:      id,
+		ServiceName:    "productpage",
+		ServiceTags:    tags,
+		ServiceAddress: addr,
+		ServicePort:    port,
+	}
+}
+
+func TestMonitorEvents(t *testing.T) {
+	c := newCatalog()
+	ts := httptest.NewServer(c)
+	defer ts.Close()
+	defer close(c.done)
+
+	conf := api.DefaultConfig()
+	conf.Address = ts.URL
+	client, err := api.NewClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan monitorEvent, 10)
+	monitor := NewConsulMonitor(client, []string{"dc1"}, 10*time.Millisecond)
+	monitor.AppendServiceHandler(func(service *model.Service, event model.Event) error {
+		key := service.Hostname + ":" + strconv.Itoa(len(service.Ports))
+		events <- monitorEvent{kind: "service", key: key, event: event}
+		return nil
+	})
+	monitor.AppendInstanceHandler(func(instance *model.ServiceInstance, event model.Event) error {
+		key := instance.Endpoint.Address + ":" + instance.Tags["version"]
+		events <- monitorEvent{kind: "instance", key: key, event: event}
+		return nil
+	})
+	stop := make(chan struct{})
+	defer close(stop)
+	go monitor.Start(stop)
+
+	expect := func(want ...monitorEvent) {
+		for _, w := range want {
+			select {
+			case got := <-events:
+				if got != w {
+					t.Fatalf("got event %+v, want %+v", got, w)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for event %+v", w)
+			}
+		}
+	}
+
+	hostname := serviceHostname("productpage", "dc1")
+	v1 := makeEndpoint("111-111-111", "172.19.0.11", 9080, "version|v1")
+	c.set("productpage", v1)
+	expect(
+		monitorEvent{"service", hostname + ":1", model.EventAdd},
+		monitorEvent{"instance", "172.19.0.11:v1", model.EventAdd},
+	)
+
+	v2 := makeEndpoint("111-111-111", "172.19.0.11", 9080, "version|v2")
+	c.set("productpage", v2)
+	expect(monitorEvent{"instance", "172.19.0.11:v2", model.EventUpdate})
+
+	c.set("productpage", v2, makeEndpoint("222-222-222", "172.19.0.12", 9081, "version|v3"))
+	expect(
+		monitorEvent{"service", hostname + ":2", model.EventUpdate},
+		monitorEvent{"instance", "172.19.0.12:v3", model.EventAdd},
+	)
+
+	c.fail("222-222-222", true)
+	expect(monitorEvent{"instance", "172.19.0.12:v3", model.EventDelete})
+	c.fail("222-222-222", false)
+	expect(monitorEvent{"instance", "172.19.0.12:v3", model.EventAdd})
+
+	c.set("productpage")
+	expect(
+		monitorEvent{"instance", "172.19.0.11:v2", model.EventDelete},
+		monitorEvent{"instance", "172.19.0.12:v3", model.EventDelete},
+		monitorEvent{"service", hostname + ":2", model.EventDelete},
+	)
+
+	select {
+	case got := <-events:
+		t.Errorf("unexpected event %+v", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -20,7 +20,11 @@ import (
 	"io/ioutil"
 	"net/http"
 	"sort"
+	"sync"
 	"time"
+
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
 )
 
 type application struct {
@@ -29,13 +33,17 @@ type application struct {
 }
 
 type instance struct { // nolint: aligncheck
-	Hostname   string `json:"hostName"`
-	IPAddress  string `json:"ipAddr"`
-	Status     string `json:"status"`
-	Port       port   `json:"port"`
-	SecurePort port   `json:"securePort"`
-	// TODO: read dataCenterInfo for AZ support
-	Metadata metadata `json:"metadata,omitempty"`
+	InstanceID     string          `json:"instanceId,omitempty"`
+	Hostname       string          `json:"hostName"`
+	IPAddress      string          `json:"ipAddr"`
+	Status         string          `json:"status"`
+	Port           port            `json:"port"`
+	SecurePort     port            `json:"securePort"`
+	DataCenterInfo *dataCenterInfo `json:"dataCenterInfo,omitempty"`
+	Metadata       metadata        `json:"metadata,omitempty"`
+
+	// ActionType of the instance in the delta responses
+	ActionType string `json:"actionType,omitempty"`
 }
 
 type port struct {
@@ -43,6 +51,11 @@ type port struct {
 	Enabled bool `json:"@enabled,string"`
 }
 
+type dataCenterInfo struct {
+	Name     string   `json:"name"`
+	Metadata metadata `json:"metadata,omitempty"`
+}
+
 type metadata map[string]string
 
 // Client for Eureka
@@ -51,28 +64,48 @@ type Client interface {
 	Applications() ([]*application, error)
 }
 
-// Minimal client for Eureka server's REST APIs.
-// TODO: support multiple Eureka servers
-// TODO: caching
+// Minimal client for Eureka server's REST APIs. The client caches the
+// applications and applies the delta of the changes since the last fetch. The
+// servers are tried in order, starting from the last server that responded.
 // TODO: Eureka v3 support
 type client struct {
-	client http.Client
-	url    string
+	client  http.Client
+	servers []string
+
+	mutex sync.Mutex
+	// current is the index of the last server that responded
+	current int
+	// apps caches the instances by the instance key by the application name,
+	// nil until all applications are fetched
+	apps map[string]map[string]*instance
 }
 
-// NewClient instantiates a new Eureka client
-func NewClient(url string) Client {
+// NewClient instantiates a new Eureka client for the server URLs
+func NewClient(urls ...string) Client {
 	return &client{
-		client: http.Client{Timeout: 30 * time.Second},
-		url:    url,
+		client:  http.Client{Timeout: 30 * time.Second},
+		servers: urls,
 	}
 }
 
-const statusUp = "UP"
+// Eureka instance statuses. DOWN and UNKNOWN instances are ignored.
+const (
+	statusUp           = "UP"
+	statusStarting     = "STARTING"
+	statusOutOfService = "OUT_OF_SERVICE"
+)
+
+// Eureka delta action types
+const (
+	actionAdded    = "ADDED"
+	actionModified = "MODIFIED"
+	actionDeleted  = "DELETED"
+)
 
 const (
-	basePath = "/eureka/v2"
-	appsPath = basePath + "/apps"
+	basePath  = "/eureka/v2"
+	appsPath  = basePath + "/apps"
+	deltaPath = appsPath + "/delta"
 )
 
 type getApplications struct {
@@ -80,11 +113,59 @@ type getApplications struct {
 }
 
 type applications struct {
+	// HashCode reconciles the applications, e.g. "DOWN_1_UP_2_"
+	HashCode     string         `json:"apps__hashcode"`
 	Applications []*application `json:"application"`
 }
 
 func (c *client) Applications() ([]*application, error) {
-	req, err := http.NewRequest("GET", c.url+appsPath, nil)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.apps != nil {
+		delta, err := c.fetch(deltaPath)
+		if err == nil {
+			c.apply(delta.Applications)
+			if hashCode := c.hashCode(); hashCode == delta.HashCode {
+				return c.applications(), nil
+			}
+			glog.V(2).Infof("Eureka applications do not match the hash code %q after the delta, fetching all",
+				delta.HashCode)
+		} else {
+			glog.Warningf("Eureka delta fetch failed: %v", err)
+		}
+	}
+
+	apps, err := c.fetch(appsPath)
+	if err != nil {
+		return nil, err
+	}
+	c.apps = make(map[string]map[string]*instance)
+	c.apply(apps.Applications)
+	return c.applications(), nil
+}
+
+// fetch the applications from the first server that responds
+func (c *client) fetch(path string) (*applications, error) {
+	if len(c.servers) == 0 {
+		return nil, fmt.Errorf("no Eureka servers")
+	}
+
+	var errs error
+	for i := range c.servers {
+		server := (c.current + i) % len(c.servers)
+		apps, err := c.get(c.servers[server] + path)
+		if err == nil {
+			c.current = server
+			return apps, nil
+		}
+		errs = multierror.Append(errs, multierror.Prefix(err, c.servers[server]))
+	}
+	return nil, errs
+}
+
+func (c *client) get(url string) (*applications, error) {
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -109,9 +190,79 @@ func (c *client) Applications() ([]*application, error) {
 		return nil, err
 	}
 
-	return apps.Applications.Applications, nil
+	return &apps.Applications, nil
+}
+
+// apply the instances to the cache according to their action types
+func (c *client) apply(apps []*application) {
+	for _, app := range apps {
+		instances := c.apps[app.Name]
+		if instances == nil {
+			instances = make(map[string]*instance)
+			c.apps[app.Name] = instances
+		}
+		for _, inst := range app.Instances {
+			action := inst.ActionType
+			inst.ActionType = ""
+			switch action {
+			case actionDeleted:
+				delete(instances, instanceKey(inst))
+			case actionAdded, actionModified, "":
+				instances[instanceKey(inst)] = inst
+			default:
+				glog.Warningf("unsupported Eureka action type %q for instance %s", action, instanceKey(inst))
+			}
+		}
+		if len(instances) == 0 {
+			delete(c.apps, app.Name)
+		}
+	}
+}
+
+// hashCode of the cached applications, counting the instances by status in
+// the order of the status
+func (c *client) hashCode() string {
+	counts := make(map[string]int)
+	for _, instances := range c.apps {
+		for _, inst := range instances {
+			counts[inst.Status]++
+		}
+	}
+	statuses := make([]string, 0, len(counts))
+	for status := range counts {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+
+	out := ""
+	for _, status := range statuses {
+		out += fmt.Sprintf("%s_%d_", status, counts[status])
+	}
+	return out
+}
+
+// applications in the cache. The instances are shared with the cache and
+// must not be modified.
+func (c *client) applications() []*application {
+	out := make([]*application, 0, len(c.apps))
+	for name, instances := range c.apps {
+		app := &application{Name: name, Instances: make([]*instance, 0, len(instances))}
+		for _, inst := range instances {
+			app.Instances = append(app.Instances, inst)
+		}
+		out = append(out, app)
+	}
+	sortApplications(out)
+	return out
 }
 
+// instanceKey identifies an instance within its application
+func instanceKey(inst *instance) string {
+	if inst.InstanceID != "" {
+		return inst.InstanceID
+	}
+	return fmt.Sprintf("%s-%s-%d", inst.Hostname, inst.IPAddress, inst.Port.Port)
+}
 func sortApplications(apps []*application) {
 	sort.Slice(apps, func(i, j int) bool { return apps[i].Name < apps[j].Name })
 	for _, app := range apps {
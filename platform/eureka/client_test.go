@@ -15,9 +15,12 @@
 package eureka
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"sync"
 	"testing"
 )
 
@@ -94,3 +97,111 @@ func TestClient(t *testing.T) {
 		ts.Close()
 	}
 }
+
+func TestClientDelta(t *testing.T) {
+	var (
+		mutex    sync.Mutex
+		full     applications
+		delta    applications
+		requests []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		requests = append(requests, r.URL.Path)
+		var data getApplications
+		switch r.URL.Path {
+		case appsPath:
+			data.Applications = full
+		case deltaPath:
+			data.Applications = delta
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(data) // nolint: errcheck
+	}))
+	defer ts.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	// the client fails over to the second server
+	cl := NewClient(down.URL, ts.URL)
+
+	hostname := "foo.bar.local"
+	withStatus := func(inst *instance, status, action string) *instance {
+		inst.Status = status
+		inst.ActionType = action
+		return inst
+	}
+	update := func(f, d applications) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		full, delta, requests = f, d, nil
+	}
+	expect := func(context string, paths []string, apps []*application) {
+		out, err := cl.Applications()
+		if err != nil {
+			t.Fatalf("unexpected error retrieving Eureka applications for %s context: %v", context, err)
+		}
+		if err = compare(t, out, apps); err != nil {
+			t.Errorf("retrieved Eureka applications do not match expected for %s context:\n%v", context, err)
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		if !reflect.DeepEqual(requests, paths) {
+			t.Errorf("requests for %s context => %v, want %v", context, requests, paths)
+		}
+	}
+
+	update(applications{
+		HashCode: "UP_2_",
+		Applications: []*application{{
+			Name: appName(hostname),
+			Instances: []*instance{
+				makeInstance(hostname, "10.0.0.1", 5000, -1, nil),
+				makeInstance(hostname, "10.0.0.2", 5000, -1, nil),
+			},
+		}},
+	}, applications{})
+	expect("initial fetch", []string{appsPath}, []*application{{
+		Name: appName(hostname),
+		Instances: []*instance{
+			makeInstance(hostname, "10.0.0.1", 5000, -1, nil),
+			makeInstance(hostname, "10.0.0.2", 5000, -1, nil),
+		},
+	}})
+
+	update(applications{}, applications{
+		HashCode: "OUT_OF_SERVICE_1_UP_1_",
+		Applications: []*application{{
+			Name: appName(hostname),
+			Instances: []*instance{
+				withStatus(makeInstance(hostname, "10.0.0.1", 5000, -1, nil), statusUp, actionDeleted),
+				withStatus(makeInstance(hostname, "10.0.0.2", 5000, -1, nil), statusOutOfService, actionModified),
+				withStatus(makeInstance(hostname, "10.0.0.3", 5000, -1, nil), statusUp, actionAdded),
+			},
+		}},
+	})
+	expect("delta", []string{deltaPath}, []*application{{
+		Name: appName(hostname),
+		Instances: []*instance{
+			withStatus(makeInstance(hostname, "10.0.0.2", 5000, -1, nil), statusOutOfService, ""),
+			makeInstance(hostname, "10.0.0.3", 5000, -1, nil),
+		},
+	}})
+
+	update(applications{
+		HashCode: "UP_1_",
+		Applications: []*application{{
+			Name:      appName(hostname),
+			Instances: []*instance{makeInstance(hostname, "10.0.0.4", 5000, -1, nil)},
+		}},
+	}, applications{HashCode: "UP_3_"})
+	expect("hash code mismatch", []string{deltaPath, appsPath}, []*application{{
+		Name:      appName(hostname),
+		Instances: []*instance{makeInstance(hostname, "10.0.0.4", 5000, -1, nil)},
+	}})
+}
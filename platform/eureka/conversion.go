@@ -24,7 +24,8 @@ import (
 )
 
 // Convert Eureka applications to services. If provided, only convert applications in the hostnames whitelist,
-// otherwise convert all.
+// otherwise convert all. Instances that are starting or out of service still declare their services, so that the
+// services remain while their instances are drained.
 func convertServices(apps []*application, hostnames map[string]bool) map[string]*model.Service {
 	services := make(map[string]*model.Service)
 	for _, app := range apps {
@@ -33,7 +34,7 @@ func convertServices(apps []*application, hostnames map[string]bool) map[string]
 				continue
 			}
 
-			if instance.Status != statusUp {
+			if !declaresService(instance.Status) {
 				continue
 			}
 
@@ -74,7 +75,8 @@ func convertServices(apps []*application, hostnames map[string]bool) map[string]
 }
 
 // Convert Eureka applications to service instances. The services argument must contain a map of hostnames to
-// services. Only service instances with a corresponding service are converted.
+// services. Only service instances with a corresponding service are converted, and only the instances that are UP
+// receive traffic.
 func convertServiceInstances(services map[string]*model.Service, apps []*application) []*model.ServiceInstance {
 	out := make([]*model.ServiceInstance, 0)
 	for _, app := range apps {
@@ -94,8 +96,10 @@ func convertServiceInstances(services map[string]*model.Service, apps []*applica
 						Port:        port.Port,
 						ServicePort: port,
 					},
-					Service: services[instance.Hostname],
-					Tags:    convertTags(instance.Metadata),
+					Service:          services[instance.Hostname],
+					Tags:             convertTags(instance.Metadata),
+					AvailabilityZone: convertAvailabilityZone(instance.DataCenterInfo),
+					ServiceAccount:   convertServiceAccount(instance.Metadata),
 				})
 			}
 		}
@@ -103,6 +107,26 @@ func convertServiceInstances(services map[string]*model.Service, apps []*applica
 	return out
 }
 
+// declaresService checks that an instance with the status defines its service
+func declaresService(status string) bool {
+	switch status {
+	case statusUp, statusStarting, statusOutOfService:
+		return true
+	default:
+		return false
+	}
+}
+
+// availabilityZoneMetadata is the data center info metadata key for the AZ of Amazon instances
+const availabilityZoneMetadata = "availability-zone"
+
+func convertAvailabilityZone(info *dataCenterInfo) string {
+	if info == nil {
+		return ""
+	}
+	return info.Metadata[availabilityZoneMetadata]
+}
+
 func convertPorts(instance *instance) model.PortList {
 	out := make(model.PortList, 0, 2) // Eureka instances have 0..2 enabled ports
 	protocol := convertProtocol(instance.Metadata)
@@ -122,6 +146,24 @@ func convertPorts(instance *instance) model.PortList {
 
 const protocolMetadata = "istio.protocol" // metadata key for port protocol
 
+// serviceAccountMetadata is the metadata key for the Istio service account of
+// the instance, either the name of the service account, e.g. "productpage", or
+// its complete URI, e.g. "spiffe://cluster.local/ns/default/sa/productpage"
+const serviceAccountMetadata = "istio.serviceaccount"
+
+// serviceAccountNamespaceMetadata is the metadata key for the namespace of the
+// service account name, defaultNamespace if unset
+const serviceAccountNamespaceMetadata = "istio.serviceaccount.namespace"
+
+// istioURIPrefix is the URI prefix in the Istio service account scheme
+const istioURIPrefix = "spiffe"
+
+// defaultTrustDomain is the trust domain of the service account names
+const defaultTrustDomain = "cluster.local"
+
+// defaultNamespace is the namespace of the service account names
+const defaultNamespace = "default"
+
 // supported protocol metadata values
 const (
 	metadataUDP   = "udp"
@@ -165,7 +207,25 @@ func convertTags(metadata metadata) model.Tags {
 
 	// filter out special tags
 	delete(tags, protocolMetadata)
+	delete(tags, serviceAccountMetadata)
+	delete(tags, serviceAccountNamespaceMetadata)
 	delete(tags, "@class")
 
 	return tags
 }
+
+// convertServiceAccount converts the service account in the metadata to an
+// Istio service account URI like the Kubernetes service accounts, e.g.
+// "productpage" to "spiffe://cluster.local/ns/default/sa/productpage". URIs
+// are kept.
+func convertServiceAccount(md metadata) string {
+	name := md[serviceAccountMetadata]
+	if name == "" || strings.HasPrefix(name, istioURIPrefix+"://") {
+		return name
+	}
+	namespace := md[serviceAccountNamespaceMetadata]
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	return fmt.Sprintf("%v://%v/ns/%v/sa/%v", istioURIPrefix, defaultTrustDomain, namespace, name)
+}
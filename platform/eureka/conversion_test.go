@@ -177,6 +177,35 @@ func TestConvertServiceInstances(t *testing.T) {
 	}
 }
 
+func TestConvertServiceInstancesStatus(t *testing.T) {
+	hostname := "foo.bar.local"
+	up := makeInstance(hostname, "10.0.0.1", 5000, -1, nil)
+	up.DataCenterInfo = &dataCenterInfo{Name: "Amazon", Metadata: metadata{availabilityZoneMetadata: "us-east-1a"}}
+	apps := []*application{
+		{
+			Name:      appName(hostname),
+			Instances: []*instance{up},
+		},
+	}
+	for i, status := range []string{statusOutOfService, statusStarting, "DOWN", "UNKNOWN"} {
+		inst := makeInstance(hostname, fmt.Sprintf("10.0.1.%d", i), 6000, -1, nil)
+		inst.Status = status
+		apps[0].Instances = append(apps[0].Instances, inst)
+	}
+
+	services := convertServices(apps, nil)
+	service := makeService(hostname, []int{5000, 6000}, nil)
+	if err := compare(t, services, map[string]*model.Service{hostname: service}); err != nil {
+		t.Error(err)
+	}
+
+	expected := makeServiceInstance(service, "10.0.0.1", 5000, nil)
+	expected.AvailabilityZone = "us-east-1a"
+	if err := compare(t, convertServiceInstances(services, apps), []*model.ServiceInstance{expected}); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestConvertProtocol(t *testing.T) {
 	makeMetadata := func(protocol string) metadata {
 		return metadata{
@@ -243,9 +272,10 @@ func appName(hostname string) string {
 
 func makeInstance(hostname, ip string, portNum, securePort int, md metadata) *instance {
 	inst := &instance{
-		Hostname:  hostname,
-		IPAddress: ip,
-		Status:    statusUp,
+		InstanceID: fmt.Sprintf("%s-%s-%d", hostname, ip, portNum),
+		Hostname:   hostname,
+		IPAddress:  ip,
+		Status:     statusUp,
 		Port: port{
 			Port:    7002,
 			Enabled: false,
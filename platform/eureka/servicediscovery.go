@@ -15,13 +15,22 @@
 package eureka
 
 import (
+	"sort"
+
 	"github.com/golang/glog"
 
 	"istio.io/pilot/model"
 )
 
+// ServiceDiscovery is the Eureka service discovery with the service accounts
+// of the service instances
+type ServiceDiscovery interface {
+	model.ServiceDiscovery
+	model.ServiceAccounts
+}
+
 // NewServiceDiscovery instantiates an implementation of service discovery for Eureka
-func NewServiceDiscovery(client Client) model.ServiceDiscovery {
+func NewServiceDiscovery(client Client) ServiceDiscovery {
 	return &serviceDiscovery{
 		client: client,
 	}
@@ -114,3 +123,21 @@ func (sd *serviceDiscovery) HostInstances(addrs map[string]bool) []*model.Servic
 func (sd *serviceDiscovery) ManagementPorts(addr string) model.PortList {
 	return nil
 }
+
+// GetIstioServiceAccounts implements model.ServiceAccounts operation. The
+// service accounts are taken from the metadata of the service instances.
+func (sd *serviceDiscovery) GetIstioServiceAccounts(hostname string, ports []string) []string {
+	saSet := make(map[string]bool)
+	for _, instance := range sd.Instances(hostname, ports, model.TagsList{}) {
+		if instance.ServiceAccount != "" {
+			saSet[instance.ServiceAccount] = true
+		}
+	}
+
+	out := make([]string, 0, len(saSet))
+	for sa := range saSet {
+		out = append(out, sa)
+	}
+	sort.Strings(out)
+	return out
+}
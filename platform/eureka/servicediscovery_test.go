@@ -181,6 +181,33 @@ func TestServiceDiscoveryInstances(t *testing.T) {
 	}
 }
 
+func TestServiceDiscoveryGetIstioServiceAccounts(t *testing.T) {
+	hostname := "foo.default.svc.local"
+	uri := "spiffe://cluster.local/ns/default/sa/foo"
+	cl := &mockClient{
+		{
+			Name: appName(hostname),
+			Instances: []*instance{
+				makeInstance(hostname, "10.0.0.1", 9090, -1, metadata{serviceAccountMetadata: "foo"}),
+				makeInstance(hostname, "10.0.0.2", 9090, -1, metadata{serviceAccountMetadata: uri}),
+				makeInstance(hostname, "10.0.0.3", 9090, -1, metadata{serviceAccountMetadata: "foo"}),
+				makeInstance(hostname, "10.0.0.4", 9090, -1, nil),
+				makeInstance(hostname, "10.0.0.5", 9090, -1, metadata{
+					serviceAccountMetadata:          "foo",
+					serviceAccountNamespaceMetadata: "prod",
+				}),
+			},
+		},
+	}
+	sd := NewServiceDiscovery(cl)
+
+	sa := sd.GetIstioServiceAccounts(hostname, []string{})
+	expected := []string{uri, "spiffe://cluster.local/ns/prod/sa/foo"}
+	if err := compare(t, sa, expected); err != nil {
+		t.Error(err)
+	}
+}
+
 func sortServices(services []*model.Service) {
 	sort.Slice(services, func(i, j int) bool { return services[i].Hostname < services[j].Hostname })
 	for _, service := range services {
@@ -15,21 +15,24 @@
 package kube
 
 import (
+	"sync"
+
 	"k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
 
 	"istio.io/pilot/model"
 )
 
 // PodCache is an eventually consistent pod cache
 type PodCache struct {
-	cacheHandler
+	*cacheHandler
 
 	// keys maintains stable pod IP to name key mapping
 	// this allows us to retrieve the latest status by pod IP
 	keys map[string]string
 }
 
-func newPodCache(ch cacheHandler) *PodCache {
+func newPodCache(ch *cacheHandler) *PodCache {
 	out := &PodCache{
 		cacheHandler: ch,
 		keys:         make(map[string]string),
@@ -57,7 +60,11 @@ func (pc *PodCache) getPodByIP(addr string) (*v1.Pod, bool) {
 	if !exists {
 		return nil, false
 	}
-	item, exists, err := pc.informer.GetStore().GetByKey(key)
+	namespace, _, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return nil, false
+	}
+	item, exists, err := pc.getByKey(namespace, key)
 	if !exists || err != nil {
 		return nil, false
 	}
@@ -72,3 +79,45 @@ func (pc *PodCache) tagsByIP(addr string) (model.Tags, bool) {
 	}
 	return convertTags(pod.ObjectMeta), true
 }
+
+// serviceCache memoizes the conversions of the services by the service key.
+// A conversion is valid for the informer object it was converted from, since
+// the informer replaces the objects on updates rather than modifying them.
+type serviceCache struct {
+	mutex    sync.RWMutex
+	services map[string]cachedService
+}
+
+type cachedService struct {
+	source  *v1.Service
+	service *model.Service
+}
+
+func newServiceCache() *serviceCache {
+	return &serviceCache{services: make(map[string]cachedService)}
+}
+
+// get returns the conversion of the service, nil if the service can not be
+// converted. The returned service is shared and must not be modified.
+func (sc *serviceCache) get(svc *v1.Service, domainSuffix string) *model.Service {
+	key := KeyFunc(svc.Name, svc.Namespace)
+	sc.mutex.RLock()
+	cached, exists := sc.services[key]
+	sc.mutex.RUnlock()
+	if exists && cached.source == svc {
+		return cached.service
+	}
+
+	out := convertService(*svc, domainSuffix)
+	sc.mutex.Lock()
+	sc.services[key] = cachedService{source: svc, service: out}
+	sc.mutex.Unlock()
+	return out
+}
+
+// invalidate drops the conversion of the service
+func (sc *serviceCache) invalidate(name, namespace string) {
+	sc.mutex.Lock()
+	delete(sc.services, KeyFunc(name, namespace))
+	sc.mutex.Unlock()
+}
@@ -76,7 +76,7 @@ func TestPodCache(t *testing.T) {
 
 			// Populate podCache
 			for _, pod := range c.pods {
-				if err := controller.pods.informer.GetStore().Add(pod); err != nil {
+				if err := controller.pods.store(pod.Namespace).Add(pod); err != nil {
 					t.Errorf("Cannot create %s in namespace %s (error: %v)", pod.ObjectMeta.Name, pod.ObjectMeta.Namespace, err)
 				}
 			}
@@ -19,6 +19,7 @@ import (
 	"crypto/tls"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/golang/glog"
@@ -73,6 +74,46 @@ func CreateInterface(kubeconfig string) (*rest.Config, kubernetes.Interface, err
 	return config, client, err
 }
 
+// CreateClusters creates the clients of the clusters whose kubeconfigs are
+// stored in the secret, keyed by the cluster names, in order of the names.
+// The clusters with an invalid kubeconfig are skipped with a warning.
+func CreateClusters(client kubernetes.Interface, name, namespace string) ([]Cluster, error) {
+	secret, err := client.CoreV1().Secrets(namespace).Get(name, meta_v1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(secret.Data))
+	for cluster := range secret.Data {
+		names = append(names, cluster)
+	}
+	sort.Strings(names)
+
+	out := make([]Cluster, 0, len(names))
+	for _, cluster := range names {
+		clusterClient, err := createInterfaceFromKubeconfig(secret.Data[cluster])
+		if err != nil {
+			glog.Warningf("Skipping cluster %q of secret %s/%s: %v", cluster, namespace, name, err)
+			continue
+		}
+		out = append(out, Cluster{Name: cluster, Client: clusterClient})
+	}
+	return out, nil
+}
+
+// createInterfaceFromKubeconfig creates a Kubernetes interface from the content of a kubeconfig
+func createInterfaceFromKubeconfig(kubeconfig []byte) (kubernetes.Interface, error) {
+	config, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	restConfig, err := clientcmd.NewDefaultClientConfig(*config, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(restConfig)
+}
+
 const (
 	secretCert = "tls.crt"
 	secretKey  = "tls.key"
@@ -22,6 +22,7 @@ import (
 
 	"k8s.io/api/core/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 
 	"istio.io/pilot/test/util"
 )
@@ -31,6 +32,42 @@ const (
 	controllerKeyFile  = "testdata/cert.key"
 )
 
+const clusterKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: east
+  cluster:
+    server: https://east.example.com
+contexts:
+- name: east
+  context:
+    cluster: east
+current-context: east
+`
+
+func TestCreateClusters(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "clusters", Namespace: "istio-system"},
+		Data: map[string][]byte{
+			"west": []byte("not a kubeconfig"),
+			"east": []byte(clusterKubeconfig),
+		},
+	})
+
+	// the invalid kubeconfig does not prevent the other clusters from starting
+	clusters, err := CreateClusters(client, "clusters", "istio-system")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 1 || clusters[0].Name != "east" || clusters[0].Client == nil {
+		t.Errorf("CreateClusters() => %v, want the east cluster", clusters)
+	}
+
+	if _, err = CreateClusters(client, "missing", "istio-system"); err == nil {
+		t.Errorf("CreateClusters() => no error for a missing secret")
+	}
+}
+
 func TestSecret(t *testing.T) {
 	cl := makeClient(t)
 	t.Parallel()
@@ -18,6 +18,8 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
@@ -39,15 +41,28 @@ const (
 	NodeZoneLabel = "failure-domain.beta.kubernetes.io/zone"
 	// IstioNamespace used by default for Istio cluster-wide installation
 	IstioNamespace = "istio-system"
+
+	// endpointIPIndex indexes the endpoints by their addresses
+	endpointIPIndex = "ip"
 )
 
 // ControllerOptions stores the configurable attributes of a Controller.
 type ControllerOptions struct {
 	// Namespace to restrict controller to (empty to disable restriction)
-	Namespace    string
+	Namespace string
+	// AppNamespace restricts the applications the controller manages to a
+	// namespace (empty to disable restriction)
 	AppNamespace string
-	ResyncPeriod time.Duration
-	DomainSuffix string
+	// WatchedNamespaces restricts the services, endpoints and pods to the
+	// namespaces in the list, and takes precedence over AppNamespace
+	WatchedNamespaces []string
+	// NamespaceSelector restricts the services, endpoints and pods to the
+	// namespaces matching the label selector, followed as namespaces are
+	// created, labeled and deleted. Following the namespaces requires cluster-wide
+	// permissions to list and watch namespaces.
+	NamespaceSelector string
+	ResyncPeriod      time.Duration
+	DomainSuffix      string
 }
 
 // Controller is a collection of synchronized resource watchers
@@ -58,16 +73,20 @@ type Controller struct {
 
 	client    kubernetes.Interface
 	queue     Queue
-	services  cacheHandler
-	endpoints cacheHandler
-	nodes     cacheHandler
+	services  *cacheHandler
+	endpoints *cacheHandler
+	nodes     *cacheHandler
+
+	// namespaces follows the namespaces matching the namespace selector, or
+	// is nil if the watched namespaces are fixed
+	namespaces *cacheHandler
+	// watchedNamespaces restricts the namespaces to watch, if not empty
+	watchedNamespaces map[string]bool
 
 	pods *PodCache
-}
 
-type cacheHandler struct {
-	informer cache.SharedIndexInformer
-	handler  *ChainHandler
+	// serviceCache memoizes the service conversions
+	serviceCache *serviceCache
 }
 
 // NewController creates a new Kubernetes controller
@@ -79,43 +98,131 @@ func NewController(client kubernetes.Interface, mesh *proxyconfig.ProxyMeshConfi
 		domainSuffix: options.DomainSuffix,
 		client:       client,
 		queue:        NewQueue(1 * time.Second),
+		serviceCache: newServiceCache(),
 	}
 
-	out.services = out.createInformer(&v1.Service{}, options.ResyncPeriod,
-		func(opts meta_v1.ListOptions) (runtime.Object, error) {
-			return client.CoreV1().Services(meta_v1.NamespaceAll).List(opts)
-		},
-		func(opts meta_v1.ListOptions) (watch.Interface, error) {
-			return client.CoreV1().Services(meta_v1.NamespaceAll).Watch(opts)
+	out.services = out.createInformer(&v1.Service{}, options.ResyncPeriod, cache.Indexers{},
+		func(namespace string) *cache.ListWatch {
+			return &cache.ListWatch{
+				ListFunc: func(opts meta_v1.ListOptions) (runtime.Object, error) {
+					return client.CoreV1().Services(namespace).List(opts)
+				},
+				WatchFunc: func(opts meta_v1.ListOptions) (watch.Interface, error) {
+					return client.CoreV1().Services(namespace).Watch(opts)
+				},
+			}
 		})
 
 	out.endpoints = out.createInformer(&v1.Endpoints{}, options.ResyncPeriod,
-		func(opts meta_v1.ListOptions) (runtime.Object, error) {
-			return client.CoreV1().Endpoints(meta_v1.NamespaceAll).List(opts)
-		},
-		func(opts meta_v1.ListOptions) (watch.Interface, error) {
-			return client.CoreV1().Endpoints(meta_v1.NamespaceAll).Watch(opts)
+		cache.Indexers{endpointIPIndex: endpointIPs},
+		func(namespace string) *cache.ListWatch {
+			return &cache.ListWatch{
+				ListFunc: func(opts meta_v1.ListOptions) (runtime.Object, error) {
+					return client.CoreV1().Endpoints(namespace).List(opts)
+				},
+				WatchFunc: func(opts meta_v1.ListOptions) (watch.Interface, error) {
+					return client.CoreV1().Endpoints(namespace).Watch(opts)
+				},
+			}
 		})
 
-	out.nodes = out.createInformer(&v1.Node{}, options.ResyncPeriod,
-		func(opts meta_v1.ListOptions) (runtime.Object, error) {
-			return client.CoreV1().Nodes().List(opts)
-		},
-		func(opts meta_v1.ListOptions) (watch.Interface, error) {
-			return client.CoreV1().Nodes().Watch(opts)
+	out.nodes = out.createInformer(&v1.Node{}, options.ResyncPeriod, cache.Indexers{},
+		func(_ string) *cache.ListWatch {
+			return &cache.ListWatch{
+				ListFunc: func(opts meta_v1.ListOptions) (runtime.Object, error) {
+					return client.CoreV1().Nodes().List(opts)
+				},
+				WatchFunc: func(opts meta_v1.ListOptions) (watch.Interface, error) {
+					return client.CoreV1().Nodes().Watch(opts)
+				},
+			}
 		})
-
-	out.pods = newPodCache(out.createInformer(&v1.Pod{}, options.ResyncPeriod,
-		func(opts meta_v1.ListOptions) (runtime.Object, error) {
-			return client.CoreV1().Pods(meta_v1.NamespaceAll).List(opts)
-		},
-		func(opts meta_v1.ListOptions) (watch.Interface, error) {
-			return client.CoreV1().Pods(meta_v1.NamespaceAll).Watch(opts)
+	out.nodes.watch(meta_v1.NamespaceAll)
+
+	out.pods = newPodCache(out.createInformer(&v1.Pod{}, options.ResyncPeriod, cache.Indexers{},
+		func(namespace string) *cache.ListWatch {
+			return &cache.ListWatch{
+				ListFunc: func(opts meta_v1.ListOptions) (runtime.Object, error) {
+					return client.CoreV1().Pods(namespace).List(opts)
+				},
+				WatchFunc: func(opts meta_v1.ListOptions) (watch.Interface, error) {
+					return client.CoreV1().Pods(namespace).Watch(opts)
+				},
+			}
 		}))
 
+	namespaces := options.WatchedNamespaces
+	if len(namespaces) == 0 && options.AppNamespace != "" {
+		namespaces = []string{options.AppNamespace}
+	}
+
+	if options.NamespaceSelector != "" {
+		out.watchedNamespaces = make(map[string]bool)
+		for _, namespace := range namespaces {
+			out.watchedNamespaces[namespace] = true
+		}
+
+		// the namespaces that stop matching the selector are deleted from the watch
+		out.namespaces = out.createInformer(&v1.Namespace{}, options.ResyncPeriod, cache.Indexers{},
+			func(_ string) *cache.ListWatch {
+				return &cache.ListWatch{
+					ListFunc: func(opts meta_v1.ListOptions) (runtime.Object, error) {
+						opts.LabelSelector = options.NamespaceSelector
+						return client.CoreV1().Namespaces().List(opts)
+					},
+					WatchFunc: func(opts meta_v1.ListOptions) (watch.Interface, error) {
+						opts.LabelSelector = options.NamespaceSelector
+						return client.CoreV1().Namespaces().Watch(opts)
+					},
+				}
+			})
+		out.namespaces.watch(meta_v1.NamespaceAll)
+		out.namespaces.handler.Append(func(obj interface{}, event model.Event) error {
+			ns := obj.(*v1.Namespace)
+			switch event {
+			case model.EventAdd, model.EventUpdate:
+				out.watchNamespace(ns.Name)
+			case model.EventDelete:
+				out.unwatchNamespace(ns.Name)
+			}
+			return nil
+		})
+	} else if len(namespaces) == 0 {
+		out.watchNamespace(meta_v1.NamespaceAll)
+	} else {
+		for _, namespace := range namespaces {
+			out.watchNamespace(namespace)
+		}
+	}
+
+	out.services.handler.Append(func(obj interface{}, _ model.Event) error {
+		svc := obj.(*v1.Service)
+		out.serviceCache.invalidate(svc.Name, svc.Namespace)
+		return nil
+	})
+
 	return out
 }
 
+// endpointIPs is the index function for the addresses of the endpoints
+func endpointIPs(obj interface{}) ([]string, error) {
+	ep, ok := obj.(*v1.Endpoints)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object %T", obj)
+	}
+	seen := make(map[string]bool)
+	var out []string
+	for _, ss := range ep.Subsets {
+		for _, ea := range ss.Addresses {
+			if !seen[ea.IP] {
+				seen[ea.IP] = true
+				out = append(out, ea.IP)
+			}
+		}
+	}
+	return out, nil
+}
+
 // notify is the first handler in the handler chain.
 // Returning an error causes repeated execution of the entire chain.
 func (c *Controller) notify(obj interface{}, event model.Event) error {
@@ -134,64 +241,102 @@ func (c *Controller) notify(obj interface{}, event model.Event) error {
 func (c *Controller) createInformer(
 	o runtime.Object,
 	resyncPeriod time.Duration,
-	lf cache.ListFunc,
-	wf cache.WatchFunc) cacheHandler {
+	indexers cache.Indexers,
+	lw func(namespace string) *cache.ListWatch) *cacheHandler {
 	handler := &ChainHandler{funcs: []Handler{c.notify}}
 
-	// TODO: finer-grained index (perf)
-	informer := cache.NewSharedIndexInformer(
-		&cache.ListWatch{ListFunc: lf, WatchFunc: wf}, o,
-		resyncPeriod, cache.Indexers{})
-
-	informer.AddEventHandler(
-		cache.ResourceEventHandlerFuncs{
-			// TODO: filtering functions to skip over un-referenced resources (perf)
-			AddFunc: func(obj interface{}) {
-				c.queue.Push(Task{handler: handler.Apply, obj: obj, event: model.EventAdd})
-			},
-			UpdateFunc: func(old, cur interface{}) {
-				if !reflect.DeepEqual(old, cur) {
-					c.queue.Push(Task{handler: handler.Apply, obj: cur, event: model.EventUpdate})
-				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				c.queue.Push(Task{handler: handler.Apply, obj: obj, event: model.EventDelete})
-			},
-		})
+	return newCacheHandler(handler, func(namespace string) cache.SharedIndexInformer {
+		informer := cache.NewSharedIndexInformer(lw(namespace), o, resyncPeriod, indexers)
+
+		informer.AddEventHandler(
+			cache.ResourceEventHandlerFuncs{
+				// TODO: filtering functions to skip over un-referenced resources (perf)
+				AddFunc: func(obj interface{}) {
+					c.queue.Push(Task{handler: handler.Apply, obj: obj, event: model.EventAdd})
+				},
+				UpdateFunc: func(old, cur interface{}) {
+					if !reflect.DeepEqual(old, cur) {
+						c.queue.Push(Task{handler: handler.Apply, obj: cur, event: model.EventUpdate})
+					}
+				},
+				DeleteFunc: func(obj interface{}) {
+					c.queue.Push(Task{handler: handler.Apply, obj: obj, event: model.EventDelete})
+				},
+			})
+
+		return informer
+	})
+}
 
-	return cacheHandler{informer: informer, handler: handler}
+// watchNamespace starts watching the services, endpoints and pods in the namespace
+func (c *Controller) watchNamespace(namespace string) {
+	if len(c.watchedNamespaces) > 0 && !c.watchedNamespaces[namespace] {
+		return
+	}
+	c.services.watch(namespace)
+	c.endpoints.watch(namespace)
+	c.pods.watch(namespace)
+}
+
+// unwatchNamespace stops watching the services, endpoints and pods in the
+// namespace, and notifies the handlers of their deletion. The endpoints are
+// deleted before their services.
+func (c *Controller) unwatchNamespace(namespace string) {
+	for _, h := range []*cacheHandler{c.endpoints, c.services, c.pods.cacheHandler} {
+		for _, obj := range h.unwatch(namespace) {
+			c.queue.Push(Task{handler: h.handler.Apply, obj: obj, event: model.EventDelete})
+		}
+	}
 }
 
 // HasSynced returns true after the initial state synchronization
 func (c *Controller) HasSynced() bool {
-	if !c.services.informer.HasSynced() ||
-		!c.endpoints.informer.HasSynced() ||
-		!c.pods.informer.HasSynced() {
+	if c.namespaces != nil && !c.namespaces.hasSynced() {
+		return false
+	}
+	if !c.services.hasSynced() ||
+		!c.endpoints.hasSynced() ||
+		!c.pods.hasSynced() {
 		return false
 	}
 
 	return true
 }
 
+// WatchedNamespaces lists the namespaces currently watched for endpoints,
+// which is meta_v1.NamespaceAll if the controller watches all namespaces
+func (c *Controller) WatchedNamespaces() []string {
+	return c.endpoints.namespaces()
+}
+
 // Run all controllers until a signal is received
 func (c *Controller) Run(stop <-chan struct{}) {
 	go c.queue.Run(stop)
-	go c.services.informer.Run(stop)
-	go c.endpoints.informer.Run(stop)
-	go c.pods.informer.Run(stop)
+	if c.namespaces != nil {
+		go c.namespaces.run(stop)
+	}
+	go c.services.run(stop)
+	go c.endpoints.run(stop)
+	go c.pods.run(stop)
 
 	<-stop
 	glog.V(2).Info("Controller terminated")
 }
 
-// Services implements a service catalog operation
+// Services implements a service catalog operation. The endpoints of the
+// headless services are listed as services at the endpoint addresses.
 func (c *Controller) Services() []*model.Service {
-	list := c.services.informer.GetStore().List()
+	list := c.services.list()
 	out := make([]*model.Service, 0, len(list))
 
 	for _, item := range list {
-		if svc := convertService(*item.(*v1.Service), c.domainSuffix); svc != nil {
-			out = append(out, svc)
+		svc := c.serviceCache.get(item.(*v1.Service), c.domainSuffix)
+		if svc == nil {
+			continue
+		}
+		out = append(out, svc)
+		if svc.Address == "" && !svc.External() {
+			out = append(out, c.endpointServices(item.(*v1.Service), svc)...)
 		}
 	}
 	return out
@@ -199,7 +344,7 @@ func (c *Controller) Services() []*model.Service {
 
 // GetService implements a service catalog operation
 func (c *Controller) GetService(hostname string) (*model.Service, bool) {
-	name, namespace, err := parseHostname(hostname)
+	name, namespace, endpoint, err := c.parseHostname(hostname)
 	if err != nil {
 		glog.V(2).Infof("GetService(%s) => error %v", hostname, err)
 		return nil, false
@@ -209,13 +354,75 @@ func (c *Controller) GetService(hostname string) (*model.Service, bool) {
 		return nil, false
 	}
 
-	svc := convertService(*item, c.domainSuffix)
+	svc := c.serviceCache.get(item, c.domainSuffix)
+	if svc != nil && endpoint != "" {
+		svc, _ = c.endpointService(item, svc, endpoint)
+	}
 	return svc, svc != nil
 }
 
+// parseHostname extracts the service name and namespace from the hostname,
+// and the endpoint name for the endpoints of the headless services, e.g.
+// "kafka-0" in "kafka-0.kafka.ns.svc.cluster.local"
+func (c *Controller) parseHostname(hostname string) (name, namespace, endpoint string, err error) {
+	suffix := ".svc." + c.domainSuffix
+	if !strings.HasSuffix(hostname, suffix) {
+		name, namespace, err = parseHostname(hostname)
+		return
+	}
+
+	parts := strings.Split(strings.TrimSuffix(hostname, suffix), ".")
+	switch len(parts) {
+	case 2:
+		name, namespace = parts[0], parts[1]
+	case 3:
+		endpoint, name, namespace = parts[0], parts[1], parts[2]
+	default:
+		err = fmt.Errorf("unexpected service hostname %q", hostname)
+	}
+	return
+}
+
+// endpointServices lists the services for the endpoints of a headless service
+// that have a hostname
+func (c *Controller) endpointServices(item *v1.Service, svc *model.Service) []*model.Service {
+	ep, exists := c.endpointsByKey(item.Name, item.Namespace)
+	if !exists {
+		return nil
+	}
+	var out []*model.Service
+	for _, ss := range ep.Subsets {
+		for _, ea := range ss.Addresses {
+			if ea.Hostname != "" {
+				out = append(out, endpointService(svc, ea))
+			}
+		}
+	}
+	return out
+}
+
+// endpointService retrieves the service for an endpoint of a headless service
+func (c *Controller) endpointService(item *v1.Service, svc *model.Service, endpoint string) (*model.Service, bool) {
+	if svc.Address != "" || svc.External() {
+		return nil, false
+	}
+	ep, exists := c.endpointsByKey(item.Name, item.Namespace)
+	if !exists {
+		return nil, false
+	}
+	for _, ss := range ep.Subsets {
+		for _, ea := range ss.Addresses {
+			if ea.Hostname != "" && ea.Hostname == endpoint {
+				return endpointService(svc, ea), true
+			}
+		}
+	}
+	return nil, false
+}
+
 // serviceByKey retrieves a service by name and namespace
 func (c *Controller) serviceByKey(name, namespace string) (*v1.Service, bool) {
-	item, exists, err := c.services.informer.GetStore().GetByKey(KeyFunc(name, namespace))
+	item, exists, err := c.services.getByKey(namespace, KeyFunc(name, namespace))
 	if err != nil {
 		glog.V(2).Infof("serviceByKey(%s, %s) => error %v", name, namespace, err)
 		return nil, false
@@ -226,11 +433,24 @@ func (c *Controller) serviceByKey(name, namespace string) (*v1.Service, bool) {
 	return item.(*v1.Service), true
 }
 
+// endpointsByKey retrieves the endpoints by name and namespace
+func (c *Controller) endpointsByKey(name, namespace string) (*v1.Endpoints, bool) {
+	item, exists, err := c.endpoints.getByKey(namespace, KeyFunc(name, namespace))
+	if err != nil {
+		glog.V(2).Infof("endpointsByKey(%s, %s) => error %v", name, namespace, err)
+		return nil, false
+	}
+	if !exists {
+		return nil, false
+	}
+	return item.(*v1.Endpoints), true
+}
+
 // GetPodAZ retrieves the AZ for a pod.
 func (c *Controller) GetPodAZ(pod *v1.Pod) (string, bool) {
 	// NodeName is set by the scheduler after the pod is created
 	// https://github.com/kubernetes/community/blob/master/contributors/devel/api-conventions.md#late-initialization
-	node, exists, err := c.nodes.informer.GetStore().GetByKey(pod.Spec.NodeName)
+	node, exists, err := c.nodes.getByKey(meta_v1.NamespaceAll, pod.Spec.NodeName)
 	if !exists || err != nil {
 		return "", false
 	}
@@ -267,7 +487,7 @@ func (c *Controller) ManagementPorts(addr string) model.PortList {
 // Instances implements a service catalog operation
 func (c *Controller) Instances(hostname string, ports []string, tagsList model.TagsList) []*model.ServiceInstance {
 	// Get actual service by name
-	name, namespace, err := parseHostname(hostname)
+	name, namespace, endpoint, err := c.parseHostname(hostname)
 	if err != nil {
 		glog.V(2).Infof("parseHostname(%s) => error %v", hostname, err)
 		return nil
@@ -279,10 +499,28 @@ func (c *Controller) Instances(hostname string, ports []string, tagsList model.T
 	}
 
 	// Locate all ports in the actual service
-	svc := convertService(*item, c.domainSuffix)
+	svc := c.serviceCache.get(item, c.domainSuffix)
 	if svc == nil {
 		return nil
 	}
+
+	// The endpoint of a headless service is the instance at the endpoint address
+	if endpoint != "" {
+		epSvc, exists := c.endpointService(item, svc, endpoint)
+		if !exists {
+			return nil
+		}
+		var out []*model.ServiceInstance
+		for _, instance := range c.Instances(svc.Hostname, ports, tagsList) {
+			if instance.Endpoint.Address == epSvc.Address {
+				epInstance := *instance
+				epInstance.Service = epSvc
+				out = append(out, &epInstance)
+			}
+		}
+		return out
+	}
+
 	svcPorts := make(map[string]*model.Port)
 	for _, port := range ports {
 		if svcPort, exists := svc.Ports.Get(port); exists {
@@ -290,90 +528,77 @@ func (c *Controller) Instances(hostname string, ports []string, tagsList model.T
 		}
 	}
 
-	// TODO: single port service missing name
-	for _, item := range c.endpoints.informer.GetStore().List() {
-		ep := *item.(*v1.Endpoints)
-		if ep.Name == name && ep.Namespace == namespace {
-			var out []*model.ServiceInstance
-			for _, ss := range ep.Subsets {
-				for _, ea := range ss.Addresses {
-					tags, _ := c.pods.tagsByIP(ea.IP)
-					// check that one of the input tags is a subset of the tags
-					if !tagsList.HasSubsetOf(tags) {
-						continue
-					}
+	ep, exists := c.endpointsByKey(name, namespace)
+	if !exists {
+		return nil
+	}
 
-					pod, exists := c.pods.getPodByIP(ea.IP)
-					az, sa := "", ""
-					if exists {
-						az, _ = c.GetPodAZ(pod)
-						sa = kubeToIstioServiceAccount(pod.Spec.ServiceAccountName, pod.GetNamespace(), c.domainSuffix)
-					}
+	// TODO: single port service missing name
+	var out []*model.ServiceInstance
+	for _, ss := range ep.Subsets {
+		for _, ea := range ss.Addresses {
+			tags, _ := c.pods.tagsByIP(ea.IP)
+			// check that one of the input tags is a subset of the tags
+			if !tagsList.HasSubsetOf(tags) {
+				continue
+			}
 
-					// identify the port by name
-					for _, port := range ss.Ports {
-						if svcPort, exists := svcPorts[port.Name]; exists {
-							out = append(out, &model.ServiceInstance{
-								Endpoint: model.NetworkEndpoint{
-									Address:     ea.IP,
-									Port:        int(port.Port),
-									ServicePort: svcPort,
-								},
-								Service:          svc,
-								Tags:             tags,
-								AvailabilityZone: az,
-								ServiceAccount:   sa,
-							})
-						}
-					}
+			// identify the port by name
+			for _, port := range ss.Ports {
+				if svcPort, exists := svcPorts[port.Name]; exists {
+					out = append(out, c.serviceInstance(svc, ea.IP, int(port.Port), svcPort, tags))
 				}
 			}
-			return out
 		}
 	}
-	return nil
+	return out
 }
 
 // HostInstances implements a service catalog operation
 func (c *Controller) HostInstances(addrs map[string]bool) []*model.ServiceInstance {
 	var out []*model.ServiceInstance
-	for _, item := range c.endpoints.informer.GetStore().List() {
-		ep := *item.(*v1.Endpoints)
-		for _, ss := range ep.Subsets {
-			for _, ea := range ss.Addresses {
-				if addrs[ea.IP] {
-					item, exists := c.serviceByKey(ep.Name, ep.Namespace)
-					if !exists {
-						continue
-					}
-					svc := convertService(*item, c.domainSuffix)
-					if svc == nil {
-						continue
-					}
-					for _, port := range ss.Ports {
-						svcPort, exists := svc.Ports.Get(port.Name)
-						if !exists {
-							continue
-						}
-						tags, _ := c.pods.tagsByIP(ea.IP)
-						pod, exists := c.pods.getPodByIP(ea.IP)
-						az, sa := "", ""
-						if exists {
-							az, _ = c.GetPodAZ(pod)
-							sa = kubeToIstioServiceAccount(pod.Spec.ServiceAccountName, pod.GetNamespace(), c.domainSuffix)
-						}
-						out = append(out, &model.ServiceInstance{
-							Endpoint: model.NetworkEndpoint{
-								Address:     ea.IP,
-								Port:        int(port.Port),
-								ServicePort: svcPort,
-							},
-							Service:          svc,
-							Tags:             tags,
-							AvailabilityZone: az,
-							ServiceAccount:   sa,
-						})
-					}
+	seen := make(map[string]bool)
+	for addr := range addrs {
+		items, err := c.endpoints.byIndex(endpointIPIndex, addr)
+		if err != nil {
+			glog.V(2).Infof("HostInstances(%s) => error %v", addr, err)
+			continue
+		}
+		for _, item := range items {
+			ep := item.(*v1.Endpoints)
+			key := KeyFunc(ep.Name, ep.Namespace)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			out = append(out, c.endpointInstances(ep, addrs)...)
+		}
+	}
+	return out
+}
+
+// endpointInstances lists the service instances of the endpoints for the
+// addresses, or for all the endpoint addresses if addrs is nil
+func (c *Controller) endpointInstances(ep *v1.Endpoints, addrs map[string]bool) []*model.ServiceInstance {
+	item, exists := c.serviceByKey(ep.Name, ep.Namespace)
+	if !exists {
+		return nil
+	}
+	svc := c.serviceCache.get(item, c.domainSuffix)
+	if svc == nil {
+		return nil
+	}
+
+	var out []*model.ServiceInstance
+	for _, ss := range ep.Subsets {
+		for _, ea := range ss.Addresses {
+			if addrs != nil && !addrs[ea.IP] {
+				continue
+			}
+			tags, _ := c.pods.tagsByIP(ea.IP)
+			for _, port := range ss.Ports {
+				if svcPort, exists := svc.Ports.Get(port.Name); exists {
+					out = append(out, c.serviceInstance(svc, ea.IP, int(port.Port), svcPort, tags))
 				}
 			}
 		}
@@ -381,6 +606,27 @@ func (c *Controller) HostInstances(addrs map[string]bool) []*model.ServiceInstan
 	return out
 }
 
+// serviceInstance builds the service instance at the endpoint address and port
+func (c *Controller) serviceInstance(svc *model.Service, addr string, port int, svcPort *model.Port,
+	tags model.Tags) *model.ServiceInstance {
+	az, sa := "", ""
+	if pod, exists := c.pods.getPodByIP(addr); exists {
+		az, _ = c.GetPodAZ(pod)
+		sa = kubeToIstioServiceAccount(pod.Spec.ServiceAccountName, pod.GetNamespace(), c.domainSuffix)
+	}
+	return &model.ServiceInstance{
+		Endpoint: model.NetworkEndpoint{
+			Address:     addr,
+			Port:        port,
+			ServicePort: svcPort,
+		},
+		Service:          svc,
+		Tags:             tags,
+		AvailabilityZone: az,
+		ServiceAccount:   sa,
+	}
+}
+
 // GetIstioServiceAccounts returns the Istio service accounts running a serivce
 // hostname. Each service account is encoded according to the SPIFFE VSID spec.
 // For example, a service account named "bar" in namespace "foo" is encoded as
@@ -436,10 +682,49 @@ func (c *Controller) AppendServiceHandler(f func(*model.Service, model.Event)) e
 	return nil
 }
 
-// AppendInstanceHandler implements a service catalog operation
+// AppendInstanceHandler implements a service catalog operation.
+// Endpoints are an aggregate of the service instances, so the handler is
+// notified once per instance added, removed or updated between the previous
+// and the current version of the endpoints. The instances also depend on the
+// service declaration, so the endpoints of a service are compared again when
+// the service changes, e.g. when the endpoints arrive before their service.
+// A change of N instances notifies the handler N times in a row, so handlers
+// doing expensive work per event, such as flushing a cache, pay it N times.
 func (c *Controller) AppendInstanceHandler(f func(*model.ServiceInstance, model.Event)) error {
+	// the queue applies the handlers sequentially, so the previous instances
+	// by endpoints key need no locking
+	previous := make(map[string]map[string]*model.ServiceInstance)
+	diff := func(ep *v1.Endpoints, event model.Event) {
+		key := KeyFunc(ep.Name, ep.Namespace)
+		current := make(map[string]*model.ServiceInstance)
+		if event != model.EventDelete {
+			for _, instance := range c.endpointInstances(ep, nil) {
+				current[instanceKey(instance)] = instance
+			}
+		}
+
+		for _, k := range sortedKeys(previous[key], current) {
+			old, had := previous[key][k]
+			instance, has := current[k]
+			switch {
+			case !had:
+				f(instance, model.EventAdd)
+			case !has:
+				f(old, model.EventDelete)
+			case !reflect.DeepEqual(old, instance):
+				f(instance, model.EventUpdate)
+			}
+		}
+
+		if len(current) == 0 {
+			delete(previous, key)
+		} else {
+			previous[key] = current
+		}
+	}
+
 	c.endpoints.handler.Append(func(obj interface{}, event model.Event) error {
-		ep := *obj.(*v1.Endpoints)
+		ep := obj.(*v1.Endpoints)
 
 		// Do not handle "kube-system" endpoints
 		if ep.Namespace == meta_v1.NamespaceSystem {
@@ -447,14 +732,44 @@ func (c *Controller) AppendInstanceHandler(f func(*model.ServiceInstance, model.
 		}
 
 		glog.V(2).Infof("Handle endpoint %s in namespace %s", ep.Name, ep.Namespace)
-		if item, exists := c.serviceByKey(ep.Name, ep.Namespace); exists {
-			if svc := convertService(*item, c.domainSuffix); svc != nil {
-				// TODO: we're passing an incomplete instance to the
-				// handler since endpoints is an aggregate structure
-				f(&model.ServiceInstance{Service: svc}, event)
-			}
+		diff(ep, event)
+		return nil
+	})
+
+	c.services.handler.Append(func(obj interface{}, event model.Event) error {
+		svc := obj.(*v1.Service)
+		if svc.Namespace == meta_v1.NamespaceSystem {
+			return nil
+		}
+
+		// the store already reflects the service event, so the instances of
+		// the current endpoints follow the current service declaration
+		if ep, exists := c.endpointsByKey(svc.Name, svc.Namespace); exists {
+			diff(ep, model.EventUpdate)
 		}
 		return nil
 	})
 	return nil
 }
+
+// instanceKey identifies a service instance by its endpoint and service port
+func instanceKey(instance *model.ServiceInstance) string {
+	return fmt.Sprintf("%s:%d/%s", instance.Endpoint.Address, instance.Endpoint.Port,
+		instance.Endpoint.ServicePort.Name)
+}
+
+// sortedKeys lists the union of the keys of the instance maps in order
+func sortedKeys(maps ...map[string]*model.ServiceInstance) []string {
+	set := make(map[string]bool)
+	for _, m := range maps {
+		for k := range m {
+			set[k] = true
+		}
+	}
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
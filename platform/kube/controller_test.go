@@ -301,6 +301,399 @@ func TestController_GetIstioServiceAccounts(t *testing.T) {
 	}
 }
 
+func TestControllerHostInstances(t *testing.T) {
+	controller := makeFakeKubeAPIController()
+
+	createService(controller, "svc1", "nsA", nil, []int32{8080}, nil, t)
+	createService(controller, "svc2", "nsA", nil, []int32{8081}, nil, t)
+	createService(controller, "svc3", "nsB", nil, []int32{8082}, nil, t)
+	createEndpoints(controller, "svc1", "nsA", []string{"test-port"}, []string{"128.0.0.1", "128.0.0.2"}, t)
+	createEndpoints(controller, "svc2", "nsA", []string{"test-port"}, []string{"128.0.0.1"}, t)
+	createEndpoints(controller, "svc3", "nsB", []string{"test-port"}, []string{"128.0.0.3"}, t)
+
+	instances := controller.HostInstances(map[string]bool{"128.0.0.1": true, "128.0.0.3": true})
+	got := make([]string, 0, len(instances))
+	for _, instance := range instances {
+		got = append(got, fmt.Sprintf("%s %s", instance.Service.Hostname, instance.Endpoint.Address))
+	}
+	sort.Strings(got)
+	expected := []string{
+		serviceHostname("svc1", "nsA", domainSuffix) + " 128.0.0.1",
+		serviceHostname("svc2", "nsA", domainSuffix) + " 128.0.0.1",
+		serviceHostname("svc3", "nsB", domainSuffix) + " 128.0.0.3",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("HostInstances() => %v, want %v", got, expected)
+	}
+
+	if instances = controller.HostInstances(map[string]bool{"128.0.0.4": true}); len(instances) != 0 {
+		t.Errorf("HostInstances() => %v, want none", instances)
+	}
+}
+
+func TestControllerServiceCache(t *testing.T) {
+	controller := makeFakeKubeAPIController()
+	createService(controller, "svc1", "nsA", nil, []int32{8080}, nil, t)
+	hostname := serviceHostname("svc1", "nsA", domainSuffix)
+
+	first, exists := controller.GetService(hostname)
+	if !exists || first.Ports[0].Port != 8080 {
+		t.Fatalf("GetService(%s) => %v, want port 8080", hostname, first)
+	}
+	if second, _ := controller.GetService(hostname); second != first {
+		t.Errorf("GetService(%s) => expected the cached conversion", hostname)
+	}
+
+	// the informer replaces the object on updates
+	item, _ := controller.serviceByKey("svc1", "nsA")
+	updated := *item
+	updated.Spec.Ports = []v1.ServicePort{{Name: "test-port", Port: 9090, Protocol: "http"}}
+	if err := controller.services.store(updated.Namespace).Update(&updated); err != nil {
+		t.Fatal(err)
+	}
+	if svc, _ := controller.GetService(hostname); svc.Ports[0].Port != 9090 {
+		t.Errorf("GetService(%s) => %v, want port 9090", hostname, svc)
+	}
+}
+
+func TestControllerHeadlessService(t *testing.T) {
+	controller := makeFakeKubeAPIController()
+
+	service := &v1.Service{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "kafka", Namespace: "nsA"},
+		Spec: v1.ServiceSpec{
+			ClusterIP: v1.ClusterIPNone,
+			Ports:     []v1.ServicePort{{Name: "tcp-broker", Port: 9092, Protocol: v1.ProtocolTCP}},
+		},
+	}
+	if err := controller.services.store(service.Namespace).Add(service); err != nil {
+		t.Fatal(err)
+	}
+	endpoints := &v1.Endpoints{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "kafka", Namespace: "nsA"},
+		Subsets: []v1.EndpointSubset{{
+			Addresses: []v1.EndpointAddress{
+				{IP: "128.0.0.1", Hostname: "kafka-0"},
+				{IP: "128.0.0.2", Hostname: "kafka-1"},
+				// the endpoints without a hostname have no DNS record
+				{IP: "128.0.0.3"},
+			},
+			Ports: []v1.EndpointPort{{Name: "tcp-broker", Port: 9092}},
+		}},
+	}
+	if err := controller.endpoints.store(endpoints.Namespace).Add(endpoints); err != nil {
+		t.Fatal(err)
+	}
+
+	hostname := serviceHostname("kafka", "nsA", domainSuffix)
+	services := controller.Services()
+	got := make([]string, 0, len(services))
+	for _, svc := range services {
+		got = append(got, svc.Hostname+" "+svc.Address)
+	}
+	sort.Strings(got)
+	expected := []string{
+		"kafka-0." + hostname + " 128.0.0.1",
+		"kafka-1." + hostname + " 128.0.0.2",
+		hostname + " ",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Services() => %v, want %v", got, expected)
+	}
+
+	if instances := controller.Instances(hostname, []string{"tcp-broker"}, nil); len(instances) != 3 {
+		t.Errorf("Instances(%s) => %v, want 3 instances", hostname, instances)
+	}
+
+	// the endpoint services are in the namespace of the headless service
+	isolation := model.Isolation{Enabled: true}
+	if visible := isolation.VisibleServices("nsA", services); len(visible) != len(services) {
+		t.Errorf("VisibleServices(nsA) => %v, want all services", visible)
+	}
+	if visible := isolation.VisibleServices("nsB", services); len(visible) != 0 {
+		t.Errorf("VisibleServices(nsB) => %v, want none", visible)
+	}
+
+	podHostname := "kafka-1." + hostname
+	svc, exists := controller.GetService(podHostname)
+	if !exists || svc.Address != "128.0.0.2" {
+		t.Errorf("GetService(%s) => %v, want the address of the endpoint", podHostname, svc)
+	}
+	instances := controller.Instances(podHostname, []string{"tcp-broker"}, nil)
+	if len(instances) != 1 || instances[0].Endpoint.Address != "128.0.0.2" ||
+		instances[0].Service.Hostname != podHostname {
+		t.Errorf("Instances(%s) => %v, want the endpoint", podHostname, instances)
+	}
+
+	if _, exists = controller.GetService("kafka-2." + hostname); exists {
+		t.Errorf("GetService() => found a missing endpoint")
+	}
+	if _, exists = controller.GetService("128-0-0-3." + hostname); exists {
+		t.Errorf("GetService() => found an endpoint without a hostname")
+	}
+}
+
+func TestControllerInstanceEvents(t *testing.T) {
+	controller := makeFakeKubeAPIController()
+	addPods(t, controller,
+		generatePod("pod1", "nsA", "acct1", "node1", map[string]string{"app": "test-app"}),
+		generatePod("pod2", "nsA", "acct2", "node1", map[string]string{"app": "prod-app"}))
+	controller.pods.keys["128.0.0.1"] = "nsA/pod1"
+	controller.pods.keys["128.0.0.2"] = "nsA/pod2"
+	createService(controller, "svc1", "nsA", nil, []int32{8080}, nil, t)
+
+	var events []string
+	if err := controller.AppendInstanceHandler(func(instance *model.ServiceInstance, event model.Event) {
+		events = append(events, fmt.Sprintf("%s %s:%d %s %s", event, instance.Endpoint.Address,
+			instance.Endpoint.Port, instance.Tags["app"], instance.ServiceAccount))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	endpoints := func(ips ...string) *v1.Endpoints {
+		eas := make([]v1.EndpointAddress, 0, len(ips))
+		for _, ip := range ips {
+			eas = append(eas, v1.EndpointAddress{IP: ip})
+		}
+		return &v1.Endpoints{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "svc1", Namespace: "nsA"},
+			Subsets: []v1.EndpointSubset{{
+				Addresses: eas,
+				Ports:     []v1.EndpointPort{{Name: "test-port", Port: 9080}},
+			}},
+		}
+	}
+
+	cases := []struct {
+		ep     *v1.Endpoints
+		event  model.Event
+		expect []string
+	}{
+		{endpoints("128.0.0.1"), model.EventAdd, []string{
+			"add 128.0.0.1:9080 test-app spiffe://company.com/ns/nsA/sa/acct1",
+		}},
+		{endpoints("128.0.0.1", "128.0.0.2"), model.EventUpdate, []string{
+			"add 128.0.0.2:9080 prod-app spiffe://company.com/ns/nsA/sa/acct2",
+		}},
+		{endpoints("128.0.0.1", "128.0.0.2"), model.EventUpdate, nil},
+		{endpoints("128.0.0.2", "128.0.0.3"), model.EventUpdate, []string{
+			"delete 128.0.0.1:9080 test-app spiffe://company.com/ns/nsA/sa/acct1",
+			"add 128.0.0.3:9080  ",
+		}},
+		{endpoints("128.0.0.2", "128.0.0.3"), model.EventDelete, []string{
+			"delete 128.0.0.2:9080 prod-app spiffe://company.com/ns/nsA/sa/acct2",
+			"delete 128.0.0.3:9080  ",
+		}},
+	}
+	for i, c := range cases {
+		events = nil
+		if err := applyHandlers(controller.endpoints.handler, c.ep, c.event); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(events, c.expect) {
+			t.Errorf("%d: got events %q, want %q", i, events, c.expect)
+		}
+	}
+
+	// relabeling a pod updates its instance on the next endpoints event
+	if err := applyHandlers(controller.endpoints.handler, endpoints("128.0.0.2"), model.EventAdd); err != nil {
+		t.Fatal(err)
+	}
+	pod := generatePod("pod2", "nsA", "acct2", "node1", map[string]string{"app": "test-app"})
+	if err := controller.pods.store(pod.Namespace).Update(pod); err != nil {
+		t.Fatal(err)
+	}
+	events = nil
+	if err := applyHandlers(controller.endpoints.handler, endpoints("128.0.0.2"), model.EventUpdate); err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"update 128.0.0.2:9080 test-app spiffe://company.com/ns/nsA/sa/acct2"}
+	if !reflect.DeepEqual(events, expect) {
+		t.Errorf("got events %q, want %q", events, expect)
+	}
+}
+
+func TestControllerInstanceEventsOnService(t *testing.T) {
+	controller := makeFakeKubeAPIController()
+	var events []string
+	if err := controller.AppendInstanceHandler(func(instance *model.ServiceInstance, event model.Event) {
+		events = append(events, fmt.Sprintf("%s %s", event, instance.Endpoint.Address))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the endpoints arrive before their service
+	createEndpoints(controller, "svc1", "nsA", []string{"test-port"}, []string{"128.0.0.1"}, t)
+	ep, _ := controller.endpointsByKey("svc1", "nsA")
+	if err := applyHandlers(controller.endpoints.handler, ep, model.EventAdd); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Errorf("got events %q before the service, want none", events)
+	}
+
+	createService(controller, "svc1", "nsA", nil, []int32{8080}, nil, t)
+	svc, _ := controller.serviceByKey("svc1", "nsA")
+	if err := applyHandlers(controller.services.handler, svc, model.EventAdd); err != nil {
+		t.Fatal(err)
+	}
+	if expect := []string{"add 128.0.0.1"}; !reflect.DeepEqual(events, expect) {
+		t.Errorf("got events %q, want %q", events, expect)
+	}
+
+	events = nil
+	if err := controller.services.store("nsA").Delete(svc); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyHandlers(controller.services.handler, svc, model.EventDelete); err != nil {
+		t.Fatal(err)
+	}
+	if expect := []string{"delete 128.0.0.1"}; !reflect.DeepEqual(events, expect) {
+		t.Errorf("got events %q, want %q", events, expect)
+	}
+}
+
+func TestControllerWatchedNamespaces(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	cases := []struct {
+		options ControllerOptions
+		expect  []string
+	}{
+		{ControllerOptions{}, []string{meta_v1.NamespaceAll}},
+		{ControllerOptions{AppNamespace: "nsA"}, []string{"nsA"}},
+		{ControllerOptions{AppNamespace: "nsA", WatchedNamespaces: []string{"nsB", "nsC"}}, []string{"nsB", "nsC"}},
+		{ControllerOptions{NamespaceSelector: "istio=enabled"}, []string{}},
+	}
+	for _, c := range cases {
+		controller := NewController(fake.NewSimpleClientset(), &mesh, c.options)
+		for _, h := range []*cacheHandler{controller.services, controller.endpoints, controller.pods.cacheHandler} {
+			if got := h.namespaces(); !reflect.DeepEqual(got, c.expect) {
+				t.Errorf("%#v: watched namespaces %v, want %v", c.options, got, c.expect)
+			}
+		}
+	}
+
+	controller := NewController(fake.NewSimpleClientset(), &mesh, ControllerOptions{
+		WatchedNamespaces: []string{"nsA"},
+		DomainSuffix:      domainSuffix,
+	})
+	createService(controller, "svc1", "nsA", nil, []int32{8080}, nil, t)
+	if _, exists := controller.GetService(serviceHostname("svc1", "nsA", domainSuffix)); !exists {
+		t.Errorf("GetService(svc1.nsA) => false, want true")
+	}
+	if store := controller.services.store("nsB"); store != nil {
+		t.Errorf("store(nsB) => %v, want nil", store)
+	}
+	if _, exists := controller.GetService(serviceHostname("svc1", "nsB", domainSuffix)); exists {
+		t.Errorf("GetService(svc1.nsB) => true, want false")
+	}
+}
+
+func TestControllerNamespaceSelector(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	controller := NewController(fake.NewSimpleClientset(), &mesh, ControllerOptions{
+		WatchedNamespaces: []string{"nsA", "nsB"},
+		NamespaceSelector: "istio=enabled",
+		DomainSuffix:      domainSuffix,
+	})
+	namespace := func(name string) *v1.Namespace {
+		return &v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: name}}
+	}
+
+	for _, name := range []string{"nsA", "nsC"} {
+		if err := applyHandlers(controller.namespaces.handler, namespace(name), model.EventAdd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := controller.services.namespaces(); !reflect.DeepEqual(got, []string{"nsA"}) {
+		t.Errorf("watched namespaces %v, want [nsA]", got)
+	}
+	if got := controller.WatchedNamespaces(); !reflect.DeepEqual(got, []string{"nsA"}) {
+		t.Errorf("WatchedNamespaces() => %v, want [nsA]", got)
+	}
+
+	createService(controller, "svc1", "nsA", nil, []int32{8080}, nil, t)
+	hostname := serviceHostname("svc1", "nsA", domainSuffix)
+	if _, exists := controller.GetService(hostname); !exists {
+		t.Errorf("GetService(%s) => false, want true", hostname)
+	}
+
+	// the services of a namespace that is no longer watched are deleted
+	if err := applyHandlers(controller.namespaces.handler, namespace("nsA"), model.EventDelete); err != nil {
+		t.Fatal(err)
+	}
+	if got := controller.services.namespaces(); len(got) != 0 {
+		t.Errorf("watched namespaces %v, want none", got)
+	}
+	if got := controller.WatchedNamespaces(); len(got) != 0 {
+		t.Errorf("WatchedNamespaces() => %v, want none", got)
+	}
+	if _, exists := controller.GetService(hostname); exists {
+		t.Errorf("GetService(%s) => true, want false", hostname)
+	}
+	tasks := controller.queue.(*queueImpl).queue
+	if len(tasks) != 1 || tasks[0].event != model.EventDelete || tasks[0].obj.(*v1.Service).Name != "svc1" {
+		t.Errorf("queued tasks %v, want the deletion of svc1", tasks)
+	}
+}
+
+// applyHandlers applies the handler chain past the synchronization check of
+// notify, since the informers of the fake controller are not running
+func applyHandlers(ch *ChainHandler, obj interface{}, event model.Event) error {
+	for _, f := range ch.funcs[1:] {
+		if err := f(obj, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// makeBenchmarkController creates the services with an endpoint address each
+func makeBenchmarkController(b *testing.B, n int) *Controller {
+	controller := makeFakeKubeAPIController()
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("svc%d", i)
+		ip := fmt.Sprintf("10.%d.%d.%d", (i>>16)&0xff, (i>>8)&0xff, i&0xff)
+		createService(controller, name, "nsA", nil, []int32{8080}, nil, b)
+		createEndpoints(controller, name, "nsA", []string{"test-port"}, []string{ip}, b)
+	}
+	return controller
+}
+
+// The lookups should not depend on the number of endpoints
+var benchmarkSizes = []int{100, 1000, 20000}
+
+func BenchmarkInstances(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			controller := makeBenchmarkController(b, n)
+			hostname := serviceHostname(fmt.Sprintf("svc%d", n/2), "nsA", domainSuffix)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if instances := controller.Instances(hostname, []string{"test-port"}, nil); len(instances) != 1 {
+					b.Fatalf("Instances(%s) => %v", hostname, instances)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkHostInstances(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			controller := makeBenchmarkController(b, n)
+			i := n / 2
+			addrs := map[string]bool{fmt.Sprintf("10.%d.%d.%d", (i>>16)&0xff, (i>>8)&0xff, i&0xff): true}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if instances := controller.HostInstances(addrs); len(instances) != 1 {
+					b.Fatalf("HostInstances(%v) => %v", addrs, instances)
+				}
+			}
+		})
+	}
+}
+
 func makeFakeKubeAPIController() *Controller {
 	clientSet := fake.NewSimpleClientset()
 	mesh := proxy.DefaultMeshConfig()
@@ -311,7 +704,7 @@ func makeFakeKubeAPIController() *Controller {
 	})
 }
 
-func createEndpoints(controller *Controller, name, namespace string, portNames, ips []string, t *testing.T) {
+func createEndpoints(controller *Controller, name, namespace string, portNames, ips []string, t testing.TB) {
 	eas := []v1.EndpointAddress{}
 	for _, ip := range ips {
 		eas = append(eas, v1.EndpointAddress{IP: ip})
@@ -332,13 +725,13 @@ func createEndpoints(controller *Controller, name, namespace string, portNames,
 			Ports:     eps,
 		}},
 	}
-	if err := controller.endpoints.informer.GetStore().Add(endpoint); err != nil {
+	if err := controller.endpoints.store(endpoint.Namespace).Add(endpoint); err != nil {
 		t.Errorf("failed to create endpoints %s in namespace %s (error %v)", name, namespace, err)
 	}
 }
 
 func createService(controller *Controller, name, namespace string, annotations map[string]string,
-	ports []int32, selector map[string]string, t *testing.T) {
+	ports []int32, selector map[string]string, t testing.TB) {
 
 	svcPorts := []v1.ServicePort{}
 	for _, p := range ports {
@@ -361,14 +754,14 @@ func createService(controller *Controller, name, namespace string, annotations m
 			Type:      v1.ServiceTypeClusterIP,
 		},
 	}
-	if err := controller.services.informer.GetStore().Add(service); err != nil {
+	if err := controller.services.store(service.Namespace).Add(service); err != nil {
 		t.Errorf("Cannot create service %s in namespace %s (error: %v)", name, namespace, err)
 	}
 }
 
 func addPods(t *testing.T, controller *Controller, pods ...*v1.Pod) {
 	for _, pod := range pods {
-		if err := controller.pods.informer.GetStore().Add(pod); err != nil {
+		if err := controller.pods.store(pod.Namespace).Add(pod); err != nil {
 			t.Errorf("Cannot create pod in namespace %s (error: %v)", pod.ObjectMeta.Namespace, err)
 		}
 	}
@@ -399,7 +792,7 @@ func generateNode(name string, labels map[string]string) *v1.Node {
 
 func addNodes(t *testing.T, controller *Controller, nodes ...*v1.Node) {
 	for _, node := range nodes {
-		if err := controller.nodes.informer.GetStore().Add(node); err != nil {
+		if err := controller.nodes.store(meta_v1.NamespaceAll).Add(node); err != nil {
 			t.Errorf("Cannot create node %s (error: %v)", node.Name, err)
 		}
 	}
@@ -71,8 +71,9 @@ func convertService(svc v1.Service, domainSuffix string) *model.Service {
 		external = svc.Spec.ExternalName
 	}
 
-	// must have address or be external (but not both)
-	if (addr == "" && external == "") || (addr != "" && external != "") {
+	// must have address, be headless, or be external (but only one of these)
+	headless := svc.Spec.ClusterIP == v1.ClusterIPNone && external == ""
+	if (addr == "" && external == "" && !headless) || (addr != "" && external != "") {
 		return nil
 	}
 
@@ -102,6 +103,7 @@ func convertService(svc v1.Service, domainSuffix string) *model.Service {
 		Address:         addr,
 		ExternalName:    external,
 		ServiceAccounts: serviceaccounts,
+		Namespace:       svc.Namespace,
 	}
 }
 
@@ -110,6 +112,20 @@ func serviceHostname(name, namespace, domainSuffix string) string {
 	return fmt.Sprintf("%s.%s.svc.%s", name, namespace, domainSuffix)
 }
 
+// endpointService represents an endpoint of a headless service as a service
+// at the address of the endpoint, e.g. "kafka-0.kafka.ns.svc.cluster.local".
+// Only the endpoints with a hostname, e.g. the pods of a StatefulSet, have a
+// DNS record in kube-dns.
+func endpointService(svc *model.Service, ea v1.EndpointAddress) *model.Service {
+	return &model.Service{
+		Hostname:        fmt.Sprintf("%s.%s", ea.Hostname, svc.Hostname),
+		Address:         ea.IP,
+		Ports:           svc.Ports,
+		ServiceAccounts: svc.ServiceAccounts,
+		Namespace:       svc.Namespace,
+	}
+}
+
 // canonicalToIstioServiceAccount converts a Canonical service account to an Istio service account
 func canonicalToIstioServiceAccount(saname string) string {
 	return fmt.Sprintf("%v://%v", IstioURIPrefix, saname)
@@ -116,6 +116,10 @@ func TestServiceConversion(t *testing.T) {
 		t.Errorf("service IP incorrect => %q, want %q", service.Address, ip)
 	}
 
+	if service.Namespace != namespace {
+		t.Errorf("service namespace incorrect => %q, want %q", service.Namespace, namespace)
+	}
+
 	sa := service.ServiceAccounts
 	if sa == nil || len(sa) != 4 {
 		t.Errorf("number of service accounts is incorrect")
@@ -213,6 +217,49 @@ func TestExternalServiceConversion(t *testing.T) {
 	}
 }
 
+func TestHeadlessServiceConversion(t *testing.T) {
+	serviceName := "kafka"
+	namespace := "default"
+
+	headlessSvc := v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      serviceName,
+			Namespace: namespace,
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{
+				{
+					Name:     "tcp-broker",
+					Port:     9092,
+					Protocol: v1.ProtocolTCP,
+				},
+			},
+			ClusterIP: v1.ClusterIPNone,
+		},
+	}
+
+	service := convertService(headlessSvc, domainSuffix)
+	if service == nil {
+		t.Fatal("could not convert headless service")
+	}
+
+	if service.Address != "" || service.External() {
+		t.Errorf("headless service should have no address => %q", service.Address)
+	}
+
+	for _, tt := range []struct {
+		ea   v1.EndpointAddress
+		host string
+	}{
+		{v1.EndpointAddress{IP: "10.1.1.1", Hostname: "kafka-0"}, "kafka-0.kafka.default.svc.company.com"},
+	} {
+		out := endpointService(service, tt.ea)
+		if out.Hostname != tt.host || out.Address != tt.ea.IP || len(out.Ports) != 1 || out.Namespace != "default" {
+			t.Errorf("endpointService(%v) => %+v, want hostname %q", tt.ea, out, tt.host)
+		}
+	}
+}
+
 func TestInvalidServiceConversion(t *testing.T) {
 	serviceName := "service1"
 	namespace := "default"
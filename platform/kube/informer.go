@@ -0,0 +1,183 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/golang/glog"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// cacheHandler multiplexes the informers of a resource type over the watched
+// namespaces and applies the handler chain to the events of all of them.
+// The informer for meta_v1.NamespaceAll watches the whole cluster, which
+// requires cluster-wide list permissions, while the namespaced informers only
+// require permissions in their namespaces.
+type cacheHandler struct {
+	handler *ChainHandler
+
+	// newInformer creates an informer for the resources in a namespace
+	newInformer func(namespace string) cache.SharedIndexInformer
+
+	mutex     sync.RWMutex
+	informers map[string]*namespaceInformer
+	// stop is set once the informers are running
+	stop <-chan struct{}
+}
+
+// namespaceInformer is an informer that can be stopped on its own when its
+// namespace is no longer watched
+type namespaceInformer struct {
+	cache.SharedIndexInformer
+	stop chan struct{}
+}
+
+func newCacheHandler(handler *ChainHandler, newInformer func(string) cache.SharedIndexInformer) *cacheHandler {
+	return &cacheHandler{
+		handler:     handler,
+		newInformer: newInformer,
+		informers:   make(map[string]*namespaceInformer),
+	}
+}
+
+// watch starts watching the resources in the namespace
+func (h *cacheHandler) watch(namespace string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, exists := h.informers[namespace]; exists {
+		return
+	}
+	glog.V(2).Infof("Watching namespace %q", namespace)
+	informer := &namespaceInformer{
+		SharedIndexInformer: h.newInformer(namespace),
+		stop:                make(chan struct{}),
+	}
+	h.informers[namespace] = informer
+	if h.stop != nil {
+		go informer.Run(informer.stop)
+	}
+}
+
+// unwatch stops watching the resources in the namespace and returns the
+// resources last known in the namespace
+func (h *cacheHandler) unwatch(namespace string) []interface{} {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	informer, exists := h.informers[namespace]
+	if !exists {
+		return nil
+	}
+	glog.V(2).Infof("Stopped watching namespace %q", namespace)
+	delete(h.informers, namespace)
+	if h.stop != nil {
+		close(informer.stop)
+	}
+	return informer.GetStore().List()
+}
+
+// run runs the informers, including the ones watched later, until a signal is received
+func (h *cacheHandler) run(stop <-chan struct{}) {
+	h.mutex.Lock()
+	h.stop = stop
+	for _, informer := range h.informers {
+		go informer.Run(informer.stop)
+	}
+	h.mutex.Unlock()
+
+	<-stop
+	h.mutex.Lock()
+	for _, informer := range h.informers {
+		close(informer.stop)
+	}
+	h.informers = make(map[string]*namespaceInformer)
+	h.mutex.Unlock()
+}
+
+// hasSynced returns true after the initial synchronization of all informers
+func (h *cacheHandler) hasSynced() bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for _, informer := range h.informers {
+		if !informer.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// namespaces lists the watched namespaces in order
+func (h *cacheHandler) namespaces() []string {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	out := make([]string, 0, len(h.informers))
+	for namespace := range h.informers {
+		out = append(out, namespace)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// store returns the store holding the resources of the namespace, or nil if
+// the namespace is not watched
+func (h *cacheHandler) store(namespace string) cache.Indexer {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	if informer, exists := h.informers[meta_v1.NamespaceAll]; exists {
+		return informer.GetIndexer()
+	}
+	if informer, exists := h.informers[namespace]; exists {
+		return informer.GetIndexer()
+	}
+	return nil
+}
+
+// getByKey returns the resource by its namespace and key
+func (h *cacheHandler) getByKey(namespace, key string) (interface{}, bool, error) {
+	store := h.store(namespace)
+	if store == nil {
+		return nil, false, nil
+	}
+	return store.GetByKey(key)
+}
+
+// list returns the resources in all the watched namespaces
+func (h *cacheHandler) list() []interface{} {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	var out []interface{}
+	for _, informer := range h.informers {
+		out = append(out, informer.GetStore().List()...)
+	}
+	return out
+}
+
+// byIndex returns the resources in all the watched namespaces whose indexed
+// values include the value
+func (h *cacheHandler) byIndex(indexName, value string) ([]interface{}, error) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	var out []interface{}
+	for _, informer := range h.informers {
+		items, err := informer.GetIndexer().ByIndex(indexName, value)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, items...)
+	}
+	return out, nil
+}
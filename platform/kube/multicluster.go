@@ -0,0 +1,252 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/client-go/kubernetes"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model"
+)
+
+const (
+	// ClusterTagName is the tag of the service instances naming the cluster
+	// of the instances in a multi-cluster controller
+	ClusterTagName = "istio.io/cluster"
+
+	// syncStatusPeriod is the period for reporting the clusters that have not synchronized yet
+	syncStatusPeriod = 5 * time.Second
+)
+
+// Cluster is a Kubernetes cluster watched by a multi-cluster controller
+type Cluster struct {
+	// Name identifies the cluster in the tags of the service instances
+	Name string
+	// Client connects to the API server of the cluster
+	Client kubernetes.Interface
+}
+
+// MultiClusterController merges the services and endpoints of several
+// clusters with a flat pod network. The clusters share the service hostnames,
+// so the services are resolved from the first cluster that declares them and
+// the instances of a service are the union of its instances in all clusters.
+type MultiClusterController struct {
+	clusters []*clusterController
+}
+
+type clusterController struct {
+	name string
+	*Controller
+}
+
+// NewMultiClusterController creates a controller with a Kubernetes controller
+// per cluster, in order of precedence for the service declarations
+func NewMultiClusterController(clusters []Cluster, mesh *proxyconfig.ProxyMeshConfig,
+	options ControllerOptions) *MultiClusterController {
+	out := &MultiClusterController{}
+	for _, cluster := range clusters {
+		out.clusters = append(out.clusters, &clusterController{
+			name:       cluster.Name,
+			Controller: NewController(cluster.Client, mesh, options),
+		})
+	}
+	return out
+}
+
+// ClusterController returns the controller of the first cluster with the name
+func (mc *MultiClusterController) ClusterController(name string) (*Controller, bool) {
+	for _, cluster := range mc.clusters {
+		if cluster.name == name {
+			return cluster.Controller, true
+		}
+	}
+	return nil, false
+}
+
+// tagInstance copies the service instance with the cluster tag
+func (cc *clusterController) tagInstance(instance *model.ServiceInstance) *model.ServiceInstance {
+	out := *instance
+	out.Tags = make(model.Tags, len(instance.Tags)+1)
+	for k, v := range instance.Tags {
+		out.Tags[k] = v
+	}
+	out.Tags[ClusterTagName] = cc.name
+	return &out
+}
+
+// Services implements a service catalog operation
+func (mc *MultiClusterController) Services() []*model.Service {
+	seen := make(map[string]bool)
+	var out []*model.Service
+	for _, cluster := range mc.clusters {
+		for _, svc := range cluster.Services() {
+			if !seen[svc.Hostname] {
+				seen[svc.Hostname] = true
+				out = append(out, svc)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Hostname < out[j].Hostname })
+	return out
+}
+
+// GetService implements a service catalog operation
+func (mc *MultiClusterController) GetService(hostname string) (*model.Service, bool) {
+	for _, cluster := range mc.clusters {
+		if svc, exists := cluster.GetService(hostname); exists {
+			return svc, true
+		}
+	}
+	return nil, false
+}
+
+// Instances implements a service catalog operation. The instances refer to
+// the merged service declaration, and the tags select the instances by their
+// cluster tag as well.
+func (mc *MultiClusterController) Instances(hostname string, ports []string,
+	tagsList model.TagsList) []*model.ServiceInstance {
+	svc, exists := mc.GetService(hostname)
+	if !exists {
+		return nil
+	}
+
+	var out []*model.ServiceInstance
+	for _, cluster := range mc.clusters {
+		for _, instance := range cluster.Instances(hostname, ports, nil) {
+			tagged := cluster.tagInstance(instance)
+			if !tagsList.HasSubsetOf(tagged.Tags) {
+				continue
+			}
+			tagged.Service = svc
+			out = append(out, tagged)
+		}
+	}
+	return out
+}
+
+// HostInstances implements a service catalog operation
+func (mc *MultiClusterController) HostInstances(addrs map[string]bool) []*model.ServiceInstance {
+	var out []*model.ServiceInstance
+	for _, cluster := range mc.clusters {
+		for _, instance := range cluster.HostInstances(addrs) {
+			out = append(out, cluster.tagInstance(instance))
+		}
+	}
+	return out
+}
+
+// ManagementPorts implements a service catalog operation
+func (mc *MultiClusterController) ManagementPorts(addr string) model.PortList {
+	for _, cluster := range mc.clusters {
+		if ports := cluster.ManagementPorts(addr); ports != nil {
+			return ports
+		}
+	}
+	return nil
+}
+
+// GetIstioServiceAccounts implements a service catalog operation
+func (mc *MultiClusterController) GetIstioServiceAccounts(hostname string, ports []string) []string {
+	saSet := make(map[string]bool)
+	for _, cluster := range mc.clusters {
+		for _, sa := range cluster.GetIstioServiceAccounts(hostname, ports) {
+			saSet[sa] = true
+		}
+	}
+
+	out := make([]string, 0, len(saSet))
+	for sa := range saSet {
+		out = append(out, sa)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// AppendServiceHandler implements a service catalog operation
+func (mc *MultiClusterController) AppendServiceHandler(f func(*model.Service, model.Event)) error {
+	for _, cluster := range mc.clusters {
+		if err := cluster.AppendServiceHandler(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AppendInstanceHandler implements a service catalog operation
+func (mc *MultiClusterController) AppendInstanceHandler(f func(*model.ServiceInstance, model.Event)) error {
+	for _, cluster := range mc.clusters {
+		cc := cluster
+		if err := cc.AppendInstanceHandler(func(instance *model.ServiceInstance, event model.Event) {
+			f(cc.tagInstance(instance), event)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SyncStatus reports whether each cluster has completed its initial state
+// synchronization, by cluster name
+func (mc *MultiClusterController) SyncStatus() map[string]bool {
+	out := make(map[string]bool, len(mc.clusters))
+	for _, cluster := range mc.clusters {
+		out[cluster.name] = cluster.HasSynced()
+	}
+	return out
+}
+
+// HasSynced returns true after the initial state synchronization of all clusters
+func (mc *MultiClusterController) HasSynced() bool {
+	for _, cluster := range mc.clusters {
+		if !cluster.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// Run all cluster controllers until a signal is received, and report the
+// clusters as they complete their initial synchronization
+func (mc *MultiClusterController) Run(stop <-chan struct{}) {
+	for _, cluster := range mc.clusters {
+		go cluster.Run(stop)
+	}
+
+	synced := make(map[string]bool)
+	ticker := time.NewTicker(syncStatusPeriod)
+	defer ticker.Stop()
+	for len(synced) < len(mc.clusters) {
+		for name, status := range mc.SyncStatus() {
+			if status && !synced[name] {
+				synced[name] = true
+				glog.Infof("Cluster %s synchronized", name)
+			} else if !status {
+				glog.V(2).Infof("Cluster %s is synchronizing", name)
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+
+	<-stop
+	glog.V(2).Info("Multi-cluster controller terminated")
+}
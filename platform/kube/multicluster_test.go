@@ -0,0 +1,131 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+)
+
+func makeMultiClusterController() *MultiClusterController {
+	mesh := proxy.DefaultMeshConfig()
+	return NewMultiClusterController([]Cluster{
+		{Name: "east", Client: fake.NewSimpleClientset()},
+		{Name: "west", Client: fake.NewSimpleClientset()},
+	}, &mesh, ControllerOptions{
+		ResyncPeriod: resync,
+		DomainSuffix: domainSuffix,
+	})
+}
+
+func TestMultiClusterController(t *testing.T) {
+	controller := makeMultiClusterController()
+	east, west := controller.clusters[0].Controller, controller.clusters[1].Controller
+
+	createService(east, "svc1", "nsA", nil, []int32{8080}, nil, t)
+	createService(west, "svc1", "nsA", nil, []int32{9090}, nil, t)
+	createService(west, "svc2", "nsA", nil, []int32{8080}, nil, t)
+	createEndpoints(east, "svc1", "nsA", []string{"test-port"}, []string{"128.0.0.1"}, t)
+	createEndpoints(west, "svc1", "nsA", []string{"test-port"}, []string{"129.0.0.1", "129.0.0.2"}, t)
+	createEndpoints(west, "svc2", "nsA", []string{"test-port"}, []string{"129.0.0.1"}, t)
+
+	hostname := serviceHostname("svc1", "nsA", domainSuffix)
+	var hostnames []string
+	for _, svc := range controller.Services() {
+		hostnames = append(hostnames, svc.Hostname)
+	}
+	expected := []string{hostname, serviceHostname("svc2", "nsA", domainSuffix)}
+	if !reflect.DeepEqual(hostnames, expected) {
+		t.Errorf("Services() => %v, want %v", hostnames, expected)
+	}
+
+	// the first cluster declares the service
+	svc, exists := controller.GetService(hostname)
+	if !exists || svc.Ports[0].Port != 8080 {
+		t.Fatalf("GetService(%s) => %v, want port 8080", hostname, svc)
+	}
+
+	instances := controller.Instances(hostname, []string{"test-port"}, nil)
+	got := make([]string, 0, len(instances))
+	for _, instance := range instances {
+		if instance.Service != svc {
+			t.Errorf("Instances(%s) => service %v, want %v", hostname, instance.Service, svc)
+		}
+		got = append(got, fmt.Sprintf("%s %s", instance.Tags[ClusterTagName], instance.Endpoint.Address))
+	}
+	sort.Strings(got)
+	expected = []string{"east 128.0.0.1", "west 129.0.0.1", "west 129.0.0.2"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Instances(%s) => %v, want %v", hostname, got, expected)
+	}
+
+	instances = controller.Instances(hostname, []string{"test-port"}, model.TagsList{{ClusterTagName: "west"}})
+	if len(instances) != 2 {
+		t.Errorf("Instances(%s) in cluster west => %v, want 2 instances", hostname, instances)
+	}
+
+	instances = controller.HostInstances(map[string]bool{"129.0.0.1": true})
+	got = make([]string, 0, len(instances))
+	for _, instance := range instances {
+		got = append(got, fmt.Sprintf("%s %s", instance.Tags[ClusterTagName], instance.Service.Hostname))
+	}
+	sort.Strings(got)
+	expected = []string{"west " + hostname, "west " + serviceHostname("svc2", "nsA", domainSuffix)}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("HostInstances() => %v, want %v", got, expected)
+	}
+
+	if cluster, exists := controller.ClusterController("west"); !exists || cluster != west {
+		t.Errorf("ClusterController(west) => %v, want the west controller", cluster)
+	}
+	if _, exists := controller.ClusterController("north"); exists {
+		t.Errorf("ClusterController(north) => found a missing cluster")
+	}
+
+	status := controller.SyncStatus()
+	if !reflect.DeepEqual(status, map[string]bool{"east": false, "west": false}) {
+		t.Errorf("SyncStatus() => %v, want both clusters unsynchronized", status)
+	}
+}
+
+func TestMultiClusterControllerInstanceHandler(t *testing.T) {
+	controller := makeMultiClusterController()
+	west := controller.clusters[1].Controller
+	createService(west, "svc1", "nsA", nil, []int32{8080}, nil, t)
+
+	var events []string
+	if err := controller.AppendInstanceHandler(func(instance *model.ServiceInstance, event model.Event) {
+		events = append(events, fmt.Sprintf("%s %s %s", event, instance.Tags[ClusterTagName], instance.Endpoint.Address))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	createEndpoints(west, "svc1", "nsA", []string{"test-port"}, []string{"129.0.0.1"}, t)
+	ep, _ := west.endpointsByKey("svc1", "nsA")
+	if err := applyHandlers(west.endpoints.handler, ep, model.EventAdd); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"add west 129.0.0.1"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("got events %v, want %v", events, expected)
+	}
+}
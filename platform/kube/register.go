@@ -15,15 +15,28 @@
 package kube
 
 import (
+	"encoding/json"
+	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
 	"k8s.io/api/core/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
+const (
+	// VMHeartbeatsAnnotation on the endpoints records the heartbeats of the
+	// addresses registered with a TTL, as a JSON map from the address to its
+	// TTL and heartbeat sequence number. The addresses are refreshed by
+	// heartbeats and removed by the reaper once their TTL elapsed since the
+	// reaper last observed a heartbeat.
+	VMHeartbeatsAnnotation = "alpha.istio.io/vm-heartbeats"
+)
+
 var (
 	// For most common ports allow the protocol to be guessed, this isn't meant
 	// to replace /etc/services. Fully qualified proto[-extra]:port is the
@@ -165,6 +178,10 @@ func RegisterEndpoint(client kubernetes.Interface, namespace string, svcName str
 			if matchingSubset != 1 {
 				glog.Errorf("Unexpected match in %d subsets", matchingSubset)
 			}
+			if hasAddress(ss.Addresses, ip) {
+				glog.Infof("Address %s is already registered", ip)
+				continue
+			}
 			eps.Subsets[i].Addresses = append(ss.Addresses, v1.EndpointAddress{IP: ip})
 		}
 	}
@@ -194,3 +211,254 @@ func RegisterEndpoint(client kubernetes.Interface, namespace string, svcName str
 	}
 	return nil
 }
+
+// hasAddress returns true if the address is in the list
+func hasAddress(addresses []v1.EndpointAddress, ip string) bool {
+	for _, address := range addresses {
+		if address.IP == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// removeAddress removes the address from the subsets of the endpoints, drops
+// the subsets left without addresses and returns true if the address was found.
+func removeAddress(eps *v1.Endpoints, ip string) bool {
+	found := false
+	subsets := eps.Subsets[:0]
+	for _, ss := range eps.Subsets {
+		addresses := ss.Addresses[:0]
+		for _, address := range ss.Addresses {
+			if address.IP == ip {
+				found = true
+			} else {
+				addresses = append(addresses, address)
+			}
+		}
+		ss.Addresses = addresses
+		if len(ss.Addresses) > 0 || len(ss.NotReadyAddresses) > 0 {
+			subsets = append(subsets, ss)
+		}
+	}
+	eps.Subsets = subsets
+	return found
+}
+
+// heartbeat is the state of an address registered with a TTL. Heartbeats
+// increment the sequence number rather than record a time, so that the
+// expiration is measured on the clock of the reaper alone.
+type heartbeat struct {
+	// TTL of the address after the last heartbeat, in nanoseconds
+	TTL time.Duration `json:"ttl"`
+	// Sequence number of the last heartbeat
+	Sequence int64 `json:"sequence"`
+}
+
+// heartbeats decodes the heartbeats of the addresses of the endpoints
+func heartbeats(eps *v1.Endpoints) (map[string]heartbeat, error) {
+	out := make(map[string]heartbeat)
+	value, exists := eps.Annotations[VMHeartbeatsAnnotation]
+	if !exists {
+		return out, nil
+	}
+	if err := json.Unmarshal([]byte(value), &out); err != nil {
+		return nil, fmt.Errorf("invalid annotation %s of endpoints %s in namespace %s: %v",
+			VMHeartbeatsAnnotation, eps.Name, eps.Namespace, err)
+	}
+	return out, nil
+}
+
+// setHeartbeats encodes the heartbeats of the addresses of the endpoints
+func setHeartbeats(eps *v1.Endpoints, beats map[string]heartbeat) error {
+	if len(beats) == 0 {
+		delete(eps.Annotations, VMHeartbeatsAnnotation)
+		return nil
+	}
+	value, err := json.Marshal(beats)
+	if err != nil {
+		return err
+	}
+	if eps.Annotations == nil {
+		eps.Annotations = make(map[string]string)
+	}
+	eps.Annotations[VMHeartbeatsAnnotation] = string(value)
+	return nil
+}
+
+// DeregisterEndpoint removes the endpoint address from the endpoints of the
+// service. The service is left in place since it may have other endpoints.
+func DeregisterEndpoint(client kubernetes.Interface, namespace string, svcName string, ip string) error {
+	eps, err := client.CoreV1().Endpoints(namespace).Get(svcName, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if !removeAddress(eps, ip) {
+		return fmt.Errorf("address %s is not registered for %s in namespace %s", ip, svcName, namespace)
+	}
+
+	beats, err := heartbeats(eps)
+	if err != nil {
+		glog.Warningf("Dropping heartbeats: %v", err)
+		beats = nil
+	}
+	delete(beats, ip)
+	if err = setHeartbeats(eps, beats); err != nil {
+		return err
+	}
+
+	if _, err = client.CoreV1().Endpoints(namespace).Update(eps); err != nil {
+		glog.Error("Update failed with: ", err)
+		return err
+	}
+	glog.Infof("Successfully deregistered %s from %s", ip, svcName)
+	return nil
+}
+
+// HeartbeatEndpoint refreshes the registered endpoint address with its TTL.
+// The address is removed by the reaper unless refreshed again within the TTL
+// of the time the reaper observes the heartbeat.
+func HeartbeatEndpoint(client kubernetes.Interface, namespace string, svcName string,
+	ip string, ttl time.Duration) error {
+	eps, err := client.CoreV1().Endpoints(namespace).Get(svcName, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, ss := range eps.Subsets {
+		found = found || hasAddress(ss.Addresses, ip)
+	}
+	if !found {
+		return fmt.Errorf("address %s is not registered for %s in namespace %s", ip, svcName, namespace)
+	}
+
+	beats, err := heartbeats(eps)
+	if err != nil {
+		return err
+	}
+	beat := beats[ip]
+	beats[ip] = heartbeat{TTL: ttl, Sequence: beat.Sequence + 1}
+	if err = setHeartbeats(eps, beats); err != nil {
+		return err
+	}
+
+	_, err = client.CoreV1().Endpoints(namespace).Update(eps)
+	return err
+}
+
+// EndpointReaper removes the endpoint addresses whose heartbeats stopped for
+// longer than their TTL. The reaper records the time it first observes each
+// heartbeat on its own clock, so the clocks of the registered VMs are never
+// compared with it. An address is reaped at most one reaping period after its
+// TTL elapsed, and no earlier than one TTL after the reaper starts.
+type EndpointReaper struct {
+	client kubernetes.Interface
+
+	// observed records the last heartbeat observed by endpoints key and address
+	observed map[string]observedHeartbeat
+}
+
+// observedHeartbeat is a heartbeat sequence number and the time it was observed
+type observedHeartbeat struct {
+	sequence int64
+	at       time.Time
+}
+
+// NewEndpointReaper creates a reaper for the endpoints of the client
+func NewEndpointReaper(client kubernetes.Interface) *EndpointReaper {
+	return &EndpointReaper{
+		client:   client,
+		observed: make(map[string]observedHeartbeat),
+	}
+}
+
+// Reap removes the endpoint addresses in the namespaces whose last heartbeat
+// was observed more than their TTL before now. Endpoints updated concurrently
+// fail with a conflict and are reaped on the next attempt.
+func (r *EndpointReaper) Reap(namespaces []string, now time.Time) error {
+	var errs error
+	listed := true
+	observed := make(map[string]observedHeartbeat)
+	for _, namespace := range namespaces {
+		list, err := r.client.CoreV1().Endpoints(namespace).List(meta_v1.ListOptions{})
+		if err != nil {
+			errs = multierror.Append(errs, err)
+			listed = false
+			continue
+		}
+
+		for i := range list.Items {
+			eps := &list.Items[i]
+			if _, exists := eps.Annotations[VMHeartbeatsAnnotation]; !exists {
+				continue
+			}
+			beats, err := heartbeats(eps)
+			if err != nil {
+				errs = multierror.Append(errs, err)
+				continue
+			}
+
+			var expired []string
+			for ip, beat := range beats {
+				key := KeyFunc(eps.Name, eps.Namespace) + "/" + ip
+				last, exists := r.observed[key]
+				if !exists || last.sequence != beat.Sequence {
+					last = observedHeartbeat{sequence: beat.Sequence, at: now}
+				}
+				observed[key] = last
+				if now.Sub(last.at) > beat.TTL {
+					glog.Infof("Reaping address %s of %s in namespace %s without heartbeat since %v",
+						ip, eps.Name, eps.Namespace, last.at)
+					removeAddress(eps, ip)
+					delete(beats, ip)
+					expired = append(expired, key)
+				}
+			}
+			if len(expired) == 0 {
+				continue
+			}
+
+			if err = setHeartbeats(eps, beats); err != nil {
+				errs = multierror.Append(errs, err)
+				continue
+			}
+			if _, err = r.client.CoreV1().Endpoints(eps.Namespace).Update(eps); err != nil {
+				errs = multierror.Append(errs, err)
+				continue
+			}
+			// a registration of the address again starts over with a new observation
+			for _, key := range expired {
+				delete(observed, key)
+				delete(r.observed, key)
+			}
+		}
+	}
+
+	// the observations in the namespaces that failed to list still hold
+	if !listed {
+		for key, last := range r.observed {
+			if _, exists := observed[key]; !exists {
+				observed[key] = last
+			}
+		}
+	}
+	r.observed = observed
+	return errs
+}
+
+// Run reaps the expired endpoint addresses in the namespaces listed at each
+// period until a signal is received
+func (r *EndpointReaper) Run(namespaces func() []string, period time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := r.Reap(namespaces(), time.Now()); err != nil {
+				glog.Warningf("Failed to reap endpoints: %v", err)
+			}
+		}
+	}
+}
@@ -15,9 +15,15 @@
 package kube
 
 import (
+	"reflect"
+	"sort"
 	"testing"
+	"time"
 
 	"k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestStr2NamedPort(t *testing.T) {
@@ -112,3 +118,129 @@ func TestAddLabelsAndAnnotations(t *testing.T) {
 		t.Errorf("Got unexpected %v for annotation a1=av1", o.Annotations["a1"])
 	}
 }
+
+// registeredAddresses lists the addresses of the endpoints in order
+func registeredAddresses(t *testing.T, client kubernetes.Interface, namespace, svcName string) []string {
+	eps, err := client.CoreV1().Endpoints(namespace).Get(svcName, meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]string, 0)
+	for _, ss := range eps.Subsets {
+		for _, address := range ss.Addresses {
+			out = append(out, address.IP)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestRegisterDeregisterEndpoint(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ports := []NamedPort{{Port: 8080, Name: "http"}}
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.1"} {
+		if err := RegisterEndpoint(client, "nsA", "vm", ip, ports, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := registeredAddresses(t, client, "nsA", "vm"); !reflect.DeepEqual(got, []string{"10.0.0.1", "10.0.0.2"}) {
+		t.Errorf("registered addresses %v, want [10.0.0.1 10.0.0.2]", got)
+	}
+
+	if err := DeregisterEndpoint(client, "nsA", "vm", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := registeredAddresses(t, client, "nsA", "vm"); !reflect.DeepEqual(got, []string{"10.0.0.2"}) {
+		t.Errorf("registered addresses %v, want [10.0.0.2]", got)
+	}
+	if err := DeregisterEndpoint(client, "nsA", "vm", "10.0.0.1"); err == nil {
+		t.Error("DeregisterEndpoint() of an unregistered address => no error")
+	}
+
+	if err := DeregisterEndpoint(client, "nsA", "vm", "10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+	eps, err := client.CoreV1().Endpoints("nsA").Get("vm", meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eps.Subsets) != 0 {
+		t.Errorf("subsets %v, want none", eps.Subsets)
+	}
+	if _, err = client.CoreV1().Services("nsA").Get("vm", meta_v1.GetOptions{}); err != nil {
+		t.Errorf("service deleted with its last endpoint: %v", err)
+	}
+}
+
+func TestEndpointHeartbeatReaper(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ports := []NamedPort{{Port: 8080, Name: "http"}}
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		if err := RegisterEndpoint(client, "nsA", "vm", ip, ports, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := HeartbeatEndpoint(client, "nsA", "vm", "10.0.0.1", 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := HeartbeatEndpoint(client, "nsA", "vm", "10.0.0.2", 30*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := HeartbeatEndpoint(client, "nsA", "vm", "10.0.0.4", 30*time.Second); err == nil {
+		t.Error("HeartbeatEndpoint() of an unregistered address => no error")
+	}
+
+	// the reaper measures the TTLs from the time it observes the heartbeats,
+	// and addresses registered without a TTL are never reaped
+	reaper := NewEndpointReaper(client)
+	now := time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		now       time.Time
+		heartbeat string
+		expect    []string
+	}{
+		{now, "", []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{now.Add(5 * time.Second), "10.0.0.1", []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{now.Add(12 * time.Second), "", []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{now.Add(20 * time.Second), "", []string{"10.0.0.2", "10.0.0.3"}},
+		{now.Add(40 * time.Second), "", []string{"10.0.0.3"}},
+	}
+	for _, c := range cases {
+		if c.heartbeat != "" {
+			if err := HeartbeatEndpoint(client, "nsA", "vm", c.heartbeat, 10*time.Second); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := reaper.Reap([]string{"nsA"}, c.now); err != nil {
+			t.Fatal(err)
+		}
+		if got := registeredAddresses(t, client, "nsA", "vm"); !reflect.DeepEqual(got, c.expect) {
+			t.Errorf("registered addresses at %v %v, want %v", c.now, got, c.expect)
+		}
+	}
+
+	// the namespaces that are not watched are not reaped
+	if err := RegisterEndpoint(client, "nsB", "vm", "10.0.0.5", ports, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := HeartbeatEndpoint(client, "nsB", "vm", "10.0.0.5", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for _, offset := range []time.Duration{time.Minute, 2 * time.Minute} {
+		if err := reaper.Reap(nil, now.Add(offset)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := registeredAddresses(t, client, "nsB", "vm"); !reflect.DeepEqual(got, []string{"10.0.0.5"}) {
+		t.Errorf("registered addresses in nsB %v, want [10.0.0.5]", got)
+	}
+
+	eps, err := client.CoreV1().Endpoints("nsA").Get("vm", meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, exists := eps.Annotations[VMHeartbeatsAnnotation]; exists {
+		t.Errorf("annotation %s => %q, want none", VMHeartbeatsAnnotation, value)
+	}
+}
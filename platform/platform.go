@@ -22,4 +22,6 @@ const (
 	KubernetesRegistry ServiceRegistry = "Kubernetes"
 	// ConsulRegistry environment flag
 	ConsulRegistry ServiceRegistry = "Consul"
+	// EurekaRegistry environment flag
+	EurekaRegistry ServiceRegistry = "Eureka"
 )
@@ -18,6 +18,8 @@ import (
 	"context"
 	"errors"
 	"reflect"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
@@ -52,6 +54,15 @@ import (
 // from the failed attempt. Retry budgets are allocated whenever the desired
 // configuration changes.
 //
+// On termination, the agent either aborts all epochs immediately or, given a
+// drain command, shuts down gracefully: the agent reports the draining state
+// to fail the readiness checks, waits for the removal of the proxy endpoint,
+// asks the latest epoch to drain its listeners, and aborts the remaining
+// epochs after the drain duration.
+//
+// The agent skips the restart if the desired configuration implements
+// ConfigDiffer and reports no changes that require a restart.
+//
 // Agent executes a single control loop that receives notifications about
 // scheduled configuration updates, exits from older proxy epochs, and retry
 // attempt timers. The call to schedule a configuration update will block until
@@ -66,11 +77,119 @@ type Agent interface {
 	// Run starts the agent control loop and awaits for a signal on the input
 	// channel to exit the loop.
 	Run(ctx context.Context)
+
+	// Status returns a snapshot of the agent state. It is safe to call
+	// concurrently with the control loop.
+	Status() AgentStatus
+}
+
+// AgentStatus describes the state of the proxy epochs managed by the agent
+type AgentStatus struct {
+	// Epoch is the latest running epoch, or -1 if no epoch is running
+	Epoch int `json:"epoch"`
+
+	// Restarts is the number of proxy starts following the initial one,
+	// including hot restarts and retries
+	Restarts int `json:"restarts"`
+
+	// Failures is the number of epochs that terminated with an error
+	Failures int `json:"failures"`
+
+	// LastExitError is the error of the last epoch that failed
+	LastExitError string `json:"lastExitError,omitempty"`
+
+	// RetryBudget is the number of retries left to apply the desired configuration
+	RetryBudget int `json:"retryBudget"`
+
+	// BudgetExhausted is set once the agent gives up applying the desired configuration
+	BudgetExhausted bool `json:"budgetExhausted"`
+
+	// Draining is set once the agent starts a graceful shutdown
+	Draining bool `json:"draining"`
+
+	// RestartReasons counts the proxy starts by the changed configuration sections
+	RestartReasons map[string]int `json:"restartReasons,omitempty"`
+
+	// SkippedRestarts is the number of configuration updates applied without a restart
+	SkippedRestarts int `json:"skippedRestarts"`
+
+	// RestartIntervals is the distribution of the time between consecutive proxy starts
+	RestartIntervals Histogram `json:"restartIntervals"`
+
+	// CurrentConfig is the configuration of the latest epoch
+	CurrentConfig interface{} `json:"-"`
+}
+
+// ConfigChange is a difference between two proxy configurations
+type ConfigChange struct {
+	// Section of the configuration, used as the restart reason
+	Section string
+
+	// Detail describes the change within the section
+	Detail string
+}
+
+func (c ConfigChange) String() string {
+	if c.Detail == "" {
+		return c.Section
+	}
+	return c.Section + ": " + c.Detail
+}
+
+// ConfigDiffer is implemented by the proxy configurations that can classify
+// the changes from the current configuration. Diff returns the changes that
+// require a proxy restart, or none if the proxy can keep running with the
+// current configuration.
+type ConfigDiffer interface {
+	Diff(current interface{}) []ConfigChange
+}
+
+// Histogram counts observations in buckets by upper bound
+type Histogram struct {
+	// Bounds are the upper bounds of all buckets except the last one, in
+	// seconds for the durations
+	Bounds []float64 `json:"bounds"`
+
+	// Counts are the observations in each bucket, with the last one for values
+	// exceeding all bounds
+	Counts []int `json:"counts"`
+
+	// Sum of all observations
+	Sum float64 `json:"sum"`
+}
+
+// NewHistogram creates an empty histogram with the bounds
+func NewHistogram(bounds ...float64) Histogram {
+	return Histogram{
+		Bounds: bounds,
+		Counts: make([]int, len(bounds)+1),
+	}
+}
+
+// Observe records a duration
+func (h *Histogram) Observe(d time.Duration) {
+	h.ObserveValue(d.Seconds())
+}
+
+// ObserveValue records a value
+func (h *Histogram) ObserveValue(v float64) {
+	i := sort.SearchFloat64s(h.Bounds, v)
+	h.Counts[i]++
+	h.Sum += v
+}
+
+func (h Histogram) copy() Histogram {
+	out := h
+	out.Counts = append([]int(nil), h.Counts...)
+	return out
 }
 
 var (
 	errAbort = errors.New("epoch aborted")
 
+	// restartBuckets are the bounds in seconds of the restart interval histogram
+	restartBuckets = []float64{1, 10, 60, 300, 1800, 3600}
+
 	// DefaultRetry configuration for proxies
 	DefaultRetry = Retry{
 		MaxRetries:      10,
@@ -86,13 +205,25 @@ const (
 
 // NewAgent creates a new proxy agent for the proxy start-up and clean-up functions.
 func NewAgent(proxy Proxy, retry Retry) Agent {
+	return NewGracefulAgent(proxy, retry, Drain{})
+}
+
+// NewGracefulAgent creates a new proxy agent that drains the proxy before
+// terminating the epochs.
+func NewGracefulAgent(proxy Proxy, retry Retry, drain Drain) Agent {
 	return &agent{
 		proxy:    &proxy,
 		retry:    &retry,
+		drain:    &drain,
 		epochs:   make(map[int]interface{}),
 		configCh: make(chan interface{}),
 		statusCh: make(chan exitStatus),
 		abortCh:  make(map[int]chan error),
+		status: AgentStatus{
+			Epoch:            -1,
+			RestartReasons:   make(map[string]int),
+			RestartIntervals: NewHistogram(restartBuckets...),
+		},
 	}
 }
 
@@ -112,6 +243,21 @@ type Retry struct {
 	InitialInterval time.Duration
 }
 
+// Drain configuration for the graceful shutdown of the proxy
+type Drain struct {
+	// Command asks the proxy epoch to drain its listeners. The agent terminates
+	// the epochs immediately if the command is not set.
+	Command func(int) error
+
+	// EndpointRemovalDelay is the time between failing the readiness checks and
+	// the drain command for the load balancers to remove the proxy endpoint
+	EndpointRemovalDelay time.Duration
+
+	// DrainDuration is the time from the drain command after which the agent
+	// aborts the remaining epochs
+	DrainDuration time.Duration
+}
+
 // Proxy defines command interface for a proxy
 type Proxy struct {
 	// Run command for a config, epoch, and abort channel
@@ -121,7 +267,9 @@ type Proxy struct {
 	Cleanup func(int)
 
 	// Panic command is invoked with the desired config when all retries to
-	// start the proxy fail just before the agent terminating
+	// start the proxy fail just before the agent terminating. Without a panic
+	// command, the agent keeps running and reports the exhausted budget in its
+	// status until the desired config changes.
 	Panic func(interface{})
 }
 
@@ -132,6 +280,9 @@ type agent struct {
 	// retry configuration
 	retry *Retry
 
+	// drain configuration
+	drain *Drain
+
 	// desired configuration state
 	desiredConfig interface{}
 
@@ -149,6 +300,16 @@ type agent struct {
 
 	// channel for aborting running instances
 	abortCh map[int]chan error
+
+	// started is set after the first proxy start
+	started bool
+
+	// lastStart is the time of the latest proxy start
+	lastStart time.Time
+
+	// status snapshot shared with the readers outside of the control loop
+	mu     sync.RWMutex
+	status AgentStatus
 }
 
 type exitStatus struct {
@@ -160,6 +321,31 @@ func (a *agent) ScheduleConfigUpdate(config interface{}) {
 	a.configCh <- config
 }
 
+func (a *agent) Status() AgentStatus {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	out := a.status
+	out.RestartReasons = make(map[string]int, len(a.status.RestartReasons))
+	for reason, count := range a.status.RestartReasons {
+		out.RestartReasons[reason] = count
+	}
+	out.RestartIntervals = a.status.RestartIntervals.copy()
+	return out
+}
+
+// updateStatus applies a change to the status snapshot and refreshes the
+// fields tracked by the control loop
+func (a *agent) updateStatus(update func(*AgentStatus)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if update != nil {
+		update(&a.status)
+	}
+	a.status.Epoch = a.latestEpoch()
+	a.status.RetryBudget = a.retry.budget
+	a.status.CurrentConfig = a.currentConfig
+}
+
 func (a *agent) Run(ctx context.Context) {
 	glog.V(2).Info("Starting proxy agent")
 
@@ -189,6 +375,7 @@ func (a *agent) Run(ctx context.Context) {
 				// reset retry budget if and only if the desired config changes
 				a.retry.budget = a.retry.MaxRetries
 				a.reconcile()
+				a.updateStatus(func(s *AgentStatus) { s.BudgetExhausted = false })
 			}
 
 		case status := <-a.statusCh:
@@ -202,6 +389,10 @@ func (a *agent) Run(ctx context.Context) {
 				glog.V(2).Infof("Epoch %d aborted", status.epoch)
 			} else if status.err != nil {
 				glog.Warningf("Epoch %d terminated with an error: %v", status.epoch, status.err)
+				a.updateStatus(func(s *AgentStatus) {
+					s.Failures++
+					s.LastExitError = status.err.Error()
+				})
 
 				// NOTE: due to Envoy hot restart race conditions, an error from the
 				// process requires aggressive non-graceful restarts by killing all
@@ -224,15 +415,18 @@ func (a *agent) Run(ctx context.Context) {
 					glog.V(2).Infof("Updated retry delay to %v, budget to %d", delayDuration, a.retry.budget)
 				} else {
 					glog.Error("Permanent error: budget exhausted trying to fulfill the desired configuration")
+					a.updateStatus(func(s *AgentStatus) { s.BudgetExhausted = true })
 					if a.proxy.Panic != nil {
 						a.proxy.Panic(a.desiredConfig)
+						return
 					}
-					return
 				}
 			}
+			a.updateStatus(nil)
 
 		case <-time.After(delay):
 			a.reconcile()
+			a.updateStatus(nil)
 
 		case _, more := <-ctx.Done():
 			if !more {
@@ -245,9 +439,51 @@ func (a *agent) Run(ctx context.Context) {
 
 func (a *agent) terminate() {
 	glog.V(2).Info("Agent terminating")
+	if a.drain.Command != nil && len(a.epochs) > 0 {
+		a.drainAll()
+	}
 	a.abortAll()
 }
 
+// drainAll fails the readiness of the agent, awaits the endpoint removal,
+// drains the latest epoch, and awaits the exit of all epochs until the end of
+// the drain duration
+func (a *agent) drainAll() {
+	a.updateStatus(func(s *AgentStatus) { s.Draining = true })
+	if !a.awaitEpochs(a.drain.EndpointRemovalDelay) {
+		return
+	}
+
+	epoch := a.latestEpoch()
+	glog.Infof("Draining epoch %d", epoch)
+	if err := a.drain.Command(epoch); err != nil {
+		glog.Warningf("Failed to drain epoch %d: %v", epoch, err)
+	}
+
+	if a.awaitEpochs(a.drain.DrainDuration) {
+		glog.Warningf("Drain duration elapsed with %d running epochs", len(a.epochs))
+	}
+}
+
+// awaitEpochs cleans up the exiting epochs for the duration and reports
+// whether any epochs are still running
+func (a *agent) awaitEpochs(duration time.Duration) bool {
+	elapsed := time.After(duration)
+	for len(a.epochs) > 0 {
+		select {
+		case <-elapsed:
+			return true
+		case status := <-a.statusCh:
+			glog.V(2).Infof("Epoch %d exited while draining: %v", status.epoch, status.err)
+			delete(a.epochs, status.epoch)
+			delete(a.abortCh, status.epoch)
+			a.proxy.Cleanup(status.epoch)
+			a.updateStatus(nil)
+		}
+	}
+	return false
+}
+
 func (a *agent) reconcile() {
 	// cancel any scheduled restart
 	a.retry.restart = nil
@@ -260,6 +496,19 @@ func (a *agent) reconcile() {
 		return
 	}
 
+	changes := a.configChanges()
+	if len(changes) == 0 {
+		// the latest epoch keeps running with the equivalent configuration
+		glog.V(2).Info("Desired configuration does not require a restart")
+		a.epochs[a.latestEpoch()] = a.desiredConfig
+		a.currentConfig = a.desiredConfig
+		a.updateStatus(func(s *AgentStatus) { s.SkippedRestarts++ })
+		return
+	}
+	for _, change := range changes {
+		glog.Infof("Proxy configuration change %v", change)
+	}
+
 	// discover and increment the latest running epoch
 	epoch := a.latestEpoch() + 1
 	// buffer aborts to prevent blocking on failing proxy
@@ -267,9 +516,38 @@ func (a *agent) reconcile() {
 	a.epochs[epoch] = a.desiredConfig
 	a.abortCh[epoch] = abortCh
 	a.currentConfig = a.desiredConfig
+	now := time.Now()
+	started, lastStart := a.started, a.lastStart
+	a.updateStatus(func(s *AgentStatus) {
+		if started {
+			s.Restarts++
+			s.RestartIntervals.Observe(now.Sub(lastStart))
+		}
+		sections := make(map[string]bool)
+		for _, change := range changes {
+			if !sections[change.Section] {
+				sections[change.Section] = true
+				s.RestartReasons[change.Section]++
+			}
+		}
+	})
+	a.started = true
+	a.lastStart = now
 	go a.waitForExit(a.desiredConfig, epoch, abortCh)
 }
 
+// configChanges lists the changes from the current to the desired
+// configuration that require a restart
+func (a *agent) configChanges() []ConfigChange {
+	if a.latestEpoch() < 0 {
+		return []ConfigChange{{Section: "start"}}
+	}
+	if differ, ok := a.desiredConfig.(ConfigDiffer); ok {
+		return differ.Diff(a.currentConfig)
+	}
+	return []ConfigChange{{Section: "config"}}
+}
+
 // waitForExit runs the start-up command as a go routine and waits for it to finish
 func (a *agent) waitForExit(config interface{}, epoch int, abortCh <-chan error) {
 	glog.V(2).Infof("Epoch %d starting", epoch)
@@ -18,6 +18,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -341,3 +342,187 @@ func TestCascadingAbort(t *testing.T) {
 	a.ScheduleConfigUpdate(2)
 	<-ctx.Done()
 }
+
+// TestStatus checks the status snapshot after a failed start and a successful retry
+func TestStatus(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	running := make(chan struct{})
+	tries := 0
+	start := func(config interface{}, epoch int, _ <-chan error) error {
+		tries++
+		if tries == 1 {
+			return errors.New("first try")
+		}
+		close(running)
+		<-ctx.Done()
+		return nil
+	}
+	a := NewAgent(Proxy{start, func(int) {}, nil}, testRetry)
+	if status := a.Status(); status.Epoch != -1 {
+		t.Errorf("Status() => got epoch %d before start, want -1", status.Epoch)
+	}
+	go a.Run(ctx)
+	a.ScheduleConfigUpdate("config")
+	<-running
+
+	status := a.Status()
+	if status.Epoch != 0 || status.Restarts != 1 || status.Failures != 1 ||
+		status.LastExitError != "first try" || status.BudgetExhausted || status.CurrentConfig != "config" {
+		t.Errorf("Status() => got %+v", status)
+	}
+}
+
+// TestDrain checks that the agent fails its readiness, drains the running epoch
+// after the endpoint removal delay, and aborts it after the drain duration
+func TestDrain(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	running := make(chan struct{})
+	var terminateStart, drainStart time.Time
+	aborted := make(chan time.Time, 1)
+	start := func(config interface{}, epoch int, abort <-chan error) error {
+		close(running)
+		err := <-abort
+		aborted <- time.Now()
+		return err
+	}
+	var a Agent
+	drained := -1
+	drain := Drain{
+		Command: func(epoch int) error {
+			drainStart = time.Now()
+			drained = epoch
+			if !a.Status().Draining {
+				t.Error("Expected the agent to report draining before the drain command")
+			}
+			return nil
+		},
+		EndpointRemovalDelay: 20 * time.Millisecond,
+		DrainDuration:        50 * time.Millisecond,
+	}
+	a = NewGracefulAgent(Proxy{start, func(int) {}, nil}, testRetry, drain)
+	done := make(chan struct{})
+	go func() {
+		a.Run(ctx)
+		close(done)
+	}()
+	a.ScheduleConfigUpdate("config")
+	<-running
+	terminateStart = time.Now()
+	cancel()
+	<-done
+
+	if drained != 0 {
+		t.Fatalf("Drain command got epoch %d, want 0", drained)
+	}
+	if delay := drainStart.Sub(terminateStart); delay < drain.EndpointRemovalDelay {
+		t.Errorf("Epoch drained %v after the termination, want at least %v", delay, drain.EndpointRemovalDelay)
+	}
+	abortTime := <-aborted
+	if duration := abortTime.Sub(drainStart); duration < drain.DrainDuration || duration > 5*time.Second {
+		t.Errorf("Epoch aborted %v after the drain, want %v", duration, drain.DrainDuration)
+	}
+}
+
+// TestDrainExit checks that the agent terminates once the draining epochs exit
+func TestDrainExit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	running := make(chan struct{})
+	exit := make(chan struct{})
+	start := func(config interface{}, epoch int, _ <-chan error) error {
+		close(running)
+		<-exit
+		return nil
+	}
+	cleaned := false
+	drain := Drain{
+		Command: func(int) error {
+			close(exit)
+			return nil
+		},
+		DrainDuration: time.Minute,
+	}
+	a := NewGracefulAgent(Proxy{start, func(int) { cleaned = true }, nil}, testRetry, drain)
+	done := make(chan struct{})
+	go func() {
+		a.Run(ctx)
+		close(done)
+	}()
+	a.ScheduleConfigUpdate("config")
+	<-running
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Agent did not terminate after the epoch exited")
+	}
+	if !cleaned {
+		t.Error("Expected cleanup of the drained epoch")
+	}
+	if status := a.Status(); status.Epoch != -1 || !status.Draining {
+		t.Errorf("Status() => got %+v", status)
+	}
+}
+
+type testConfig struct {
+	version int
+	restart bool
+}
+
+func (c testConfig) Diff(current interface{}) []ConfigChange {
+	if !c.restart {
+		return nil
+	}
+	return []ConfigChange{{Section: "test", Detail: fmt.Sprint(c.version)}, {Section: "test"}}
+}
+
+// TestSkipRestart checks that the agent restarts only for the changes reported by the config
+func TestSkipRestart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started := make(chan int, 10)
+	start := func(config interface{}, epoch int, _ <-chan error) error {
+		started <- epoch
+		<-ctx.Done()
+		return nil
+	}
+	a := NewAgent(Proxy{start, func(int) {}, nil}, testRetry)
+	go a.Run(ctx)
+
+	a.ScheduleConfigUpdate(testConfig{version: 1, restart: true})
+	if epoch := <-started; epoch != 0 {
+		t.Errorf("Started epoch %d, want 0", epoch)
+	}
+	a.ScheduleConfigUpdate(testConfig{version: 2})
+	a.ScheduleConfigUpdate(testConfig{version: 3, restart: true})
+	if epoch := <-started; epoch != 1 {
+		t.Errorf("Started epoch %d, want 1 after a skipped restart", epoch)
+	}
+
+	status := a.Status()
+	want := map[string]int{"start": 1, "test": 1}
+	if status.SkippedRestarts != 1 || status.Restarts != 1 || !reflect.DeepEqual(status.RestartReasons, want) {
+		t.Errorf("Status() => got %+v", status)
+	}
+	count := 0
+	for _, n := range status.RestartIntervals.Counts {
+		count += n
+	}
+	if count != 1 || status.RestartIntervals.Counts[0] != 1 {
+		t.Errorf("Status() => got restart intervals %+v, want one observation", status.RestartIntervals)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram(1, 10)
+	for _, d := range []time.Duration{500 * time.Millisecond, time.Second, 5 * time.Second, time.Minute} {
+		h.Observe(d)
+	}
+	if want := []int{2, 1, 1}; !reflect.DeepEqual(h.Counts, want) {
+		t.Errorf("Observe() => got counts %v, want %v", h.Counts, want)
+	}
+	if h.Sum != 66.5 {
+		t.Errorf("Observe() => got sum %v, want 66.5", h.Sum)
+	}
+}
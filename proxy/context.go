@@ -16,8 +16,10 @@ package proxy
 
 import (
 	"errors"
+	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
@@ -43,6 +45,9 @@ type Environment struct {
 
 	// Mesh is the mesh config (to be merged into the config store)
 	Mesh *proxyconfig.ProxyMeshConfig
+
+	// Isolation restricts the services visible to sidecar proxies
+	Isolation model.Isolation
 }
 
 // Node defines the proxy attributes used by xDS identification
@@ -76,6 +81,36 @@ const (
 	Egress NodeType = "egress"
 )
 
+var (
+	nodeTypesMutex sync.RWMutex
+
+	// nodeTypes are the proxy types accepted in the service nodes
+	nodeTypes = map[NodeType]bool{Sidecar: true, Ingress: true, Egress: true}
+)
+
+// RegisterNodeType adds a custom proxy type to the types accepted in the
+// service nodes. The discovery service must know how to configure the type.
+func RegisterNodeType(t NodeType) {
+	nodeTypesMutex.Lock()
+	defer nodeTypesMutex.Unlock()
+	nodeTypes[t] = true
+}
+
+// UnregisterNodeType removes a custom proxy type from the types accepted in the
+// service nodes
+func UnregisterNodeType(t NodeType) {
+	nodeTypesMutex.Lock()
+	defer nodeTypesMutex.Unlock()
+	delete(nodeTypes, t)
+}
+
+// IsNodeType checks that the proxy type is built-in or registered
+func IsNodeType(t NodeType) bool {
+	nodeTypesMutex.RLock()
+	defer nodeTypesMutex.RUnlock()
+	return nodeTypes[t]
+}
+
 // ServiceNode encodes the proxy node attributes into a URI-acceptable string
 func (node Node) ServiceNode() string {
 	return strings.Join([]string{
@@ -94,6 +129,9 @@ func ParseServiceNode(s string) (Node, error) {
 	}
 
 	out.Type = NodeType(parts[0])
+	if !IsNodeType(out.Type) {
+		return out, fmt.Errorf("unknown proxy type %q", parts[0])
+	}
 	out.IPAddress = parts[1]
 	out.ID = parts[2]
 	out.Domain = parts[3]
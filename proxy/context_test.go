@@ -57,6 +57,18 @@ func TestServiceNode(t *testing.T) {
 	}
 }
 
+func TestParseServiceNodeType(t *testing.T) {
+	if _, err := proxy.ParseServiceNode("gateway~10.1.1.0~gw.default~default.svc.cluster.local"); err == nil {
+		t.Error("ParseServiceNode() => expected an error for an unknown proxy type")
+	}
+	proxy.RegisterNodeType("gateway")
+	defer proxy.UnregisterNodeType("gateway")
+	node, err := proxy.ParseServiceNode("gateway~10.1.1.0~gw.default~default.svc.cluster.local")
+	if err != nil || node.Type != "gateway" || node.IPAddress != "10.1.1.0" {
+		t.Errorf("ParseServiceNode() => got %#v, %v", node, err)
+	}
+}
+
 func TestParsePort(t *testing.T) {
 	if port := proxy.ParsePort("localhost:3000"); port != 3000 {
 		t.Errorf("ParsePort(localhost:3000) => Got %d, want 3000", port)
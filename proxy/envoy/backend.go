@@ -0,0 +1,225 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/ptypes/duration"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/pmezard/go-difflib/difflib"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+)
+
+// Backend launches the proxy for an epoch of the configuration. The watcher
+// writes the configuration of each epoch to a file and hands it over to the
+// backend. Run must block until the proxy exits or an error is received on the
+// abort channel, in which case the backend must stop the proxy and return the
+// abort error.
+type Backend interface {
+	Run(args BackendArgs, abort <-chan error) error
+}
+
+// BackendArgs are the parameters of a proxy epoch. The argument templates of
+// the binary backend are evaluated against these parameters.
+type BackendArgs struct {
+	// ConfigFile is the path to the configuration file of the epoch
+	ConfigFile string
+
+	// Epoch is the restart epoch
+	Epoch int
+
+	// ServiceNode is the proxy node identifier
+	ServiceNode string
+
+	// Mesh is the mesh configuration
+	Mesh *proxyconfig.ProxyMeshConfig
+}
+
+// Backend names selectable in the proxy agent
+const (
+	// BinaryBackendName runs the proxy binary
+	BinaryBackendName = "binary"
+
+	// DryRunBackendName only writes and compares the configuration files
+	DryRunBackendName = "dry-run"
+)
+
+// argFuncs are the functions available in the argument templates
+var argFuncs = template.FuncMap{
+	"seconds": func(d *duration.Duration) int {
+		return int(convertDuration(d) / time.Second)
+	},
+}
+
+// BinaryBackend runs a proxy binary for each epoch
+type BinaryBackend struct {
+	// path to the proxy binary
+	path string
+
+	// args are the argument templates, or nil for the default Envoy arguments
+	args []*template.Template
+}
+
+// NewBinaryBackend creates a backend for the binary at the path. The arguments
+// are text templates evaluated against BackendArgs with the extra function
+// "seconds" to format durations, e.g. "{{seconds .Mesh.DrainDuration}}".
+// Envoy arguments apply if no arguments are provided.
+func NewBinaryBackend(path string, args []string) (*BinaryBackend, error) {
+	out := &BinaryBackend{path: path}
+	for i, arg := range args {
+		tmpl, err := template.New(fmt.Sprintf("arg%d", i)).Funcs(argFuncs).Parse(arg)
+		if err != nil {
+			return nil, multierror.Prefix(err, "invalid argument template "+arg)
+		}
+		out.args = append(out.args, tmpl)
+	}
+	return out, nil
+}
+
+// Args evaluates the command line arguments for an epoch
+func (b *BinaryBackend) Args(a BackendArgs) ([]string, error) {
+	if b.args == nil {
+		args := envoyArgs(a.ConfigFile, a.Epoch, a.Mesh, a.ServiceNode)
+
+		// inject tracing flag for higher levels
+		if glog.V(4) {
+			args = append(args, "-l", "trace")
+		} else if glog.V(3) {
+			args = append(args, "-l", "debug")
+		}
+		return args, nil
+	}
+
+	out := make([]string, 0, len(b.args))
+	for _, tmpl := range b.args {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, a); err != nil {
+			return nil, err
+		}
+		out = append(out, buf.String())
+	}
+	return out, nil
+}
+
+// Run starts the binary and kills it on abort
+func (b *BinaryBackend) Run(a BackendArgs, abort <-chan error) error {
+	args, err := b.Args(a)
+	if err != nil {
+		return err
+	}
+
+	glog.V(2).Infof("Proxy command: %s %v", b.path, args)
+
+	/* #nosec */
+	cmd := exec.Command(b.path, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-abort:
+		glog.Warningf("Aborting epoch %d", a.Epoch)
+		if errKill := cmd.Process.Kill(); errKill != nil {
+			glog.Warningf("killing epoch %d caused an error %v", a.Epoch, errKill)
+		}
+		return err
+	case err := <-done:
+		return err
+	}
+}
+
+// DryRunBackend does not start any process. It logs the differences between
+// the configuration files of the consecutive epochs and holds the epoch until
+// the next epoch starts, like the hot restart of Envoy shuts down the parent
+// epoch, or until it is aborted. The epochs do not serve the admin API. The
+// backend is useful to validate the generated configuration offline.
+type DryRunBackend struct {
+	mu       sync.Mutex
+	previous string
+
+	// superseded is closed when the next epoch starts
+	superseded chan struct{}
+}
+
+// NewDryRunBackend creates a dry-run backend
+func NewDryRunBackend() *DryRunBackend {
+	return &DryRunBackend{}
+}
+
+// Run logs the configuration changes and waits for the next epoch or the abort
+func (b *DryRunBackend) Run(a BackendArgs, abort <-chan error) error {
+	data, err := ioutil.ReadFile(a.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	diff, err := configDiff(b.previous, string(data))
+	b.previous = string(data)
+	if b.superseded != nil {
+		close(b.superseded)
+	}
+	superseded := make(chan struct{})
+	b.superseded = superseded
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if diff == "" {
+		glog.Infof("Dry run epoch %d: configuration %s is unchanged", a.Epoch, a.ConfigFile)
+	} else {
+		glog.Infof("Dry run epoch %d: configuration %s changed:\n%s", a.Epoch, a.ConfigFile, diff)
+	}
+
+	select {
+	case err := <-abort:
+		return err
+	case <-superseded:
+		glog.V(2).Infof("Dry run epoch %d: superseded by the next epoch", a.Epoch)
+		return nil
+	}
+}
+
+// configDiff produces a unified diff between two configurations
+func configDiff(previous, current string) (string, error) {
+	if previous == current {
+		return "", nil
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(previous),
+		B:        difflib.SplitLines(current),
+		FromFile: "previous",
+		ToFile:   "current",
+		Context:  2,
+	})
+}
@@ -0,0 +1,163 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/pilot/proxy"
+)
+
+func TestBinaryBackendArgs(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	args := BackendArgs{ConfigFile: "test.json", Epoch: 5, ServiceNode: "my-proxy", Mesh: &mesh}
+
+	backend, err := NewBinaryBackend(BinaryPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := backend.Args(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := envoyArgs("test.json", 5, &mesh, "my-proxy"); !reflect.DeepEqual(got, want) {
+		t.Errorf("default Args() => got %v, want %v", got, want)
+	}
+
+	backend, err = NewBinaryBackend("/bin/proxy", []string{
+		"--config={{.ConfigFile}}",
+		"--epoch={{.Epoch}}",
+		"--drain={{seconds .Mesh.DrainDuration}}",
+		"--node={{.ServiceNode}}.{{.Mesh.IstioServiceCluster}}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = backend.Args(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"--config=test.json", "--epoch=5", "--drain=2", "--node=my-proxy." + mesh.IstioServiceCluster}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("template Args() => got %v, want %v", got, want)
+	}
+
+	if _, err = NewBinaryBackend("/bin/proxy", []string{"{{.ConfigFile"}); err == nil {
+		t.Error("expected an error for a malformed template")
+	}
+	backend, err = NewBinaryBackend("/bin/proxy", []string{"{{.Unknown}}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = backend.Args(args); err == nil {
+		t.Error("expected an error for an unknown field")
+	}
+}
+
+func TestDryRunBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dryrun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	backend := NewDryRunBackend()
+	errStop := errors.New("stop")
+	for epoch, content := range []string{"a\nb\n", "a\nc\n"} {
+		fname := configFile(dir, epoch)
+		if err = ioutil.WriteFile(fname, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		abort := make(chan error, 1)
+		abort <- errStop
+		if err = backend.Run(BackendArgs{ConfigFile: fname, Epoch: epoch}, abort); err != errStop {
+			t.Errorf("Run() => got %v, want the abort error", err)
+		}
+	}
+	if backend.previous != "a\nc\n" {
+		t.Errorf("Run() did not record the configuration, got %q", backend.previous)
+	}
+
+	if err = backend.Run(BackendArgs{ConfigFile: path.Join(dir, "missing.json")}, nil); err == nil {
+		t.Error("expected an error for a missing configuration file")
+	}
+}
+
+func TestDryRunBackendSuperseded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dryrun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	backend := NewDryRunBackend()
+	for epoch := 0; epoch < 2; epoch++ {
+		if err = ioutil.WriteFile(configFile(dir, epoch), []byte("a\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the first epoch exits on its own once the next epoch starts
+	done := make(chan error, 1)
+	go func() {
+		done <- backend.Run(BackendArgs{ConfigFile: configFile(dir, 0), Epoch: 0}, make(chan error))
+	}()
+	for {
+		backend.mu.Lock()
+		started := backend.superseded != nil
+		backend.mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	abort := make(chan error, 1)
+	errStop := errors.New("stop")
+	abort <- errStop
+	if err = backend.Run(BackendArgs{ConfigFile: configFile(dir, 1), Epoch: 1}, abort); err != errStop {
+		t.Errorf("Run() => got %v, want the abort error", err)
+	}
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Errorf("Run() of the superseded epoch => got %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("the superseded epoch did not exit")
+	}
+}
+
+func TestConfigDiff(t *testing.T) {
+	diff, err := configDiff("a\nb\n", "a\nb\n")
+	if err != nil || diff != "" {
+		t.Errorf("configDiff() of equal configurations => got %q, %v", diff, err)
+	}
+
+	diff, err = configDiff("a\nb\n", "a\nc\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(diff, "-b\n") || !strings.Contains(diff, "+c\n") {
+		t.Errorf("configDiff() => got %q", diff)
+	}
+}
@@ -114,28 +114,16 @@ func buildConfig(listeners Listeners, clusters Clusters, lds bool, mesh *proxyco
 
 // buildListeners produces a list of listeners and referenced clusters for all proxies
 func buildListeners(env proxy.Environment, role proxy.Node) Listeners {
-	switch role.Type {
-	case proxy.Sidecar:
-		listeners, _ := buildSidecar(env, role)
-		return listeners
-	case proxy.Ingress:
-		return buildIngressListeners(env.Mesh, env.ServiceDiscovery, env.IstioConfigStore, role)
-	case proxy.Egress:
-		return buildEgressListeners(env.Mesh, role)
+	g, exists := generatorFor(role.Type)
+	if !exists {
+		return nil
 	}
-	return nil
+	return g.Listeners(env, role)
 }
 
 func buildClusters(env proxy.Environment, role proxy.Node) (clusters Clusters) {
-	switch role.Type {
-	case proxy.Sidecar:
-		_, clusters = buildSidecar(env, role)
-	case proxy.Ingress:
-		httpRouteConfigs, _ := buildIngressRoutes(env.Mesh, env.ServiceDiscovery, env.IstioConfigStore)
-		clusters = httpRouteConfigs.clusters().normalize()
-	case proxy.Egress:
-		httpRouteConfigs := buildEgressRoutes(env.Mesh, env.ServiceDiscovery)
-		clusters = httpRouteConfigs.clusters().normalize()
+	if g, exists := generatorFor(role.Type); exists {
+		clusters = g.Clusters(env, role)
 	}
 
 	// apply custom policies for outbound clusters
@@ -151,13 +139,32 @@ func buildClusters(env proxy.Environment, role proxy.Node) (clusters Clusters) {
 	return clusters
 }
 
+// sidecarGenerator configures the sidecar proxies
+type sidecarGenerator struct{}
+
+func (sidecarGenerator) Listeners(env proxy.Environment, node proxy.Node) Listeners {
+	listeners, _ := buildSidecar(env, node)
+	return listeners
+}
+
+func (sidecarGenerator) Clusters(env proxy.Environment, node proxy.Node) Clusters {
+	_, clusters := buildSidecar(env, node)
+	return clusters
+}
+
+func (sidecarGenerator) Routes(env proxy.Environment, node proxy.Node) HTTPRouteConfigs {
+	instances := env.HostInstances(map[string]bool{node.IPAddress: true})
+	services := visibleServices(env, node)
+	return buildOutboundHTTPRoutes(env.Mesh, node, instances, services, env.IstioConfigStore)
+}
+
 // buildSidecar produces a list of listeners and referenced clusters for sidecar proxies
 // TODO: this implementation is inefficient as it is recomputing all the routes for all proxies
 // There is a lot of potential to cache and reuse cluster definitions across proxies and also
 // skip computing the actual HTTP routes
 func buildSidecar(env proxy.Environment, sidecar proxy.Node) (Listeners, Clusters) {
 	instances := env.HostInstances(map[string]bool{sidecar.IPAddress: true})
-	services := env.Services()
+	services := visibleServices(env, sidecar)
 	managementPorts := env.ManagementPorts(sidecar.IPAddress)
 	listeners := make(Listeners, 0)
 	clusters := make(Clusters, 0)
@@ -217,25 +224,23 @@ func buildSidecar(env proxy.Environment, sidecar proxy.Node) (Listeners, Cluster
 	return listeners.normalize(), clusters.normalize()
 }
 
+// visibleServices lists the services visible to the sidecar proxy in the
+// namespace of its DNS domain
+func visibleServices(env proxy.Environment, sidecar proxy.Node) []*model.Service {
+	namespace := strings.Split(sidecar.Domain, ".")[0]
+	return env.Isolation.VisibleServices(namespace, env.Services())
+}
+
 // buildRDSRoutes supplies RDS-enabled HTTP routes
 // The route name is assumed to be the port number used by the route in the
 // listener, or the special value for _all routes_.
 // TODO: this can be optimized by querying for a specific HTTP port in the table
-func buildRDSRoute(mesh *proxyconfig.ProxyMeshConfig, role proxy.Node, routeName string,
-	discovery model.ServiceDiscovery, config model.IstioConfigStore) *HTTPRouteConfig {
-	var configs HTTPRouteConfigs
-	switch role.Type {
-	case proxy.Ingress:
-		configs, _ = buildIngressRoutes(mesh, discovery, config)
-	case proxy.Egress:
-		configs = buildEgressRoutes(mesh, discovery)
-	case proxy.Sidecar:
-		instances := discovery.HostInstances(map[string]bool{role.IPAddress: true})
-		services := discovery.Services()
-		configs = buildOutboundHTTPRoutes(mesh, role, instances, services, config)
-	default:
+func buildRDSRoute(env proxy.Environment, role proxy.Node, routeName string) *HTTPRouteConfig {
+	g, exists := generatorFor(role.Type)
+	if !exists {
 		return nil
 	}
+	configs := g.Routes(env, role)
 
 	if routeName == RDSAll {
 		return configs.combine()
@@ -495,6 +500,11 @@ func buildOutboundTCPListeners(mesh *proxyconfig.ProxyMeshConfig, services []*mo
 		if service.External() {
 			continue // TODO TCP external services not currently supported
 		}
+		if service.Address == "" {
+			// headless services are reached at the addresses of their endpoints,
+			// which the registries list as separate services
+			continue
+		}
 		for _, servicePort := range service.Ports {
 			switch servicePort.Protocol {
 			case model.ProtocolTCP, model.ProtocolHTTPS:
@@ -0,0 +1,135 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"github.com/golang/glog"
+
+	"istio.io/pilot/proxy"
+)
+
+// Configuration sections reported as the restart reasons
+const (
+	sectionRuntime        = "runtime"
+	sectionListeners      = "listeners"
+	sectionLDS            = "lds"
+	sectionAdmin          = "admin"
+	sectionClusters       = "clusters"
+	sectionClusterManager = "cluster_manager"
+	sectionStatsd         = "statsd"
+	sectionTracing        = "tracing"
+	sectionCertificates   = "certificates"
+)
+
+// Diff compares the configuration to the current one by sections. Envoy reads
+// the configuration file and the referenced certificates only on start-up, so
+// any change observable by Envoy requires a hot restart. Changes that do not
+// alter the serialized configuration, e.g. a nil versus an empty collection,
+// are skipped.
+func (conf *Config) Diff(current interface{}) []proxy.ConfigChange {
+	prev, ok := current.(*Config)
+	if !ok || prev == nil {
+		return []proxy.ConfigChange{{Section: "config", Detail: "unknown current configuration"}}
+	}
+
+	out := make([]proxy.ConfigChange, 0)
+	section := func(name string, a, b interface{}) {
+		if !jsonEqual(a, b) {
+			out = append(out, proxy.ConfigChange{Section: name, Detail: "changed"})
+		}
+	}
+
+	section(sectionRuntime, prev.RootRuntime, conf.RootRuntime)
+	out = append(out, diffNamed(sectionListeners, listenersByAddress(prev.Listeners),
+		listenersByAddress(conf.Listeners))...)
+	section(sectionLDS, prev.LDS, conf.LDS)
+	section(sectionAdmin, prev.Admin, conf.Admin)
+	out = append(out, diffNamed(sectionClusters, clustersByName(prev.ClusterManager.Clusters),
+		clustersByName(conf.ClusterManager.Clusters))...)
+	section(sectionClusterManager,
+		ClusterManager{SDS: prev.ClusterManager.SDS, CDS: prev.ClusterManager.CDS},
+		ClusterManager{SDS: conf.ClusterManager.SDS, CDS: conf.ClusterManager.CDS})
+	section(sectionStatsd, prev.StatsdUDPIPAddress, conf.StatsdUDPIPAddress)
+	section(sectionTracing, prev.Tracing, conf.Tracing)
+	if !bytes.Equal(prev.Hash, conf.Hash) {
+		out = append(out, proxy.ConfigChange{Section: sectionCertificates, Detail: "hash changed"})
+	}
+
+	return out
+}
+
+func listenersByAddress(listeners Listeners) map[string]interface{} {
+	out := make(map[string]interface{}, len(listeners))
+	for _, listener := range listeners {
+		out[listener.Address] = listener
+	}
+	return out
+}
+
+func clustersByName(clusters Clusters) map[string]interface{} {
+	out := make(map[string]interface{}, len(clusters))
+	for _, cluster := range clusters {
+		out[cluster.Name] = cluster
+	}
+	return out
+}
+
+// diffNamed reports the added, removed, and modified elements of a section
+func diffNamed(name string, prev, next map[string]interface{}) []proxy.ConfigChange {
+	keys := make([]string, 0, len(prev)+len(next))
+	for key := range prev {
+		keys = append(keys, key)
+	}
+	for key := range next {
+		if _, exists := prev[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	out := make([]proxy.ConfigChange, 0)
+	for _, key := range keys {
+		a, inPrev := prev[key]
+		b, inNext := next[key]
+		switch {
+		case !inPrev:
+			out = append(out, proxy.ConfigChange{Section: name, Detail: key + " added"})
+		case !inNext:
+			out = append(out, proxy.ConfigChange{Section: name, Detail: key + " removed"})
+		case !jsonEqual(a, b):
+			out = append(out, proxy.ConfigChange{Section: name, Detail: key + " changed"})
+		}
+	}
+	return out
+}
+
+// jsonEqual compares the serialized values
+func jsonEqual(a, b interface{}) bool {
+	x, err := json.Marshal(a)
+	if err != nil {
+		glog.Warningf("failed to serialize %#v: %v", a, err)
+		return false
+	}
+	y, err := json.Marshal(b)
+	if err != nil {
+		glog.Warningf("failed to serialize %#v: %v", b, err)
+		return false
+	}
+	return bytes.Equal(x, y)
+}
@@ -0,0 +1,84 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"reflect"
+	"testing"
+
+	"istio.io/pilot/proxy"
+)
+
+func TestDiff(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	base := func() *Config {
+		return buildConfig(Listeners{
+			{Address: "tcp://0.0.0.0:80", Name: "http"},
+			{Address: "tcp://0.0.0.0:81", Name: "tcp"},
+		}, Clusters{{Name: "a"}, {Name: "b"}}, true, &mesh)
+	}
+
+	cases := []struct {
+		name   string
+		modify func(*Config)
+		want   []proxy.ConfigChange
+	}{
+		{
+			name:   "equivalent",
+			modify: func(c *Config) { c.ClusterManager.Clusters[0].Hosts = []Host{} },
+			want:   []proxy.ConfigChange{},
+		},
+		{
+			name: "listeners",
+			modify: func(c *Config) {
+				c.Listeners[0].BindToPort = !c.Listeners[0].BindToPort
+				c.Listeners = append(c.Listeners[:1], &Listener{Address: "tcp://0.0.0.0:82"})
+			},
+			want: []proxy.ConfigChange{
+				{Section: sectionListeners, Detail: "tcp://0.0.0.0:80 changed"},
+				{Section: sectionListeners, Detail: "tcp://0.0.0.0:81 removed"},
+				{Section: sectionListeners, Detail: "tcp://0.0.0.0:82 added"},
+			},
+		},
+		{
+			name: "clusters and admin",
+			modify: func(c *Config) {
+				c.ClusterManager.Clusters[1].ConnectTimeoutMs++
+				c.Admin.Address = "tcp://127.0.0.1:1"
+			},
+			want: []proxy.ConfigChange{
+				{Section: sectionAdmin, Detail: "changed"},
+				{Section: sectionClusters, Detail: "b changed"},
+			},
+		},
+		{
+			name:   "certificates",
+			modify: func(c *Config) { c.Hash = []byte{1} },
+			want:   []proxy.ConfigChange{{Section: sectionCertificates, Detail: "hash changed"}},
+		},
+	}
+
+	for _, c := range cases {
+		next := base()
+		c.modify(next)
+		if got := next.Diff(base()); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: Diff() => got %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	if got := base().Diff("other"); len(got) != 1 {
+		t.Errorf("Diff() of an unknown configuration => got %v, want a change", got)
+	}
+}
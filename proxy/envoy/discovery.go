@@ -21,6 +21,7 @@ import (
 	"net/http/pprof"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 
@@ -47,6 +48,13 @@ type DiscoveryService struct {
 	cdsCache *discoveryCache
 	rdsCache *discoveryCache
 	ldsCache *discoveryCache
+
+	metrics *discoveryMetrics
+	proxies *proxyRegistry
+
+	// syncStatus reports the synchronization of the clusters of a
+	// multi-cluster service registry, or is nil for other registries
+	syncStatus func() map[string]bool
 }
 
 type discoveryCacheStatEntry struct {
@@ -197,6 +205,8 @@ func NewDiscoveryService(ctl model.Controller, configCache model.ConfigStoreCach
 		cdsCache:    newDiscoveryCache(o.EnableCaching),
 		rdsCache:    newDiscoveryCache(o.EnableCaching),
 		ldsCache:    newDiscoveryCache(o.EnableCaching),
+		metrics:     newDiscoveryMetrics(),
+		proxies:     newProxyRegistry(),
 	}
 	container := restful.NewContainer()
 	if o.EnableProfiling {
@@ -211,20 +221,31 @@ func NewDiscoveryService(ctl model.Controller, configCache model.ConfigStoreCach
 
 	// Flush cached discovery responses whenever services, service
 	// instances, or routing configuration changes.
-	serviceHandler := func(s *model.Service, e model.Event) { out.clearCache() }
+	serviceHandler := func(s *model.Service, e model.Event) { out.clearCacheOn(triggerService) }
 	if err := ctl.AppendServiceHandler(serviceHandler); err != nil {
 		return nil, err
 	}
-	instanceHandler := func(s *model.ServiceInstance, e model.Event) { out.clearCache() }
+	instanceHandler := func(s *model.ServiceInstance, e model.Event) { out.clearCacheOn(triggerInstance) }
 	if err := ctl.AppendInstanceHandler(instanceHandler); err != nil {
 		return nil, err
 	}
 
 	if configCache != nil {
-		configHandler := func(model.Config, model.Event) { out.clearCache() }
+		configHandler := func(config model.Config, _ model.Event) { out.clearCacheOn(config.Type) }
 		configCache.RegisterEventHandler(model.RouteRule.Type, configHandler)
 		configCache.RegisterEventHandler(model.IngressRule.Type, configHandler)
 		configCache.RegisterEventHandler(model.DestinationPolicy.Type, configHandler)
+		out.metrics.synced["config"] = configCache.HasSynced
+	}
+	if synced, ok := ctl.(interface {
+		HasSynced() bool
+	}); ok {
+		out.metrics.synced["services"] = synced.HasSynced
+	}
+	if status, ok := ctl.(interface {
+		SyncStatus() map[string]bool
+	}); ok {
+		out.syncStatus = status.SyncStatus
 	}
 
 	return out, nil
@@ -246,6 +267,7 @@ func (ds *DiscoveryService) Register(container *restful.Container) {
 	ws.Route(ws.
 		GET(fmt.Sprintf("/v1/registration/{%s}", ServiceKey)).
 		To(ds.ListEndpoints).
+		Filter(ds.metrics.filter(sdsType)).
 		Doc("SDS registration").
 		Param(ws.PathParameter(ServiceKey, "tuple of service name and tag name").DataType("string")))
 
@@ -254,6 +276,7 @@ func (ds *DiscoveryService) Register(container *restful.Container) {
 	ws.Route(ws.
 		GET(fmt.Sprintf("/v1/clusters/{%s}/{%s}", ServiceCluster, ServiceNode)).
 		To(ds.ListClusters).
+		Filter(ds.metrics.filter(cdsType)).
 		Doc("CDS registration").
 		Param(ws.PathParameter(ServiceCluster, "client proxy service cluster").DataType("string")).
 		Param(ws.PathParameter(ServiceNode, "client proxy service node").DataType("string")))
@@ -263,6 +286,7 @@ func (ds *DiscoveryService) Register(container *restful.Container) {
 	ws.Route(ws.
 		GET(fmt.Sprintf("/v1/routes/{%s}/{%s}/{%s}", RouteConfigName, ServiceCluster, ServiceNode)).
 		To(ds.ListRoutes).
+		Filter(ds.metrics.filter(rdsType)).
 		Doc("RDS registration").
 		Param(ws.PathParameter(RouteConfigName, "route configuration name").DataType("string")).
 		Param(ws.PathParameter(ServiceCluster, "client proxy service cluster").DataType("string")).
@@ -273,6 +297,7 @@ func (ds *DiscoveryService) Register(container *restful.Container) {
 	ws.Route(ws.
 		GET(fmt.Sprintf("/v1/listeners/{%s}/{%s}", ServiceCluster, ServiceNode)).
 		To(ds.ListListeners).
+		Filter(ds.metrics.filter(ldsType)).
 		Doc("LDS registration").
 		Param(ws.PathParameter(ServiceCluster, "client proxy service cluster").DataType("string")).
 		Param(ws.PathParameter(ServiceNode, "client proxy service node").DataType("string")))
@@ -284,7 +309,34 @@ func (ds *DiscoveryService) Register(container *restful.Container) {
 		To(ds.ListSecret).
 		Doc("List TLS secret URI for a listener").
 		Param(ws.PathParameter(ServiceCluster, "client proxy service cluster").DataType("string")).
-		Param(ws.PathParameter(ServiceNode, "client proxy service node").DataType("string")))
+		Param(ws.PathParameter(ServiceNode, "client proxy service node").DataType("string")).
+		Param(ws.QueryParameter(SecretWaitParam, "long polling duration for a secret matching If-None-Match").
+			DataType("string")).
+		Writes(SecretResponse{}))
+
+	ws.Route(ws.
+		GET("/v1/debug/validation").
+		To(ds.AnalyzeConfig).
+		Doc("Cross-object analysis of the configuration against the service registry").
+		Writes(model.Diagnostics{}))
+
+	ws.Route(ws.
+		GET("/v1/debug/proxies").
+		To(ds.ListProxies).
+		Doc("Proxies that recently requested configuration and whether their configuration is stale").
+		Writes([]ProxyStatus{}))
+
+	ws.Route(ws.
+		GET("/v1/debug/conflicts").
+		To(ds.RouteRuleConflicts).
+		Doc("Route rules with the same precedence and overlapping match conditions").
+		Writes([]model.RouteRuleConflict{}))
+
+	ws.Route(ws.
+		GET("/v1/debug/sync").
+		To(ds.SyncStatus).
+		Doc("Whether each cluster of a multi-cluster service registry completed its initial synchronization").
+		Writes(map[string]bool{}))
 
 	ws.Route(ws.
 		GET("/cache_stats").
@@ -298,6 +350,9 @@ func (ds *DiscoveryService) Register(container *restful.Container) {
 		Doc("Clear discovery service cache stats"))
 
 	container.Add(ws)
+
+	// Prometheus metrics are served in the text format outside of the JSON web service
+	container.ServeMux.HandleFunc("/metrics", ds.Metrics)
 }
 
 // Run starts the server and blocks
@@ -336,12 +391,88 @@ func (ds *DiscoveryService) ClearCacheStats(_ *restful.Request, _ *restful.Respo
 	ds.ldsCache.resetStats()
 }
 
+// AnalyzeConfig responds with the findings of the semantic analysis of the
+// configuration against the service registry.
+func (ds *DiscoveryService) AnalyzeConfig(_ *restful.Request, response *restful.Response) {
+	if err := response.WriteEntity(model.AnalyzeConfig(ds.IstioConfigStore, ds.ServiceDiscovery)); err != nil {
+		glog.Warning(err)
+	}
+}
+
+// RouteRuleConflicts responds with the pairs of route rules whose order of
+// application is decided by their configuration keys.
+func (ds *DiscoveryService) RouteRuleConflicts(_ *restful.Request, response *restful.Response) {
+	if err := response.WriteEntity(model.RouteRuleConflicts(ds.RouteRules())); err != nil {
+		glog.Warning(err)
+	}
+}
+
+// SyncStatus responds with the synchronization status of the clusters by
+// name, which is empty unless the service registry spans several clusters
+func (ds *DiscoveryService) SyncStatus(_ *restful.Request, response *restful.Response) {
+	out := map[string]bool{}
+	if ds.syncStatus != nil {
+		out = ds.syncStatus()
+	}
+	if err := response.WriteEntity(out); err != nil {
+		glog.Warning(err)
+	}
+}
+
+// ProxyCounts counts the proxies that include each configuration object in
+// their configuration. The proxies are the ones that recently requested
+// configuration, and each proxy counts the objects selected for it.
+func (ds *DiscoveryService) ProxyCounts() map[string]int {
+	out := make(map[string]int)
+	routeRules := ds.RouteRules()
+	var ingressKeys []string
+
+	for _, status := range ds.proxies.list(time.Now()) {
+		node, err := proxy.ParseServiceNode(status.ServiceNode)
+		if err != nil {
+			continue
+		}
+
+		switch node.Type {
+		case proxy.Sidecar:
+			instances := ds.HostInstances(map[string]bool{node.IPAddress: true})
+			namespace := strings.Split(node.Domain, ".")[0]
+			for ruleKey, rule := range routeRules {
+				if model.MatchSource(rule, instances) &&
+					ds.Isolation.Visible(model.DestinationNamespace(ds.ServiceDiscovery, rule.Destination), namespace) {
+					out[ruleKey]++
+				}
+			}
+			for _, service := range visibleServices(ds.Environment, node) {
+				if config, exists := ds.DestinationPolicyConfig(service.Hostname); exists {
+					out[config.Key()]++
+				}
+			}
+		case proxy.Ingress:
+			if ingressKeys == nil {
+				ingressKeys = appliedIngressRules(ds.Mesh, ds.ServiceDiscovery, ds.IstioConfigStore)
+			}
+			for _, ruleKey := range ingressKeys {
+				out[ruleKey]++
+			}
+		}
+	}
+	return out
+}
+
+// clearCacheOn flushes the cache on an event of the trigger type
+func (ds *DiscoveryService) clearCacheOn(trigger string) {
+	ds.metrics.observeClear(trigger)
+	ds.clearCache()
+}
+
 func (ds *DiscoveryService) clearCache() {
 	glog.Infof("Cleared discovery service cache")
 	ds.sdsCache.clear()
 	ds.cdsCache.clear()
 	ds.rdsCache.clear()
 	ds.ldsCache.clear()
+	ds.proxies.invalidate()
 }
 
 // ListAllEndpoints responds with all Services and is not restricted to a single service-key
@@ -432,16 +563,21 @@ func (ds *DiscoveryService) ListClusters(request *restful.Request, response *res
 		glog.V(5).Infof("CDS Discovery request to ListClusters for service_cluster %s, service_node %s, role %s",
 			cluster, node, role.Type)
 
-		clusters := buildClusters(ds.Environment, role)
-		if out, err = json.MarshalIndent(ClusterManager{Clusters: clusters}, " ", " "); err != nil {
+		if out, err = ds.generateClusters(role); err != nil {
 			errorResponse(response, http.StatusInternalServerError, "CDS "+err.Error())
 			return
 		}
 		ds.cdsCache.updateCachedDiscoveryResponse(key, out)
 	}
+	ds.proxies.record(request.PathParameter(ServiceNode), cdsType, out, time.Now())
 	writeResponse(response, out)
 }
 
+func (ds *DiscoveryService) generateClusters(role proxy.Node) ([]byte, error) {
+	clusters := buildClusters(ds.Environment, role)
+	return json.MarshalIndent(ClusterManager{Clusters: clusters}, " ", " ")
+}
+
 // ListListeners responds to LDS requests
 func (ds *DiscoveryService) ListListeners(request *restful.Request, response *restful.Response) {
 	key := request.Request.URL.String()
@@ -455,17 +591,21 @@ func (ds *DiscoveryService) ListListeners(request *restful.Request, response *re
 		glog.V(5).Infof("LDS Discovery request to ListListeners for service_cluster %s, service_node %s, role %s",
 			cluster, node, role.Type)
 
-		listeners := buildListeners(ds.Environment, role)
-		out, err = json.MarshalIndent(ldsResponse{Listeners: listeners}, " ", " ")
-		if err != nil {
+		if out, err = ds.generateListeners(role); err != nil {
 			errorResponse(response, http.StatusInternalServerError, "LDS "+err.Error())
 			return
 		}
 		ds.ldsCache.updateCachedDiscoveryResponse(key, out)
 	}
+	ds.proxies.record(request.PathParameter(ServiceNode), ldsType, out, time.Now())
 	writeResponse(response, out)
 }
 
+func (ds *DiscoveryService) generateListeners(role proxy.Node) ([]byte, error) {
+	listeners := buildListeners(ds.Environment, role)
+	return json.MarshalIndent(ldsResponse{Listeners: listeners}, " ", " ")
+}
+
 // ListRoutes responds to RDS requests, used by HTTP routes
 // Routes correspond to HTTP routes and use the listener port as the route name
 // to identify HTTP filters in the config. Service node value holds the local proxy identity.
@@ -485,55 +625,20 @@ func (ds *DiscoveryService) ListRoutes(request *restful.Request, response *restf
 			"role %s, route-config-name %s",
 			cluster, node, role.Type, routeConfigName)
 
-		routeConfig := buildRDSRoute(ds.Mesh, role, routeConfigName, ds.ServiceDiscovery, ds.IstioConfigStore)
-		if out, err = json.MarshalIndent(routeConfig, " ", " "); err != nil {
+		if out, err = ds.generateRoutes(role, routeConfigName); err != nil {
 			errorResponse(response, http.StatusInternalServerError, "RDS "+err.Error())
 			return
 		}
 		ds.rdsCache.updateCachedDiscoveryResponse(key, out)
 	}
+	ds.proxies.record(request.PathParameter(ServiceNode), rdsType+"/"+request.PathParameter(RouteConfigName),
+		out, time.Now())
 	writeResponse(response, out)
 }
 
-// ListSecret responds to TLS secret registration
-func (ds *DiscoveryService) ListSecret(request *restful.Request, response *restful.Response) {
-	// caching is disabled due to lack of secret watch notifications
-
-	cluster, node, role, err := ds.parseDiscoveryRequest(request)
-
-	if err != nil {
-		errorResponse(response, http.StatusNotFound, "ListSecrets "+err.Error())
-		return
-	}
-
-	glog.V(5).Infof("ListSecrets Discovery request to ListSecret for service_cluster %s, service_node %s, role %s",
-		cluster, node, role.Type)
-
-	if role.Type != proxy.Ingress {
-		writeResponse(response, nil)
-		return
-	}
-
-	_, secret := buildIngressRoutes(ds.Mesh, ds, ds)
-
-	if secret == "" {
-		glog.V(5).Infof("Secret is not set")
-		writeResponse(response, nil)
-		return
-	}
-
-	tls, err := ds.GetTLSSecret(secret)
-	if err != nil {
-		errorResponse(response, http.StatusNotFound,
-			fmt.Sprintf("ListSecrets Failed to read the secret: %s", err))
-		return
-	}
-
-	out, err := json.Marshal(tls)
-	if err != nil {
-		errorResponse(response, http.StatusInternalServerError, "ListSecrets "+err.Error())
-	}
-	writeResponse(response, out)
+func (ds *DiscoveryService) generateRoutes(role proxy.Node, routeConfigName string) ([]byte, error) {
+	routeConfig := buildRDSRoute(ds.Environment, role, routeConfigName)
+	return json.MarshalIndent(routeConfig, " ", " ")
 }
 
 func errorResponse(r *restful.Response, status int, msg string) {
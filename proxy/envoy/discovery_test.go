@@ -20,6 +20,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 
 	restful "github.com/emicklei/go-restful"
@@ -478,13 +479,155 @@ func TestSecretDiscovery(t *testing.T) {
 	ds := makeDiscoveryService(t, registry, &mesh)
 	url := fmt.Sprintf("/v1alpha/secret/%s/%s", ds.Mesh.IstioServiceCluster, mock.Ingress.ServiceNode())
 	got := makeDiscoveryRequest(ds, "GET", url, t)
-	want, err := json.Marshal(ingressTLSSecret)
+	want, err := json.Marshal(SecretResponse{TLSSecret: *ingressTLSSecret, Hosts: []string{"*"}})
 	if err != nil {
 		t.Error(err)
 	}
 	if string(got) != string(want) {
 		t.Errorf("ListSecret() => Got %q, expected %q", got, want)
 	}
+
+	// an unchanged secret is not modified after the wait duration
+	container := restful.NewContainer()
+	ds.Register(container)
+	request := httptest.NewRequest("GET", url+"?"+SecretWaitParam+"=10ms", nil)
+	request.Header.Set("If-None-Match", secretETag(want))
+	recorder := httptest.NewRecorder()
+	container.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusNotModified || recorder.Header().Get("ETag") != secretETag(want) {
+		t.Errorf("ListSecret() => Got %d with ETag %q, expected %d", recorder.Code,
+			recorder.Header().Get("ETag"), http.StatusNotModified)
+	}
+}
+
+func TestConfigAnalysis(t *testing.T) {
+	mesh := makeMeshConfig()
+	registry := memory.Make(model.IstioConfigTypes)
+	addConfig(registry, weightedRouteRule, t)
+	if _, err := registry.Create(model.Config{
+		ConfigMeta: model.ConfigMeta{Type: model.RouteRule.Type, Name: "dangling"},
+		Spec:       &proxyconfig.RouteRule{Destination: "missing.default.svc.cluster.local"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ds := makeDiscoveryService(t, registry, &mesh)
+
+	var got []struct {
+		Severity string `json:"severity"`
+		Key      string `json:"key"`
+	}
+	if err := json.Unmarshal(makeDiscoveryRequest(ds, "GET", "/v1/debug/validation", t), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Severity != "error" || got[0].Key != "route-rule//dangling" {
+		t.Errorf("AnalyzeConfig() => got %+v, want a single error for the dangling rule", got)
+	}
+}
+
+func TestRouteRuleConflicts(t *testing.T) {
+	mesh := makeMeshConfig()
+	registry := memory.Make(model.IstioConfigTypes)
+	addConfig(registry, weightedRouteRule, t)
+	addConfig(registry, timeoutRouteRule, t)
+	addConfig(registry, faultRouteRule, t)
+	ds := makeDiscoveryService(t, registry, &mesh)
+
+	var got []model.RouteRuleConflict
+	if err := json.Unmarshal(makeDiscoveryRequest(ds, "GET", "/v1/debug/conflicts", t), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []model.RouteRuleConflict{{
+		Destination: "world.default.svc.cluster.local",
+		Keys:        [2]string{"route-rule//fault-route", "route-rule//timeout"},
+	}, {
+		Destination: "world.default.svc.cluster.local",
+		Keys:        [2]string{"route-rule//fault-route", "route-rule//weighted-route"},
+	}, {
+		Destination: "world.default.svc.cluster.local",
+		Keys:        [2]string{"route-rule//timeout", "route-rule//weighted-route"},
+		Duplicate:   true,
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RouteRuleConflicts() => got %+v, want %+v", got, want)
+	}
+}
+
+func TestSyncStatus(t *testing.T) {
+	mesh := makeMeshConfig()
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes), &mesh)
+
+	var got map[string]bool
+	if err := json.Unmarshal(makeDiscoveryRequest(ds, "GET", "/v1/debug/sync", t), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("SyncStatus() => got %v, want no clusters", got)
+	}
+
+	want := map[string]bool{"east": true, "west": false}
+	ds.syncStatus = func() map[string]bool { return want }
+	if err := json.Unmarshal(makeDiscoveryRequest(ds, "GET", "/v1/debug/sync", t), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SyncStatus() => got %v, want %v", got, want)
+	}
+}
+
+func TestProxyCounts(t *testing.T) {
+	mesh := makeMeshConfig()
+	registry := memory.Make(model.IstioConfigTypes)
+	addConfig(registry, weightedRouteRule, t)
+	addConfig(registry, faultRouteRule, t)
+	addConfig(registry, cbPolicy, t)
+	addConfig(registry, ingressRouteRule1, t)
+	ds := makeDiscoveryService(t, registry, &mesh)
+
+	if got := ds.ProxyCounts(); len(got) != 0 {
+		t.Errorf("ProxyCounts() => got %v before any requests", got)
+	}
+
+	for _, node := range []proxy.Node{mock.ProxyV0, mock.ProxyV1, mock.Ingress} {
+		url := fmt.Sprintf("/v1/clusters/%s/%s", ds.Mesh.IstioServiceCluster, node.ServiceNode())
+		makeDiscoveryRequest(ds, "GET", url, t)
+	}
+
+	want := map[string]int{
+		"route-rule//weighted-route":       2,
+		"route-rule//fault-route":          1,
+		"destination-policy/default/world": 2,
+		"ingress-rule//bar":                1,
+	}
+	if got := ds.ProxyCounts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ProxyCounts() => got %v, want %v", got, want)
+	}
+
+	// flushing the cache, e.g. on a config status update, keeps the counts
+	ds.clearCache()
+	if got := ds.ProxyCounts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ProxyCounts() => got %v after cache flush, want %v", got, want)
+	}
+}
+
+func TestVisibleServices(t *testing.T) {
+	env := proxy.Environment{
+		ServiceDiscovery: mock.Discovery,
+		Isolation:        model.Isolation{Enabled: true},
+	}
+	sidecar := mock.ProxyV0
+	if got, want := len(visibleServices(env, sidecar)), len(mock.Discovery.Services()); got != want {
+		t.Errorf("visibleServices() => got %d services, want %d", got, want)
+	}
+
+	sidecar.Domain = "other.svc.cluster.local"
+	if got := visibleServices(env, sidecar); len(got) != 0 {
+		t.Errorf("visibleServices() => got %v, want none outside of the namespace", got)
+	}
+
+	env.Isolation.ExportedNamespaces = []string{"default"}
+	if got, want := len(visibleServices(env, sidecar)), len(mock.Discovery.Services()); got != want {
+		t.Errorf("visibleServices() => got %d services, want %d exported", got, want)
+	}
 }
 
 func TestDiscoveryCache(t *testing.T) {
@@ -25,6 +25,21 @@ import (
 	"istio.io/pilot/proxy"
 )
 
+// egressGenerator configures the egress proxies
+type egressGenerator struct{}
+
+func (egressGenerator) Listeners(env proxy.Environment, node proxy.Node) Listeners {
+	return buildEgressListeners(env.Mesh, node)
+}
+
+func (egressGenerator) Clusters(env proxy.Environment, _ proxy.Node) Clusters {
+	return buildEgressRoutes(env.Mesh, env.ServiceDiscovery).clusters().normalize()
+}
+
+func (egressGenerator) Routes(env proxy.Environment, _ proxy.Node) HTTPRouteConfigs {
+	return buildEgressRoutes(env.Mesh, env.ServiceDiscovery)
+}
+
 func buildEgressListeners(mesh *proxyconfig.ProxyMeshConfig, egress proxy.Node) Listeners {
 	port := proxy.ParsePort(mesh.EgressProxyAddress)
 	listener := buildHTTPListener(mesh, egress, nil, nil, WildcardAddress, port, fmt.Sprintf("%d", port), false)
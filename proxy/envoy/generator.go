@@ -0,0 +1,73 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"sync"
+
+	"istio.io/pilot/proxy"
+)
+
+// Generator produces the Envoy configuration for a proxy type. The discovery
+// service applies the destination policies and appends the Mixer cluster to
+// the generated clusters, and selects the routes by the route configuration
+// name.
+type Generator interface {
+	// Listeners for the proxy
+	Listeners(env proxy.Environment, node proxy.Node) Listeners
+
+	// Clusters referenced by the listeners and the routes of the proxy
+	Clusters(env proxy.Environment, node proxy.Node) Clusters
+
+	// Routes served over RDS keyed by the listener port
+	Routes(env proxy.Environment, node proxy.Node) HTTPRouteConfigs
+}
+
+var (
+	generatorsMutex sync.RWMutex
+
+	// generators by the proxy type
+	generators = map[proxy.NodeType]Generator{
+		proxy.Sidecar: sidecarGenerator{},
+		proxy.Ingress: ingressGenerator{},
+		proxy.Egress:  egressGenerator{},
+	}
+)
+
+// RegisterGenerator sets the generator for the proxy type. Custom proxy types
+// become valid in the service nodes of the discovery requests.
+func RegisterGenerator(t proxy.NodeType, g Generator) {
+	generatorsMutex.Lock()
+	generators[t] = g
+	generatorsMutex.Unlock()
+	proxy.RegisterNodeType(t)
+}
+
+// UnregisterGenerator removes the generator and the service nodes of the
+// custom proxy type
+func UnregisterGenerator(t proxy.NodeType) {
+	generatorsMutex.Lock()
+	delete(generators, t)
+	generatorsMutex.Unlock()
+	proxy.UnregisterNodeType(t)
+}
+
+// generatorFor returns the generator for the proxy type
+func generatorFor(t proxy.NodeType) (Generator, bool) {
+	generatorsMutex.RLock()
+	defer generatorsMutex.RUnlock()
+	g, exists := generators[t]
+	return g, exists
+}
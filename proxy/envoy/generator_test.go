@@ -0,0 +1,94 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+)
+
+const routerType proxy.NodeType = "router"
+
+// routerGenerator exposes a single route to a fixed cluster on port 8000
+type routerGenerator struct{}
+
+func (routerGenerator) Listeners(env proxy.Environment, node proxy.Node) Listeners {
+	return Listeners{buildHTTPListener(env.Mesh, node, nil, nil, WildcardAddress, 8000, "8000", true)}
+}
+
+func (routerGenerator) Clusters(_ proxy.Environment, _ proxy.Node) Clusters {
+	return Clusters{{Name: "router-backend", Type: ClusterTypeStrictDNS}}
+}
+
+func (routerGenerator) Routes(_ proxy.Environment, _ proxy.Node) HTTPRouteConfigs {
+	return HTTPRouteConfigs{8000: &HTTPRouteConfig{VirtualHosts: []*VirtualHost{{
+		Name:    "router",
+		Domains: []string{"*"},
+		Routes:  []*HTTPRoute{{Prefix: "/", Cluster: "router-backend"}},
+	}}}}
+}
+
+func TestRegisterGenerator(t *testing.T) {
+	router := proxy.Node{
+		Type:      routerType,
+		IPAddress: "10.3.3.3",
+		ID:        "router.default",
+		Domain:    "default.svc.cluster.local",
+	}
+	if _, err := proxy.ParseServiceNode(router.ServiceNode()); err == nil {
+		t.Fatal("ParseServiceNode() => expected an error for an unregistered proxy type")
+	}
+	RegisterGenerator(routerType, routerGenerator{})
+	defer UnregisterGenerator(routerType)
+
+	mesh := makeMeshConfig()
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes), &mesh)
+
+	var lds ldsResponse
+	url := fmt.Sprintf("/v1/listeners/%s/%s", ds.Mesh.IstioServiceCluster, router.ServiceNode())
+	if err := json.Unmarshal(makeDiscoveryRequest(ds, "GET", url, t), &lds); err != nil {
+		t.Fatal(err)
+	}
+	if len(lds.Listeners) != 1 || lds.Listeners[0].Address != "tcp://0.0.0.0:8000" {
+		t.Errorf("GET %s => got %+v", url, lds.Listeners)
+	}
+
+	var cds ClusterManager
+	url = fmt.Sprintf("/v1/clusters/%s/%s", ds.Mesh.IstioServiceCluster, router.ServiceNode())
+	if err := json.Unmarshal(makeDiscoveryRequest(ds, "GET", url, t), &cds); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, cluster := range cds.Clusters {
+		found = found || cluster.Name == "router-backend"
+	}
+	if !found {
+		t.Errorf("GET %s => got %+v", url, cds.Clusters)
+	}
+
+	var rds HTTPRouteConfig
+	url = fmt.Sprintf("/v1/routes/8000/%s/%s", ds.Mesh.IstioServiceCluster, router.ServiceNode())
+	if err := json.Unmarshal(makeDiscoveryRequest(ds, "GET", url, t), &rds); err != nil {
+		t.Fatal(err)
+	}
+	if len(rds.VirtualHosts) != 1 || rds.VirtualHosts[0].Name != "router" {
+		t.Errorf("GET %s => got %+v", url, rds)
+	}
+}
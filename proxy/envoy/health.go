@@ -0,0 +1,253 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/proxy"
+)
+
+const (
+	// statistics counting the successful discovery updates in Envoy
+	cdsUpdateStat = "cluster_manager.cds.update_success"
+	ldsUpdateStat = "listener_manager.lds.update_success"
+)
+
+// HealthServer exposes the liveness, readiness and status of the proxy agent
+// for the platform probes:
+//   - /healthz fails once the agent exhausts its retry budget
+//   - /ready succeeds once Envoy has received the initial CDS and LDS responses
+//     and fails once the proxy starts draining
+//   - /status reports the agent epochs, the hash of the live configuration,
+//     and the expiry of the proxy certificates
+type HealthServer struct {
+	mesh      *proxyconfig.ProxyMeshConfig
+	watcher   Watcher
+	dryRun    bool
+	client    *http.Client
+	server    *http.Server
+	container *restful.Container
+}
+
+// AgentStatus is the status report of the proxy agent
+type AgentStatus struct {
+	proxy.AgentStatus
+
+	// ConfigHash is the hash of the values referenced by the live configuration (e.g. TLS secrets)
+	ConfigHash string `json:"configHash,omitempty"`
+
+	// Ready is set if the proxy passes the readiness check
+	Ready bool `json:"ready"`
+
+	// Reason explains why the proxy is not ready
+	Reason string `json:"reason,omitempty"`
+
+	// Certificates lists the expiry of the certificates present on the proxy
+	Certificates []CertificateStatus `json:"certificates,omitempty"`
+}
+
+// CertificateStatus is the expiry of a certificate file
+type CertificateStatus struct {
+	Path             string    `json:"path"`
+	NotAfter         time.Time `json:"notAfter"`
+	ExpiresInSeconds int64     `json:"expiresInSeconds"`
+}
+
+// NewHealthServer creates a health server on the port for the watcher proxy.
+// The proxies of the dry-run backend are ready once an epoch is running since
+// they do not serve the admin API.
+func NewHealthServer(port int, mesh *proxyconfig.ProxyMeshConfig, watcher Watcher, dryRun bool) *HealthServer {
+	container := restful.NewContainer()
+	out := &HealthServer{
+		mesh:    mesh,
+		watcher: watcher,
+		dryRun:  dryRun,
+		client:  &http.Client{Timeout: time.Second},
+		server: &http.Server{
+			Addr:    fmt.Sprintf(":%d", port),
+			Handler: container,
+		},
+		container: container,
+	}
+
+	ws := &restful.WebService{}
+	ws.Produces(restful.MIME_JSON)
+
+	ws.Route(ws.
+		GET("/healthz").
+		To(out.healthz).
+		Doc("Liveness of the proxy agent"))
+
+	ws.Route(ws.
+		GET("/ready").
+		To(out.ready).
+		Doc("Readiness of the proxy"))
+
+	ws.Route(ws.
+		GET("/status").
+		To(out.status).
+		Doc("Status of the proxy agent").
+		Writes(AgentStatus{}))
+
+	container.Add(ws)
+
+	return out
+}
+
+// Run starts the server and blocks until the context is canceled
+func (s *HealthServer) Run(ctx context.Context) {
+	glog.Infof("Starting health service at %v", s.server.Addr)
+	go func() {
+		<-ctx.Done()
+		s.server.Close() // nolint: errcheck
+	}()
+	if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		glog.Error(err)
+	}
+}
+
+func (s *HealthServer) healthz(_ *restful.Request, response *restful.Response) {
+	if s.watcher.Status().BudgetExhausted {
+		writeStatusResponse(response, http.StatusServiceUnavailable, "retry budget exhausted")
+		return
+	}
+	writeStatusResponse(response, http.StatusOK, "ok")
+}
+
+func (s *HealthServer) ready(_ *restful.Request, response *restful.Response) {
+	if err := s.checkReady(); err != nil {
+		writeStatusResponse(response, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeStatusResponse(response, http.StatusOK, "ok")
+}
+
+func (s *HealthServer) status(_ *restful.Request, response *restful.Response) {
+	out := AgentStatus{AgentStatus: s.watcher.Status(), Ready: true}
+	if config, ok := out.CurrentConfig.(*Config); ok && len(config.Hash) > 0 {
+		out.ConfigHash = hex.EncodeToString(config.Hash)
+	}
+	if err := s.checkReady(); err != nil {
+		out.Ready = false
+		out.Reason = err.Error()
+	}
+	out.Certificates = s.certificates(time.Now())
+	if err := response.WriteEntity(out); err != nil {
+		glog.Warning(err)
+	}
+}
+
+// certificates reports the expiry of the mounted auth certificate and the ingress certificate
+func (s *HealthServer) certificates(now time.Time) []CertificateStatus {
+	files := []string{path.Join(proxy.IngressCertsPath, ingressCertFilename)}
+	if s.mesh.AuthPolicy == proxyconfig.ProxyMeshConfig_MUTUAL_TLS {
+		files = append(files, path.Join(s.mesh.AuthCertsPath, certChainFilename))
+	}
+
+	out := make([]CertificateStatus, 0, len(files))
+	for _, file := range files {
+		expiry, err := certificateExpiry(file)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				glog.Warningf("failed to read the certificate expiry: %v", err)
+			}
+			continue
+		}
+		out = append(out, CertificateStatus{
+			Path:             file,
+			NotAfter:         expiry,
+			ExpiresInSeconds: int64(expiry.Sub(now) / time.Second),
+		})
+	}
+	return out
+}
+
+// checkReady verifies that a proxy epoch is running and that Envoy has applied
+// the initial discovery responses according to its admin statistics
+func (s *HealthServer) checkReady() error {
+	status := s.watcher.Status()
+	if status.Draining {
+		return errors.New("proxy is draining")
+	}
+	if status.Epoch < 0 {
+		return errors.New("proxy is not running")
+	}
+	if s.dryRun {
+		return nil
+	}
+
+	url := fmt.Sprintf("http://%s:%d/stats", LocalhostAddress, s.mesh.ProxyAdminPort)
+	resp, err := s.client.Get(url)
+	if err != nil {
+		return fmt.Errorf("proxy admin port is not reachable: %v", err)
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxy admin port responded with %d", resp.StatusCode)
+	}
+
+	stats, err := parseStats(resp.Body)
+	if err != nil {
+		return err
+	}
+	for _, stat := range []string{cdsUpdateStat, ldsUpdateStat} {
+		if stats[stat] == 0 {
+			return fmt.Errorf("proxy has not received the initial %s", strings.ToUpper(strings.Split(stat, ".")[1]))
+		}
+	}
+	return nil
+}
+
+// parseStats reads the counters and gauges in the Envoy admin format "name: value"
+func parseStats(r io.Reader) (map[string]uint64, error) {
+	out := make(map[string]uint64)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 64)
+		if err != nil {
+			// histograms and other non-numeric statistics
+			continue
+		}
+		out[strings.TrimSpace(parts[0])] = value
+	}
+	return out, scanner.Err()
+}
+
+func writeStatusResponse(r *restful.Response, status int, msg string) {
+	r.WriteHeader(status)
+	if _, err := r.Write([]byte(msg + "\n")); err != nil {
+		glog.Warning(err)
+	}
+}
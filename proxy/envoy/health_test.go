@@ -0,0 +1,229 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/proxy"
+)
+
+type fakeWatcher struct {
+	status proxy.AgentStatus
+}
+
+func (w *fakeWatcher) Run(context.Context)       {}
+func (w *fakeWatcher) Reload()                   {}
+func (w *fakeWatcher) Status() proxy.AgentStatus { return w.status }
+
+// agentWatcher exposes the status of an agent as a watcher
+type agentWatcher struct {
+	proxy.Agent
+}
+
+func (w *agentWatcher) Reload() {}
+
+// failingBackend fails every proxy epoch
+type failingBackend struct{}
+
+func (failingBackend) Run(BackendArgs, <-chan error) error {
+	return errors.New("invalid configuration")
+}
+
+// makeAdmin creates a fake Envoy admin server responding with the statistics
+func makeAdmin(t *testing.T, stats string) (*httptest.Server, int) {
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stats" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, stats) // nolint: errcheck
+	}))
+	_, port, err := net.SplitHostPort(admin.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return admin, n
+}
+
+func TestParseStats(t *testing.T) {
+	stats, err := parseStats(strings.NewReader("cluster_manager.cds.update_success: 3\n" +
+		"listener_manager.lds.update_success: 0\n" +
+		"http.admin.downstream_rq_time: P0(nan,0) P25(nan,0)\n" +
+		"malformed\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 || stats[cdsUpdateStat] != 3 || stats[ldsUpdateStat] != 0 {
+		t.Errorf("parseStats() => got %v", stats)
+	}
+}
+
+func TestHealthServer(t *testing.T) {
+	ready := cdsUpdateStat + ": 1\n" + ldsUpdateStat + ": 2\n"
+	notReady := cdsUpdateStat + ": 1\n" + ldsUpdateStat + ": 0\n"
+
+	cases := []struct {
+		name   string
+		status proxy.AgentStatus
+		stats  string
+		path   string
+		code   int
+	}{
+		{"healthy", proxy.AgentStatus{Epoch: -1}, "", "/healthz", http.StatusOK},
+		{"exhausted", proxy.AgentStatus{Epoch: 2, BudgetExhausted: true}, "", "/healthz", http.StatusServiceUnavailable},
+		{"not running", proxy.AgentStatus{Epoch: -1}, ready, "/ready", http.StatusServiceUnavailable},
+		{"no lds", proxy.AgentStatus{Epoch: 0}, notReady, "/ready", http.StatusServiceUnavailable},
+		{"ready", proxy.AgentStatus{Epoch: 0}, ready, "/ready", http.StatusOK},
+		{"draining", proxy.AgentStatus{Epoch: 0, Draining: true}, ready, "/ready", http.StatusServiceUnavailable},
+	}
+
+	for _, c := range cases {
+		admin, port := makeAdmin(t, c.stats)
+		mesh := proxy.DefaultMeshConfig()
+		mesh.ProxyAdminPort = int32(port)
+		server := NewHealthServer(0, &mesh, &fakeWatcher{status: c.status}, false)
+
+		recorder := httptest.NewRecorder()
+		server.container.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, c.path, nil))
+		if recorder.Code != c.code {
+			t.Errorf("%s: GET %s => got %d (%s), want %d", c.name, c.path, recorder.Code, recorder.Body.String(), c.code)
+		}
+		admin.Close()
+	}
+
+	// the dry-run proxies do not serve the admin API
+	mesh := proxy.DefaultMeshConfig()
+	mesh.ProxyAdminPort = 1
+	server := NewHealthServer(0, &mesh, &fakeWatcher{status: proxy.AgentStatus{Epoch: 0}}, true)
+	recorder := httptest.NewRecorder()
+	server.container.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("dry run: GET /ready => got %d (%s), want %d", recorder.Code, recorder.Body.String(), http.StatusOK)
+	}
+}
+
+func TestHealthServerBudgetExhausted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	mesh := proxy.DefaultMeshConfig()
+	node := "sidecar~10.0.0.1~a.default~default.svc.cluster.local"
+	agent := proxy.NewAgent(runEnvoy(&mesh, node, dir, failingBackend{}),
+		proxy.Retry{MaxRetries: 1, InitialInterval: time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go agent.Run(ctx)
+	agent.ScheduleConfigUpdate(buildConfig(Listeners{}, Clusters{}, true, &mesh))
+
+	// the agent keeps running without a panic command, so that the health
+	// server reports the exhausted budget
+	server := NewHealthServer(0, &mesh, &agentWatcher{agent}, false)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		recorder := httptest.NewRecorder()
+		server.container.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		if recorder.Code == http.StatusServiceUnavailable {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("GET /healthz => got %d after the retries, want %d", recorder.Code, http.StatusServiceUnavailable)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status := agent.Status(); !status.BudgetExhausted || status.Failures != 2 {
+		t.Errorf("agent status %+v, want an exhausted budget after 2 failures", status)
+	}
+
+	scheduled := make(chan struct{})
+	go func() {
+		agent.ScheduleConfigUpdate(buildConfig(Listeners{}, Clusters{}, false, &mesh))
+		close(scheduled)
+	}()
+	select {
+	case <-scheduled:
+	case <-time.After(5 * time.Second):
+		t.Error("agent stopped accepting configuration after exhausting its budget")
+	}
+}
+
+func TestHealthServerStatus(t *testing.T) {
+	admin, port := makeAdmin(t, cdsUpdateStat+": 1\n")
+	defer admin.Close()
+	mesh := proxy.DefaultMeshConfig()
+	mesh.ProxyAdminPort = int32(port)
+
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	secret := makeTestSecret(t, "hello", time.Now().Add(-time.Hour), expiry)
+	if err = ioutil.WriteFile(path.Join(dir, certChainFilename), secret.Certificate, 0644); err != nil {
+		t.Fatal(err)
+	}
+	mesh.AuthPolicy = proxyconfig.ProxyMeshConfig_MUTUAL_TLS
+	mesh.AuthCertsPath = dir
+
+	status := proxy.AgentStatus{
+		Epoch:         1,
+		Restarts:      1,
+		Failures:      1,
+		LastExitError: "exit status 1",
+		CurrentConfig: &Config{Hash: []byte{0xab, 0xcd}},
+	}
+	server := NewHealthServer(0, &mesh, &fakeWatcher{status: status}, false)
+
+	recorder := httptest.NewRecorder()
+	server.container.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET /status => got %d", recorder.Code)
+	}
+
+	var got AgentStatus
+	if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Epoch != 1 || got.Restarts != 1 || got.Failures != 1 || got.LastExitError != "exit status 1" ||
+		got.ConfigHash != "abcd" || got.Ready || got.Reason != "proxy has not received the initial LDS" {
+		t.Errorf("GET /status => got %+v", got)
+	}
+	if len(got.Certificates) != 1 || got.Certificates[0].Path != path.Join(dir, certChainFilename) ||
+		!got.Certificates[0].NotAfter.Equal(expiry) || got.Certificates[0].ExpiresInSeconds <= 0 {
+		t.Errorf("GET /status => got certificates %+v", got.Certificates)
+	}
+}
@@ -27,6 +27,23 @@ import (
 	"istio.io/pilot/proxy"
 )
 
+// ingressGenerator configures the ingress proxies
+type ingressGenerator struct{}
+
+func (ingressGenerator) Listeners(env proxy.Environment, node proxy.Node) Listeners {
+	return buildIngressListeners(env.Mesh, env.ServiceDiscovery, env.IstioConfigStore, node)
+}
+
+func (ingressGenerator) Clusters(env proxy.Environment, _ proxy.Node) Clusters {
+	httpRouteConfigs, _ := buildIngressRoutes(env.Mesh, env.ServiceDiscovery, env.IstioConfigStore)
+	return httpRouteConfigs.clusters().normalize()
+}
+
+func (ingressGenerator) Routes(env proxy.Environment, _ proxy.Node) HTTPRouteConfigs {
+	httpRouteConfigs, _ := buildIngressRoutes(env.Mesh, env.ServiceDiscovery, env.IstioConfigStore)
+	return httpRouteConfigs
+}
+
 func buildIngressListeners(mesh *proxyconfig.ProxyMeshConfig,
 	discovery model.ServiceDiscovery,
 	config model.IstioConfigStore,
@@ -41,8 +58,8 @@ func buildIngressListeners(mesh *proxyconfig.ProxyMeshConfig,
 	if secret != "" {
 		listener := buildHTTPListener(mesh, ingress, nil, nil, WildcardAddress, 443, "443", true)
 		listener.SSLContext = &SSLContext{
-			CertChainFile:  path.Join(proxy.IngressCertsPath, "tls.crt"),
-			PrivateKeyFile: path.Join(proxy.IngressCertsPath, "tls.key"),
+			CertChainFile:  path.Join(proxy.IngressCertsPath, ingressCertFilename),
+			PrivateKeyFile: path.Join(proxy.IngressCertsPath, ingressKeyFilename),
 		}
 		listeners = append(listeners, listener)
 	}
@@ -77,17 +94,10 @@ func buildIngressRoutes(mesh *proxyconfig.ProxyMeshConfig,
 			continue
 		}
 
-		host := "*"
-		if rule.Match != nil {
-			if authority, ok := rule.Match.HttpHeaders[model.HeaderAuthority]; ok {
-				switch match := authority.GetMatchType().(type) {
-				case *proxyconfig.StringMatch_Exact:
-					host = match.Exact
-				default:
-					glog.Warningf("Unsupported match type for authority condition %T, falling back to %q", match, host)
-					continue
-				}
-			}
+		host, err := ingressHost(rule)
+		if err != nil {
+			glog.Warning(err)
+			continue
 		}
 		if tls != "" {
 			vhostsTLS[host] = append(vhostsTLS[host], routes...)
@@ -129,6 +139,61 @@ func buildIngressRoutes(mesh *proxyconfig.ProxyMeshConfig,
 	return configs.normalize(), tlsAll
 }
 
+// ingressHost returns the virtual host matched by the authority condition of
+// the ingress rule, or "*" for all hosts
+func ingressHost(rule *proxyconfig.IngressRule) (string, error) {
+	if rule.Match != nil {
+		if authority, ok := rule.Match.HttpHeaders[model.HeaderAuthority]; ok {
+			switch match := authority.GetMatchType().(type) {
+			case *proxyconfig.StringMatch_Exact:
+				return match.Exact, nil
+			default:
+				return "", fmt.Errorf("unsupported match type for authority condition %T", match)
+			}
+		}
+	}
+	return "*", nil
+}
+
+// appliedIngressRules lists the keys of the ingress rules that are applied
+// to the ingress proxies, in order
+func appliedIngressRules(mesh *proxyconfig.ProxyMeshConfig,
+	discovery model.ServiceDiscovery,
+	config model.IstioConfigStore) []string {
+	rules := config.RouteRulesBySource(nil)
+	out := make([]string, 0)
+	for key, rule := range config.IngressRules() {
+		if _, _, err := buildIngressRoute(mesh, rule, discovery, rules); err != nil {
+			continue
+		}
+		if _, err := ingressHost(rule); err != nil {
+			continue
+		}
+		out = append(out, key)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ingressTLSHosts lists the hosts of the ingress rules terminating TLS with the secret
+func ingressTLSHosts(config model.IstioConfigStore, secret string) []string {
+	set := make(map[string]bool)
+	for _, rule := range config.IngressRules() {
+		if rule.TlsSecret != secret {
+			continue
+		}
+		if host, err := ingressHost(rule); err == nil {
+			set[host] = true
+		}
+	}
+	out := make([]string, 0, len(set))
+	for host := range set {
+		out = append(out, host)
+	}
+	sort.Strings(out)
+	return out
+}
+
 // buildIngressRoute translates an ingress rule to an Envoy route
 func buildIngressRoute(mesh *proxyconfig.ProxyMeshConfig, ingress *proxyconfig.IngressRule,
 	discovery model.ServiceDiscovery, rules []*proxyconfig.RouteRule) ([]*HTTPRoute, string, error) {
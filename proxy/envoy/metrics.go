@@ -0,0 +1,280 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+
+	"istio.io/pilot/proxy"
+)
+
+// Discovery request types reported in the metrics
+const (
+	sdsType = "sds"
+	cdsType = "cds"
+	rdsType = "rds"
+	ldsType = "lds"
+)
+
+// Cache flush triggers reported in the metrics
+const (
+	triggerService  = "service"
+	triggerInstance = "instance"
+)
+
+var (
+	// latencyBuckets are the bounds in seconds of the request latency histogram
+	latencyBuckets = []float64{.001, .005, .01, .05, .1, .5, 1, 5}
+
+	// sizeBuckets are the bounds in bytes of the response size histogram
+	sizeBuckets = []float64{1 << 8, 1 << 10, 1 << 12, 1 << 14, 1 << 16, 1 << 18, 1 << 20}
+)
+
+// metricsContentType is the Prometheus text exposition format
+const metricsContentType = "text/plain; version=0.0.4"
+
+type requestKey struct {
+	typ  string
+	code int
+}
+
+// discoveryMetrics collects the discovery service metrics exposed in the
+// Prometheus text format
+type discoveryMetrics struct {
+	mu        sync.Mutex
+	requests  map[requestKey]uint64
+	latencies map[string]*proxy.Histogram
+	sizes     map[string]*proxy.Histogram
+	clears    map[string]uint64
+
+	// synced reports the initial synchronization of the registries by name
+	synced map[string]func() bool
+}
+
+func newDiscoveryMetrics() *discoveryMetrics {
+	return &discoveryMetrics{
+		requests:  make(map[requestKey]uint64),
+		latencies: make(map[string]*proxy.Histogram),
+		sizes:     make(map[string]*proxy.Histogram),
+		clears:    make(map[string]uint64),
+		synced:    make(map[string]func() bool),
+	}
+}
+
+// observeRequest records a discovery request of the type
+func (m *discoveryMetrics) observeRequest(typ string, code, size int, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestKey{typ: typ, code: code}]++
+	if _, exists := m.latencies[typ]; !exists {
+		latencies := proxy.NewHistogram(latencyBuckets...)
+		sizes := proxy.NewHistogram(sizeBuckets...)
+		m.latencies[typ] = &latencies
+		m.sizes[typ] = &sizes
+	}
+	m.latencies[typ].Observe(latency)
+	m.sizes[typ].ObserveValue(float64(size))
+}
+
+// observeClear records a cache flush by the trigger
+func (m *discoveryMetrics) observeClear(trigger string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clears[trigger]++
+}
+
+// filter instruments the discovery requests of the type
+func (m *discoveryMetrics) filter(typ string) restful.FilterFunction {
+	return func(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+		start := time.Now()
+		chain.ProcessFilter(request, response)
+		m.observeRequest(typ, response.StatusCode(), response.ContentLength(), time.Since(start))
+	}
+}
+
+// Metrics responds with the discovery service metrics in the Prometheus text format
+func (ds *DiscoveryService) Metrics(w http.ResponseWriter, _ *http.Request) {
+	var buf bytes.Buffer
+	ds.writeMetrics(&buf)
+	w.Header().Set("Content-Type", metricsContentType)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		glog.Warning(err)
+	}
+}
+
+func (ds *DiscoveryService) writeMetrics(buf *bytes.Buffer) {
+	m := ds.metrics
+	out := metricWriter{buf}
+
+	m.mu.Lock()
+	out.header("pilot_discovery_requests_total", "counter", "Discovery requests by type and response code.")
+	keys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].typ < keys[j].typ || keys[i].typ == keys[j].typ && keys[i].code < keys[j].code
+	})
+	for _, key := range keys {
+		out.sample("pilot_discovery_requests_total", []string{"type", key.typ, "code", strconv.Itoa(key.code)},
+			float64(m.requests[key]))
+	}
+
+	out.header("pilot_discovery_request_duration_seconds", "histogram", "Discovery request latencies by type.")
+	for _, typ := range sortedKeys(m.latencies) {
+		out.histogram("pilot_discovery_request_duration_seconds", []string{"type", typ}, m.latencies[typ])
+	}
+	out.header("pilot_discovery_response_size_bytes", "histogram", "Discovery response sizes by type.")
+	for _, typ := range sortedKeys(m.sizes) {
+		out.histogram("pilot_discovery_response_size_bytes", []string{"type", typ}, m.sizes[typ])
+	}
+
+	out.header("pilot_discovery_cache_clears_total", "counter", "Discovery cache flushes by triggering event.")
+	triggers := make([]string, 0, len(m.clears))
+	for trigger := range m.clears {
+		triggers = append(triggers, trigger)
+	}
+	sort.Strings(triggers)
+	for _, trigger := range triggers {
+		out.sample("pilot_discovery_cache_clears_total", []string{"trigger", trigger}, float64(m.clears[trigger]))
+	}
+
+	out.header("pilot_registry_synced", "gauge", "Initial synchronization of the registries.")
+	registries := make([]string, 0, len(m.synced))
+	for name := range m.synced {
+		registries = append(registries, name)
+	}
+	sort.Strings(registries)
+	for _, name := range registries {
+		value := 0.0
+		if m.synced[name]() {
+			value = 1
+		}
+		out.sample("pilot_registry_synced", []string{"registry", name}, value)
+	}
+	m.mu.Unlock()
+
+	caches := []struct {
+		typ   string
+		cache *discoveryCache
+	}{{sdsType, ds.sdsCache}, {cdsType, ds.cdsCache}, {rdsType, ds.rdsCache}, {ldsType, ds.ldsCache}}
+	hits := make([]uint64, len(caches))
+	misses := make([]uint64, len(caches))
+	for i, c := range caches {
+		for _, entry := range c.cache.stats() {
+			hits[i] += entry.Hit
+			misses[i] += entry.Miss
+		}
+	}
+	out.header("pilot_discovery_cache_hits_total", "counter", "Discovery cache hits by type.")
+	for i, c := range caches {
+		out.sample("pilot_discovery_cache_hits_total", []string{"type", c.typ}, float64(hits[i]))
+	}
+	out.header("pilot_discovery_cache_misses_total", "counter", "Discovery cache misses by type.")
+	for i, c := range caches {
+		out.sample("pilot_discovery_cache_misses_total", []string{"type", c.typ}, float64(misses[i]))
+	}
+	out.header("pilot_discovery_cache_hit_ratio", "gauge", "Ratio of the discovery cache hits to all lookups by type.")
+	for i, c := range caches {
+		ratio := 0.0
+		if hits[i]+misses[i] > 0 {
+			ratio = float64(hits[i]) / float64(hits[i]+misses[i])
+		}
+		out.sample("pilot_discovery_cache_hit_ratio", []string{"type", c.typ}, ratio)
+	}
+
+	counts := ds.proxies.counts(time.Now())
+	out.header("pilot_discovery_proxies", "gauge", "Proxies that recently requested configuration by node type.")
+	for _, typ := range []proxy.NodeType{proxy.Sidecar, proxy.Ingress, proxy.Egress} {
+		out.sample("pilot_discovery_proxies", []string{"node_type", string(typ)}, float64(counts[typ]))
+	}
+
+	if ds.IstioConfigStore != nil {
+		out.header("pilot_config_objects", "gauge", "Configuration objects by type.")
+		for _, schema := range ds.IstioConfigStore.ConfigDescriptor() {
+			configs, err := ds.IstioConfigStore.List(schema.Type, "")
+			if err != nil {
+				glog.Warningf("failed to list %s: %v", schema.Type, err)
+				continue
+			}
+			out.sample("pilot_config_objects", []string{"type", schema.Type}, float64(len(configs)))
+		}
+	}
+}
+
+func sortedKeys(histograms map[string]*proxy.Histogram) []string {
+	out := make([]string, 0, len(histograms))
+	for key := range histograms {
+		out = append(out, key)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// metricWriter formats the metrics in the Prometheus text exposition format.
+// Labels are given as name and value pairs.
+type metricWriter struct {
+	buf *bytes.Buffer
+}
+
+func (w metricWriter) header(name, typ, help string) {
+	fmt.Fprintf(w.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (w metricWriter) sample(name string, labels []string, value float64) {
+	w.buf.WriteString(name)
+	if len(labels) > 0 {
+		pairs := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, fmt.Sprintf("%s=%s", labels[i], strconv.Quote(labels[i+1])))
+		}
+		w.buf.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	w.buf.WriteString(" " + formatMetricValue(value) + "\n")
+}
+
+// histogram writes the cumulative buckets, the sum, and the count
+func (w metricWriter) histogram(name string, labels []string, h *proxy.Histogram) {
+	count := 0
+	for i, bucket := range h.Counts {
+		count += bucket
+		bound := math.Inf(1)
+		if i < len(h.Bounds) {
+			bound = h.Bounds[i]
+		}
+		w.sample(name+"_bucket", append(append([]string{}, labels...), "le", formatMetricValue(bound)),
+			float64(count))
+	}
+	w.sample(name+"_sum", labels, h.Sum)
+	w.sample(name+"_count", labels, float64(count))
+}
+
+func formatMetricValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
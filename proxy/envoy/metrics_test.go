@@ -0,0 +1,79 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/mock"
+)
+
+func TestMetrics(t *testing.T) {
+	mesh := makeMeshConfig()
+	registry := memory.Make(model.IstioConfigTypes)
+	addConfig(registry, timeoutRouteRule, t)
+	ds := makeDiscoveryService(t, registry, &mesh)
+
+	url := fmt.Sprintf("/v1/clusters/%s/%s", ds.Mesh.IstioServiceCluster, mock.ProxyV0.ServiceNode())
+	makeDiscoveryRequest(ds, "GET", url, t)
+	makeDiscoveryRequest(ds, "GET", url, t)
+	url = fmt.Sprintf("/v1/clusters/%s/%s", ds.Mesh.IstioServiceCluster, mock.Ingress.ServiceNode())
+	makeDiscoveryRequest(ds, "GET", url, t)
+	ds.clearCacheOn(triggerService)
+	ds.clearCacheOn(model.RouteRule.Type)
+
+	got := string(makeDiscoveryRequest(ds, "GET", "/metrics", t))
+	for _, want := range []string{
+		`pilot_discovery_requests_total{type="cds",code="200"} 3`,
+		`pilot_discovery_request_duration_seconds_count{type="cds"} 3`,
+		`pilot_discovery_response_size_bytes_bucket{type="cds",le="+Inf"} 3`,
+		`pilot_discovery_cache_hits_total{type="cds"} 1`,
+		`pilot_discovery_cache_misses_total{type="cds"} 2`,
+		`pilot_discovery_cache_clears_total{trigger="route-rule"} 1`,
+		`pilot_discovery_cache_clears_total{trigger="service"} 1`,
+		`pilot_discovery_proxies{node_type="sidecar"} 1`,
+		`pilot_discovery_proxies{node_type="ingress"} 1`,
+		`pilot_config_objects{type="route-rule"} 1`,
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("GET /metrics => missing %q in:\n%s", want, got)
+		}
+	}
+}
+
+func TestMetricWriterHistogram(t *testing.T) {
+	h := proxy.NewHistogram(1, 10)
+	h.ObserveValue(0.5)
+	h.ObserveValue(5)
+	h.ObserveValue(50)
+
+	var buf bytes.Buffer
+	metricWriter{&buf}.histogram("size", []string{"type", "lds"}, &h)
+	want := `size_bucket{type="lds",le="1"} 1
+size_bucket{type="lds",le="10"} 2
+size_bucket{type="lds",le="+Inf"} 3
+size_sum{type="lds"} 55.5
+size_count{type="lds"} 3
+`
+	if got := buf.String(); got != want {
+		t.Errorf("histogram() => got:\n%s\nwant:\n%s", got, want)
+	}
+}
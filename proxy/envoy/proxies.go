@@ -0,0 +1,233 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+
+	"istio.io/pilot/proxy"
+)
+
+// proxyExpiry is the period after the last discovery request of a proxy
+// when the proxy is removed from the registry
+var proxyExpiry = 5 * time.Minute
+
+// ProxyFetch records the latest discovery response served to a proxy
+type ProxyFetch struct {
+	// LastFetch is the time of the latest request
+	LastFetch time.Time `json:"lastFetch"`
+
+	// Hash of the latest response
+	Hash string `json:"hash"`
+
+	// CurrentHash is the hash of the response for the current configuration
+	CurrentHash string `json:"currentHash,omitempty"`
+
+	// Stale is set if the proxy has not fetched the current configuration
+	Stale bool `json:"stale"`
+}
+
+// ProxyStatus is the discovery state of a proxy
+type ProxyStatus struct {
+	ServiceNode string         `json:"serviceNode"`
+	Type        proxy.NodeType `json:"type"`
+	IPAddress   string         `json:"ipAddress"`
+
+	// Fetches are keyed by the discovery type, with the route configuration
+	// name appended for RDS, e.g. "rds/80"
+	Fetches map[string]*ProxyFetch `json:"fetches"`
+
+	// Stale is set if any of the fetched responses is stale
+	Stale bool `json:"stale"`
+}
+
+// proxyRegistry tracks the proxies by their service node
+type proxyRegistry struct {
+	mu      sync.Mutex
+	proxies map[string]*ProxyStatus
+
+	// generation counts the configuration changes
+	generation uint64
+}
+
+func newProxyRegistry() *proxyRegistry {
+	return &proxyRegistry{proxies: make(map[string]*ProxyStatus)}
+}
+
+// responseHash is a short digest of a discovery response
+func responseHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// record notes the response served to the proxy with the service node. The
+// response is generated for the current configuration.
+func (r *proxyRegistry) record(node, resource string, data []byte, now time.Time) {
+	role, err := proxy.ParseServiceNode(node)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status, exists := r.proxies[node]
+	if !exists {
+		status = &ProxyStatus{
+			ServiceNode: node,
+			Type:        role.Type,
+			IPAddress:   role.IPAddress,
+			Fetches:     make(map[string]*ProxyFetch),
+		}
+		r.proxies[node] = status
+	}
+	hash := responseHash(data)
+	status.Fetches[resource] = &ProxyFetch{LastFetch: now, Hash: hash, CurrentHash: hash}
+}
+
+// prune removes the proxies that have not fetched any configuration since
+// the expiry period. The caller must hold the lock.
+func (r *proxyRegistry) prune(now time.Time) {
+	for node, status := range r.proxies {
+		latest := time.Time{}
+		for _, fetch := range status.Fetches {
+			if fetch.LastFetch.After(latest) {
+				latest = fetch.LastFetch
+			}
+		}
+		if now.Sub(latest) > proxyExpiry {
+			glog.V(2).Infof("Proxy %s expired after the last fetch at %v", node, latest)
+			delete(r.proxies, node)
+		}
+	}
+}
+
+// list returns a copy of the unexpired proxies sorted by the service node
+func (r *proxyRegistry) list(now time.Time) []*ProxyStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prune(now)
+	out := make([]*ProxyStatus, 0, len(r.proxies))
+	for _, status := range r.proxies {
+		copied := *status
+		copied.Fetches = make(map[string]*ProxyFetch, len(status.Fetches))
+		for resource, fetch := range status.Fetches {
+			fetchCopy := *fetch
+			copied.Fetches[resource] = &fetchCopy
+		}
+		out = append(out, &copied)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ServiceNode < out[j].ServiceNode })
+	return out
+}
+
+// counts returns the number of the unexpired proxies by type
+func (r *proxyRegistry) counts(now time.Time) map[proxy.NodeType]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prune(now)
+	out := make(map[proxy.NodeType]int)
+	for _, status := range r.proxies {
+		out[status.Type]++
+	}
+	return out
+}
+
+// invalidate forgets the hashes of the responses for the current
+// configuration once the configuration changes
+func (r *proxyRegistry) invalidate() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.generation++
+	for _, status := range r.proxies {
+		for _, fetch := range status.Fetches {
+			fetch.CurrentHash = ""
+		}
+	}
+}
+
+// configGeneration returns the number of the configuration changes
+func (r *proxyRegistry) configGeneration() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.generation
+}
+
+// setCurrentHash records the hash of the response for the current
+// configuration unless the configuration changed since the generation
+func (r *proxyRegistry) setCurrentHash(node, resource, hash string, generation uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if generation != r.generation {
+		return
+	}
+	if status, exists := r.proxies[node]; exists {
+		if fetch, exists := status.Fetches[resource]; exists {
+			fetch.CurrentHash = hash
+		}
+	}
+}
+
+// generate computes the discovery response for the resource of the registry
+func (ds *DiscoveryService) generate(role proxy.Node, resource string) ([]byte, error) {
+	switch {
+	case resource == cdsType:
+		return ds.generateClusters(role)
+	case resource == ldsType:
+		return ds.generateListeners(role)
+	case strings.HasPrefix(resource, rdsType+"/"):
+		return ds.generateRoutes(role, strings.TrimPrefix(resource, rdsType+"/"))
+	}
+	return nil, fmt.Errorf("unknown resource %q", resource)
+}
+
+// ListProxies responds with the proxies that recently requested configuration.
+// The responses served to the proxies are compared to the responses for the
+// current configuration to detect the stale proxies. The hashes of the current
+// responses are kept until the configuration changes, so that only the
+// responses not fetched since the change are generated.
+func (ds *DiscoveryService) ListProxies(_ *restful.Request, response *restful.Response) {
+	generation := ds.proxies.configGeneration()
+	out := ds.proxies.list(time.Now())
+	for _, status := range out {
+		role, err := proxy.ParseServiceNode(status.ServiceNode)
+		if err != nil {
+			continue
+		}
+		for resource, fetch := range status.Fetches {
+			if fetch.CurrentHash == "" {
+				data, err := ds.generate(role, resource)
+				if err != nil {
+					glog.Warningf("failed to generate %s for %s: %v", resource, status.ServiceNode, err)
+					continue
+				}
+				fetch.CurrentHash = responseHash(data)
+				ds.proxies.setCurrentHash(status.ServiceNode, resource, fetch.CurrentHash, generation)
+			}
+			fetch.Stale = fetch.CurrentHash != fetch.Hash
+			status.Stale = status.Stale || fetch.Stale
+		}
+	}
+	if err := response.WriteEntity(out); err != nil {
+		glog.Warning(err)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/mock"
+)
+
+func TestListProxies(t *testing.T) {
+	mesh := makeMeshConfig()
+	registry := memory.Make(model.IstioConfigTypes)
+	ds := makeDiscoveryService(t, registry, &mesh)
+
+	node := mock.ProxyV0.ServiceNode()
+	for _, url := range []string{
+		fmt.Sprintf("/v1/clusters/%s/%s", ds.Mesh.IstioServiceCluster, node),
+		fmt.Sprintf("/v1/listeners/%s/%s", ds.Mesh.IstioServiceCluster, node),
+		fmt.Sprintf("/v1/routes/80/%s/%s", ds.Mesh.IstioServiceCluster, node),
+	} {
+		makeDiscoveryRequest(ds, "GET", url, t)
+	}
+
+	list := func() []ProxyStatus {
+		var out []ProxyStatus
+		if err := json.Unmarshal(makeDiscoveryRequest(ds, "GET", "/v1/debug/proxies", t), &out); err != nil {
+			t.Fatal(err)
+		}
+		if len(out) != 1 || out[0].ServiceNode != node || out[0].Type != proxy.Sidecar ||
+			out[0].IPAddress != mock.ProxyV0.IPAddress || len(out[0].Fetches) != 3 {
+			t.Fatalf("GET /v1/debug/proxies => got %+v", out)
+		}
+		return out
+	}
+
+	if got := list(); got[0].Stale {
+		t.Errorf("GET /v1/debug/proxies => got a stale proxy %+v", got[0])
+	}
+
+	// the route rule changes the routes of the proxy, and the config
+	// controller flushes the cache on the change
+	addConfig(registry, timeoutRouteRule, t)
+	ds.clearCache()
+	got := list()
+	if !got[0].Stale || !got[0].Fetches[rdsType+"/80"].Stale || got[0].Fetches[ldsType].Stale {
+		for resource, fetch := range got[0].Fetches {
+			t.Logf("%s: %+v", resource, fetch)
+		}
+		t.Errorf("GET /v1/debug/proxies => expected stale routes")
+	}
+}
+
+func TestProxyRegistryExpiry(t *testing.T) {
+	r := newProxyRegistry()
+	now := time.Now()
+	r.record(mock.ProxyV0.ServiceNode(), cdsType, []byte("a"), now.Add(-2*proxyExpiry))
+	r.record(mock.ProxyV1.ServiceNode(), cdsType, []byte("a"), now.Add(-2*proxyExpiry))
+	r.record(mock.ProxyV1.ServiceNode(), ldsType, []byte("b"), now)
+	r.record(mock.Ingress.ServiceNode(), cdsType, []byte("c"), now)
+	r.record("invalid", cdsType, []byte("d"), now)
+
+	if got := r.list(now); len(got) != 2 || got[0].ServiceNode > got[1].ServiceNode {
+		t.Errorf("list() => got %+v", got)
+	}
+	if got := r.counts(now); got[proxy.Sidecar] != 1 || got[proxy.Ingress] != 1 {
+		t.Errorf("counts() => got %v", got)
+	}
+
+	// the counts prune the proxies without listing them
+	if got := r.counts(now.Add(2 * proxyExpiry)); len(got) != 0 {
+		t.Errorf("counts() after the expiry => got %v", got)
+	}
+	if len(r.proxies) != 0 {
+		t.Errorf("counts() did not remove the expired proxies, got %v", r.proxies)
+	}
+}
+
+func TestProxyRegistryCurrentHash(t *testing.T) {
+	r := newProxyRegistry()
+	node := mock.ProxyV0.ServiceNode()
+	r.record(node, cdsType, []byte("a"), time.Now())
+
+	// the served response is the current one until the configuration changes
+	fetch := r.list(time.Now())[0].Fetches[cdsType]
+	if fetch.CurrentHash != fetch.Hash {
+		t.Errorf("list() => got current hash %q, want %q", fetch.CurrentHash, fetch.Hash)
+	}
+
+	generation := r.configGeneration()
+	r.invalidate()
+	if fetch = r.list(time.Now())[0].Fetches[cdsType]; fetch.CurrentHash != "" {
+		t.Errorf("list() after invalidate() => got current hash %q", fetch.CurrentHash)
+	}
+
+	// hashes generated before the change are discarded
+	r.setCurrentHash(node, cdsType, "stale", generation)
+	if fetch = r.list(time.Now())[0].Fetches[cdsType]; fetch.CurrentHash != "" {
+		t.Errorf("setCurrentHash() with an old generation => got current hash %q", fetch.CurrentHash)
+	}
+	r.setCurrentHash(node, cdsType, "current", r.configGeneration())
+	if fetch = r.list(time.Now())[0].Fetches[cdsType]; fetch.CurrentHash != "current" {
+		t.Errorf("setCurrentHash() => got current hash %q, want %q", fetch.CurrentHash, "current")
+	}
+}
@@ -395,11 +395,36 @@ func (rc *HTTPRouteConfig) clusters() Clusters {
 	return out
 }
 
+// normalize sorts the virtual hosts by name and removes the duplicate domains,
+// which Envoy rejects. A domain shared by several virtual hosts, e.g. the
+// address of a pod selected by several headless services, is kept by the
+// first virtual host.
 func (rc *HTTPRouteConfig) normalize() *HTTPRouteConfig {
 	hosts := make([]*VirtualHost, len(rc.VirtualHosts))
 	copy(hosts, rc.VirtualHosts)
 	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Name < hosts[j].Name })
-	return &HTTPRouteConfig{VirtualHosts: hosts}
+
+	out := make([]*VirtualHost, 0, len(hosts))
+	domains := make(map[string]bool)
+	for _, host := range hosts {
+		unique := make([]string, 0, len(host.Domains))
+		for _, domain := range host.Domains {
+			if !domains[domain] {
+				domains[domain] = true
+				unique = append(unique, domain)
+			}
+		}
+		if len(unique) == 0 {
+			continue
+		}
+		if len(unique) < len(host.Domains) {
+			copied := *host
+			copied.Domains = unique
+			host = &copied
+		}
+		out = append(out, host)
+	}
+	return &HTTPRouteConfig{VirtualHosts: out}
 }
 
 // AccessLog definition.
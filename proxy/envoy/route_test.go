@@ -15,8 +15,11 @@
 package envoy
 
 import (
+	"reflect"
 	"strings"
 	"testing"
+
+	"istio.io/pilot/model"
 )
 
 var (
@@ -58,3 +61,34 @@ func TestBuildListenerSSLContext(t *testing.T) {
 			dir, context.RequireClientCertificate)
 	}
 }
+
+func TestRouteConfigDuplicateDomains(t *testing.T) {
+	// a pod selected by two headless services has an endpoint service in both
+	port := &model.Port{Name: "http", Port: 80, Protocol: model.ProtocolHTTP}
+	kafka := &model.Service{Hostname: "kafka-0.kafka.default.svc.cluster.local", Address: "10.1.1.1"}
+	broker := &model.Service{Hostname: "kafka-0.broker.default.svc.cluster.local", Address: "10.1.1.1"}
+	suffix := []string{"default", "svc", "cluster", "local"}
+	rc := &HTTPRouteConfig{VirtualHosts: []*VirtualHost{
+		buildVirtualHost(kafka, port, suffix, nil),
+		buildVirtualHost(broker, port, suffix, nil),
+	}}
+
+	out := rc.normalize()
+	seen := make(map[string]bool)
+	for _, host := range out.VirtualHosts {
+		for _, domain := range host.Domains {
+			if seen[domain] {
+				t.Errorf("normalize() => duplicate domain %q", domain)
+			}
+			seen[domain] = true
+		}
+	}
+	if len(out.VirtualHosts) != 2 || !seen["10.1.1.1:80"] {
+		t.Errorf("normalize() => got %+v, want both virtual hosts with the address in one", out.VirtualHosts)
+	}
+
+	// the input virtual hosts are not modified
+	if want := buildVirtualHost(kafka, port, suffix, nil); !reflect.DeepEqual(rc.VirtualHosts[0], want) {
+		t.Errorf("normalize() modified the virtual host %+v", rc.VirtualHosts[0])
+	}
+}
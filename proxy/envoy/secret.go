@@ -0,0 +1,344 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+)
+
+// The ingress secret endpoint supports long polling: a request carrying the
+// ETag of the previous response in the "If-None-Match" header and a "wait"
+// duration parameter blocks until the secret changes or the duration elapses,
+// in which case the response is "304 Not Modified". The proxy agent validates
+// the secret and swaps the files with a symbolic link rename, so that Envoy
+// never observes a partially written key pair.
+
+const (
+	ingressCertFilename = "tls.crt"
+	ingressKeyFilename  = "tls.key"
+
+	// ingressDataDir is the symbolic link to the current secret directory
+	ingressDataDir = "..data"
+
+	// SecretWaitParam is the query parameter of the long polling duration
+	SecretWaitParam = "wait"
+)
+
+var (
+	// secretPollInterval is the period of reading the secret during a long poll
+	secretPollInterval = time.Second
+
+	// maxSecretWait bounds the long polling duration
+	maxSecretWait = 5 * time.Minute
+)
+
+// SecretResponse is the TLS secret of an ingress proxy with the hosts of the
+// ingress rules terminating TLS with the secret
+type SecretResponse struct {
+	model.TLSSecret
+	Hosts []string `json:"hosts,omitempty"`
+}
+
+// ingressSecret returns the serialized secret referenced by the ingress
+// rules, or nil if TLS is not enabled
+func (ds *DiscoveryService) ingressSecret() ([]byte, error) {
+	_, secret := buildIngressRoutes(ds.Mesh, ds, ds)
+	if secret == "" {
+		return nil, nil
+	}
+
+	tls, err := ds.GetTLSSecret(secret)
+	if err != nil {
+		return nil, multierror.Prefix(err, "failed to read the secret")
+	}
+	if tls == nil {
+		return nil, fmt.Errorf("secret %q not found", secret)
+	}
+
+	return json.Marshal(SecretResponse{
+		TLSSecret: *tls,
+		Hosts:     ingressTLSHosts(ds, secret),
+	})
+}
+
+// secretETag is the strong entity tag of the serialized secret
+func secretETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// awaitSecretChange blocks until the entity tag of the secret differs from the
+// previous one, the wait duration elapses, or the request is canceled
+func (ds *DiscoveryService) awaitSecretChange(ctx context.Context, previous string, wait time.Duration) (
+	[]byte, error) {
+	if wait > maxSecretWait {
+		wait = maxSecretWait
+	}
+	deadline := time.After(wait)
+	ticker := time.NewTicker(secretPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-deadline:
+			return ds.ingressSecret()
+		case <-ticker.C:
+			data, err := ds.ingressSecret()
+			if err != nil || secretETag(data) != previous {
+				return data, err
+			}
+		}
+	}
+}
+
+// ListSecret responds with the TLS secret of an ingress proxy
+func (ds *DiscoveryService) ListSecret(request *restful.Request, response *restful.Response) {
+	// caching is disabled due to lack of secret watch notifications
+
+	cluster, node, role, err := ds.parseDiscoveryRequest(request)
+
+	if err != nil {
+		errorResponse(response, http.StatusNotFound, "ListSecrets "+err.Error())
+		return
+	}
+
+	glog.V(5).Infof("ListSecrets Discovery request to ListSecret for service_cluster %s, service_node %s, role %s",
+		cluster, node, role.Type)
+
+	if role.Type != proxy.Ingress {
+		writeResponse(response, nil)
+		return
+	}
+
+	var wait time.Duration
+	if param := request.QueryParameter(SecretWaitParam); param != "" {
+		if wait, err = time.ParseDuration(param); err != nil {
+			errorResponse(response, http.StatusBadRequest, "ListSecrets invalid wait duration "+param)
+			return
+		}
+	}
+
+	out, err := ds.ingressSecret()
+	previous := request.HeaderParameter("If-None-Match")
+	if err == nil && wait > 0 && previous == secretETag(out) {
+		out, err = ds.awaitSecretChange(request.Request.Context(), previous, wait)
+	}
+	if err != nil {
+		errorResponse(response, http.StatusNotFound, "ListSecrets "+err.Error())
+		return
+	}
+
+	etag := secretETag(out)
+	response.AddHeader("ETag", etag)
+	if previous == etag {
+		response.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeResponse(response, out)
+}
+
+// validateIngressSecret verifies that the private key matches the certificate,
+// that the certificate is valid at the time, and that it covers the hosts
+func validateIngressSecret(secret SecretResponse, now time.Time) (*x509.Certificate, error) {
+	pair, err := tls.X509KeyPair(secret.Certificate, secret.PrivateKey)
+	if err != nil {
+		return nil, multierror.Prefix(err, "invalid key pair:")
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, multierror.Prefix(err, "invalid certificate:")
+	}
+
+	if now.Before(leaf.NotBefore) {
+		return nil, fmt.Errorf("certificate is not valid before %v", leaf.NotBefore)
+	}
+	if now.After(leaf.NotAfter) {
+		return nil, fmt.Errorf("certificate expired at %v", leaf.NotAfter)
+	}
+
+	var errs error
+	for _, host := range secret.Hosts {
+		if host == "*" {
+			continue
+		}
+		if err = leaf.VerifyHostname(host); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return leaf, errs
+}
+
+// certificateExpiry reads the expiry of the leaf certificate in the PEM file
+func certificateExpiry(file string) (time.Time, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return time.Time{}, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return time.Time{}, fmt.Errorf("no PEM data in %s", file)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
+
+// writeIngressSecret writes the key pair to a new directory and atomically
+// replaces the symbolic link to the current directory. The certificate and
+// the key files are symbolic links through the current directory link.
+func writeIngressSecret(dir string, secret model.TLSSecret) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return multierror.Prefix(err, "cannot create parent directory")
+	}
+
+	data, err := ioutil.TempDir(dir, ingressDataDir+"_")
+	if err != nil {
+		return err
+	}
+	for file, content := range map[string][]byte{
+		ingressCertFilename: secret.Certificate,
+		ingressKeyFilename:  secret.PrivateKey,
+	} {
+		if err = ioutil.WriteFile(path.Join(data, file), content, 0600); err != nil {
+			os.RemoveAll(data) // nolint: errcheck
+			return multierror.Prefix(err, "failed to write "+file)
+		}
+	}
+	if err = os.Chmod(data, 0755); err != nil {
+		os.RemoveAll(data) // nolint: errcheck
+		return err
+	}
+
+	// rename over the current link is atomic unlike the link removal
+	link := path.Join(dir, ingressDataDir)
+	tmp := link + "_tmp"
+	if err = os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err = os.Symlink(path.Base(data), tmp); err != nil {
+		os.RemoveAll(data) // nolint: errcheck
+		return err
+	}
+	if err = os.Rename(tmp, link); err != nil {
+		os.RemoveAll(data) // nolint: errcheck
+		return err
+	}
+
+	for _, file := range []string{ingressCertFilename, ingressKeyFilename} {
+		target := path.Join(ingressDataDir, file)
+		if current, err := os.Readlink(path.Join(dir, file)); err == nil && current == target {
+			continue
+		}
+		// replace the regular files written by the previous agent versions
+		if err = os.Remove(path.Join(dir, file)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err = os.Symlink(target, path.Join(dir, file)); err != nil {
+			return err
+		}
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ingressDataDir+"_") && name != path.Base(data) && name != path.Base(tmp) {
+			if err = os.RemoveAll(path.Join(dir, name)); err != nil {
+				glog.Warningf("failed to remove the previous secret %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// fetchIngressSecret long polls discovery for the ingress secret. The secret
+// is written to the directory only if it passes the validation. The function
+// returns the entity tag of the secret that the directory holds.
+func fetchIngressSecret(ctx context.Context, client *http.Client, url, dir, etag string,
+	wait time.Duration) (string, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s?%s=%v", url, SecretWaitParam, wait), nil)
+	if err != nil {
+		return etag, multierror.Prefix(err, "failed to create a request to "+url)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return etag, multierror.Prefix(err, "failed to fetch "+url)
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return etag, multierror.Prefix(err, "failed to read request body")
+	}
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return etag, nil
+	case http.StatusOK:
+	default:
+		return etag, fmt.Errorf("failed to fetch %s: %d %s", url, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	next := resp.Header.Get("ETag")
+	if next == "" {
+		next = secretETag(data)
+	}
+	if len(data) == 0 {
+		glog.V(2).Info("Ingress secret is not set")
+		return next, nil
+	}
+
+	var secret SecretResponse
+	if err = json.Unmarshal(data, &secret); err != nil {
+		return etag, multierror.Prefix(err, "failed to unmarshal TLS secret")
+	}
+	leaf, err := validateIngressSecret(secret, time.Now())
+	if err != nil {
+		return etag, multierror.Prefix(err, "rejected the ingress secret:")
+	}
+	if err = writeIngressSecret(dir, secret.TLSSecret); err != nil {
+		return etag, err
+	}
+	glog.V(2).Infof("Wrote the ingress secret for %v expiring at %v", secret.Hosts, leaf.NotAfter)
+	return next, nil
+}
@@ -0,0 +1,195 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/pilot/model"
+)
+
+// makeTestSecret generates a self-signed certificate for the host
+func makeTestSecret(t *testing.T, host string, notBefore, notAfter time.Time) model.TLSSecret {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return model.TLSSecret{
+		Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}),
+		PrivateKey:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}),
+	}
+}
+
+func TestValidateIngressSecret(t *testing.T) {
+	now := time.Now()
+	valid := makeTestSecret(t, "world.com", now.Add(-time.Hour), now.Add(time.Hour))
+	other := makeTestSecret(t, "world.com", now.Add(-time.Hour), now.Add(time.Hour))
+	expired := makeTestSecret(t, "world.com", now.Add(-2*time.Hour), now.Add(-time.Hour))
+
+	cases := []struct {
+		name   string
+		secret SecretResponse
+		err    string
+	}{
+		{
+			name:   "valid",
+			secret: SecretResponse{TLSSecret: valid, Hosts: []string{"*", "world.com"}},
+		},
+		{
+			name:   "mismatched key",
+			secret: SecretResponse{TLSSecret: model.TLSSecret{Certificate: valid.Certificate, PrivateKey: other.PrivateKey}},
+			err:    "invalid key pair",
+		},
+		{
+			name:   "expired",
+			secret: SecretResponse{TLSSecret: expired},
+			err:    "expired",
+		},
+		{
+			name:   "host",
+			secret: SecretResponse{TLSSecret: valid, Hosts: []string{"hello.com"}},
+			err:    "hello.com",
+		},
+	}
+
+	for _, c := range cases {
+		_, err := validateIngressSecret(c.secret, now)
+		switch {
+		case c.err == "" && err != nil:
+			t.Errorf("%s: validateIngressSecret() => unexpected error %v", c.name, err)
+		case c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)):
+			t.Errorf("%s: validateIngressSecret() => got %v, want an error containing %q", c.name, err, c.err)
+		}
+	}
+}
+
+func TestFetchIngressSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ingress-certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	now := time.Now()
+	secrets := make(chan model.TLSSecret, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get(SecretWaitParam) != "1s" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		var data []byte
+		select {
+		case secret := <-secrets:
+			data, _ = json.Marshal(SecretResponse{TLSSecret: secret, Hosts: []string{"world.com"}})
+		default:
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", secretETag(data))
+		w.Write(data) // nolint: errcheck
+	}))
+	defer server.Close()
+
+	fetch := func(etag string) string {
+		next, err := fetchIngressSecret(context.Background(), &http.Client{}, server.URL, dir, etag, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return next
+	}
+
+	first := makeTestSecret(t, "world.com", now.Add(-time.Hour), now.Add(time.Hour))
+	secrets <- first
+	etag := fetch("")
+	if etag == "" {
+		t.Error("fetchIngressSecret() => got an empty ETag")
+	}
+	if next := fetch(etag); next != etag {
+		t.Errorf("fetchIngressSecret() => got ETag %q for a not modified secret, want %q", next, etag)
+	}
+
+	second := makeTestSecret(t, "world.com", now.Add(-time.Hour), now.Add(2*time.Hour))
+	secrets <- second
+	if next := fetch(etag); next == etag {
+		t.Error("fetchIngressSecret() => ETag did not change")
+	}
+	cert, err := ioutil.ReadFile(path.Join(dir, ingressCertFilename))
+	if err != nil || string(cert) != string(second.Certificate) {
+		t.Errorf("fetchIngressSecret() => got certificate %q, %v", cert, err)
+	}
+	info, err := os.Stat(path.Join(dir, ingressKeyFilename))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("fetchIngressSecret() => got key file %v, %v", info, err)
+	}
+	if expiry, err := certificateExpiry(path.Join(dir, ingressCertFilename)); err != nil ||
+		expiry.Unix() != now.Add(2*time.Hour).Unix() {
+		t.Errorf("certificateExpiry() => got %v, %v", expiry, err)
+	}
+
+	// the previous secret directory is removed
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := 0
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ingressDataDir+"_") {
+			versions++
+		}
+	}
+	if versions != 1 {
+		t.Errorf("fetchIngressSecret() => got directory entries %v", entries)
+	}
+
+	// an invalid secret does not replace the current one
+	secrets <- makeTestSecret(t, "hello.com", now.Add(-time.Hour), now.Add(time.Hour))
+	if _, err = fetchIngressSecret(context.Background(), &http.Client{}, server.URL, dir, etag, time.Second); err == nil {
+		t.Error("fetchIngressSecret() => expected an error for a host mismatch")
+	}
+	if cert, err = ioutil.ReadFile(path.Join(dir, ingressCertFilename)); err != nil ||
+		string(cert) != string(second.Certificate) {
+		t.Errorf("fetchIngressSecret() => got certificate %q, %v", cert, err)
+	}
+}
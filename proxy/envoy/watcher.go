@@ -17,13 +17,10 @@ package envoy
 import (
 	"context"
 	"crypto/sha256"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"os"
-	"os/exec"
 	"path"
 	"time"
 
@@ -31,27 +28,42 @@ import (
 	multierror "github.com/hashicorp/go-multierror"
 
 	proxyconfig "istio.io/api/proxy/v1/config"
-	"istio.io/pilot/model"
 	"istio.io/pilot/proxy"
 )
 
 // Watcher triggers reloads on changes to the proxy config
 type Watcher interface {
-	// Run the watcher loop (blocking call)
+	// Run the watcher loop (blocking call). The call returns once the agent
+	// terminates the proxy after the context is canceled.
 	Run(context.Context)
 
 	// Reload the agent with the latest configuration
 	Reload()
+
+	// Status returns the state of the proxy agent
+	Status() proxy.AgentStatus
 }
 
 type watcher struct {
 	agent proxy.Agent
 	role  proxy.Node
 	mesh  *proxyconfig.ProxyMeshConfig
+
+	// ingressSecretETag is the entity tag of the ingress secret on disk
+	ingressSecretETag string
 }
 
-// NewWatcher creates a new watcher instance with an agent
-func NewWatcher(mesh *proxyconfig.ProxyMeshConfig, role proxy.Node, configpath string) (Watcher, error) {
+// ingressSecretWait is the long polling duration of the ingress secret requests
+var ingressSecretWait = 30 * time.Second
+
+// NewWatcher creates a new watcher instance with an agent that launches the
+// proxy epochs with the backend. The process exits once the agent exhausts its
+// retry budget if exitOnPanic is set, otherwise the agent keeps running and
+// reports the exhausted budget in its status, e.g. to fail the health checks.
+// On termination, the agent fails its readiness and drains the proxy after the
+// endpoint removal delay.
+func NewWatcher(mesh *proxyconfig.ProxyMeshConfig, role proxy.Node, configpath string,
+	backend Backend, exitOnPanic bool, endpointRemovalDelay time.Duration) (Watcher, error) {
 	glog.V(2).Infof("Proxy role: %#v", role)
 
 	if mesh.StatsdUdpAddress != "" {
@@ -74,7 +86,18 @@ func NewWatcher(mesh *proxyconfig.ProxyMeshConfig, role proxy.Node, configpath s
 		return nil, errors.New("ingress proxy is disabled")
 	}
 
-	agent := proxy.NewAgent(runEnvoy(mesh, role.ServiceNode(), configpath), proxy.DefaultRetry)
+	// the dry-run epochs have no admin port to drain, so the agent terminates them immediately
+	drain := drainEnvoy(mesh, endpointRemovalDelay)
+	if _, dryRun := backend.(*DryRunBackend); dryRun {
+		drain = proxy.Drain{}
+	}
+	envoyProxy := runEnvoy(mesh, role.ServiceNode(), configpath, backend)
+	if exitOnPanic {
+		envoyProxy.Panic = func(_ interface{}) {
+			glog.Fatal("cannot start the proxy with the desired configuration")
+		}
+	}
+	agent := proxy.NewGracefulAgent(envoyProxy, proxy.DefaultRetry, drain)
 	out := &watcher{
 		agent: agent,
 		role:  role,
@@ -86,7 +109,11 @@ func NewWatcher(mesh *proxyconfig.ProxyMeshConfig, role proxy.Node, configpath s
 
 func (w *watcher) Run(ctx context.Context) {
 	// agent consumes notifications from the controllerr
-	go w.agent.Run(ctx)
+	done := make(chan struct{})
+	go func() {
+		w.agent.Run(ctx)
+		close(done)
+	}()
 
 	// kickstart the proxy with partial state (in case there are no notifications coming)
 	w.Reload()
@@ -100,7 +127,7 @@ func (w *watcher) Run(ctx context.Context) {
 	if w.role.Type == proxy.Ingress {
 		go watchCerts(ctx, proxy.IngressCertsPath, w.Reload)
 
-		// update secrets with polling
+		// update secrets with long polling
 		go func() {
 			for {
 				err := w.UpdateIngressSecret(ctx)
@@ -119,6 +146,9 @@ func (w *watcher) Run(ctx context.Context) {
 	}
 
 	<-ctx.Done()
+
+	// await the proxy shutdown
+	<-done
 }
 
 func (w *watcher) Reload() {
@@ -129,68 +159,34 @@ func (w *watcher) Reload() {
 		generateCertHash(h, w.mesh.AuthCertsPath, authFiles)
 	}
 	if w.role.Type == proxy.Ingress {
-		generateCertHash(h, proxy.IngressCertsPath, []string{"tls.crt", "tls.key"})
+		generateCertHash(h, proxy.IngressCertsPath, []string{ingressCertFilename, ingressKeyFilename})
 	}
 	config.Hash = h.Sum(nil)
 
 	w.agent.ScheduleConfigUpdate(config)
 }
 
-// UpdateIngressSecret fetches the TLS secret from discovery and secret storage
-// and writes to well-known location
+func (w *watcher) Status() proxy.AgentStatus {
+	return w.agent.Status()
+}
+
+// UpdateIngressSecret long polls discovery for the TLS secret and writes the
+// validated key pair to the well-known location
 func (w *watcher) UpdateIngressSecret(ctx context.Context) error {
-	client := &http.Client{Timeout: convertDuration(w.mesh.ConnectTimeout)}
+	// the request outlasts the long polling duration
+	client := &http.Client{Timeout: convertDuration(w.mesh.ConnectTimeout) + ingressSecretWait}
 	url := fmt.Sprintf("http://%s/v1alpha/secret/%s/%s",
 		w.mesh.DiscoveryAddress, w.mesh.IstioServiceCluster, w.role.ServiceNode())
-	req, err := http.NewRequest("GET", url, nil)
-
-	if err != nil {
-		return multierror.Prefix(err, "failed to create a request to "+url)
-	}
-
-	resp, err := client.Do(req.WithContext(ctx))
-	if err != nil {
-		return multierror.Prefix(err, "failed to fetch "+url)
-	}
-
-	tlsData, err := ioutil.ReadAll(resp.Body)
-	defer resp.Body.Close() // nolint: errcheck
-	if err != nil {
-		return multierror.Prefix(err, "failed to read request body")
-	}
-	if len(tlsData) == 0 {
-		glog.Errorf("failed to get TLS data, zero data")
-		return nil
-	}
-
-	var tls model.TLSSecret
-	if err = json.Unmarshal(tlsData, &tls); err != nil {
-		glog.Errorf("failed to unmarshal TLS secret")
-		return err
-	}
-
-	if _, err := os.Stat(proxy.IngressCertsPath); os.IsNotExist(err) {
-		err = os.Mkdir(proxy.IngressCertsPath, 0755)
-		if err != nil {
-			return multierror.Prefix(err, "cannot create parent directory")
-		}
-	}
-
-	if err := ioutil.WriteFile(path.Join(proxy.IngressCertsPath, "tls.crt"), tls.Certificate, 0755); err != nil {
-		return multierror.Prefix(err, "failed to write cert file")
-	}
-	if err := ioutil.WriteFile(path.Join(proxy.IngressCertsPath, "tls.key"), tls.PrivateKey, 0755); err != nil {
-		return multierror.Prefix(err, "failed to write key file")
-	}
-
-	return nil
+	etag, err := fetchIngressSecret(ctx, client, url, proxy.IngressCertsPath, w.ingressSecretETag, ingressSecretWait)
+	w.ingressSecretETag = etag
+	return err
 }
 
 const (
 	// EpochFileTemplate is a template for the root config JSON
 	EpochFileTemplate = "envoy-rev%d.json"
 
-	// BinaryPath is the path to envoy binary
+	// BinaryPath is the default path to envoy binary
 	BinaryPath = "/usr/local/bin/envoy"
 )
 
@@ -208,7 +204,7 @@ func envoyArgs(fname string, epoch int, mesh *proxyconfig.ProxyMeshConfig, node
 	}
 }
 
-func runEnvoy(mesh *proxyconfig.ProxyMeshConfig, node, configpath string) proxy.Proxy {
+func runEnvoy(mesh *proxyconfig.ProxyMeshConfig, node, configpath string, backend Backend) proxy.Proxy {
 	return proxy.Proxy{
 		Run: func(config interface{}, epoch int, abort <-chan error) error {
 			envoyConfig, ok := config.(*Config)
@@ -222,41 +218,12 @@ func runEnvoy(mesh *proxyconfig.ProxyMeshConfig, node, configpath string) proxy.
 				return err
 			}
 
-			// spin up a new Envoy process
-			args := envoyArgs(fname, epoch, mesh, node)
-
-			// inject tracing flag for higher levels
-			if glog.V(4) {
-				args = append(args, "-l", "trace")
-			} else if glog.V(3) {
-				args = append(args, "-l", "debug")
-			}
-
-			glog.V(2).Infof("Envoy command: %v", args)
-
-			/* #nosec */
-			cmd := exec.Command(BinaryPath, args...)
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			if err := cmd.Start(); err != nil {
-				return err
-			}
-
-			done := make(chan error, 1)
-			go func() {
-				done <- cmd.Wait()
-			}()
-
-			select {
-			case err := <-abort:
-				glog.Warningf("Aborting epoch %d", epoch)
-				if errKill := cmd.Process.Kill(); errKill != nil {
-					glog.Warningf("killing epoch %d caused an error %v", epoch, errKill)
-				}
-				return err
-			case err := <-done:
-				return err
-			}
+			return backend.Run(BackendArgs{
+				ConfigFile:  fname,
+				Epoch:       epoch,
+				ServiceNode: node,
+				Mesh:        mesh,
+			}, abort)
 		},
 		Cleanup: func(epoch int) {
 			path := configFile(configpath, epoch)
@@ -264,8 +231,27 @@ func runEnvoy(mesh *proxyconfig.ProxyMeshConfig, node, configpath string) proxy.
 				glog.Warningf("Failed to delete config file %s for %d, %v", path, epoch, err)
 			}
 		},
-		Panic: func(_ interface{}) {
-			glog.Fatal("cannot start the proxy with the desired configuration")
+	}
+}
+
+// drainEnvoy fails the Envoy health checks through the admin API. Envoy then
+// drains the listeners by closing the downstream connections as they complete.
+func drainEnvoy(mesh *proxyconfig.ProxyMeshConfig, endpointRemovalDelay time.Duration) proxy.Drain {
+	return proxy.Drain{
+		Command: func(epoch int) error {
+			url := fmt.Sprintf("http://%s:%d/healthcheck/fail", LocalhostAddress, mesh.ProxyAdminPort)
+			client := &http.Client{Timeout: time.Second}
+			resp, err := client.Post(url, "text/plain", nil)
+			if err != nil {
+				return multierror.Prefix(err, "failed to drain epoch "+fmt.Sprint(epoch))
+			}
+			defer resp.Body.Close() // nolint: errcheck
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("admin port responded with %d to the drain of epoch %d", resp.StatusCode, epoch)
+			}
+			return nil
 		},
+		EndpointRemovalDelay: endpointRemovalDelay,
+		DrainDuration:        convertDuration(mesh.DrainDuration),
 	}
 }
@@ -17,6 +17,7 @@ package mock
 import (
 	"fmt"
 	"net"
+	"strings"
 
 	"istio.io/pilot/model"
 	"istio.io/pilot/proxy"
@@ -72,8 +73,9 @@ var (
 // MakeService creates a mock service
 func MakeService(hostname, address string) *model.Service {
 	return &model.Service{
-		Hostname: hostname,
-		Address:  address,
+		Hostname:  hostname,
+		Address:   address,
+		Namespace: hostnameNamespace(hostname),
 		Ports: []*model.Port{{
 			Name:     "http",
 			Port:     80, // target port 80
@@ -90,12 +92,23 @@ func MakeService(hostname, address string) *model.Service {
 	}
 }
 
+// hostnameNamespace returns the namespace of the mock services, which follow
+// the Kubernetes DNS naming convention
+func hostnameNamespace(hostname string) string {
+	parts := strings.Split(hostname, ".")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
 // MakeExternalHTTPService creates mock external service
 func MakeExternalHTTPService(hostname, external string, address string) *model.Service {
 	return &model.Service{
 		Hostname:     hostname,
 		Address:      address,
 		ExternalName: external,
+		Namespace:    hostnameNamespace(hostname),
 		Ports: []*model.Port{{
 			Name:     "http",
 			Port:     80,
@@ -110,6 +123,7 @@ func MakeExternalHTTPSService(hostname, external string, address string) *model.
 		Hostname:     hostname,
 		Address:      address,
 		ExternalName: external,
+		Namespace:    hostnameNamespace(hostname),
 		Ports: []*model.Port{{
 			Name:     "https",
 			Port:     443,
@@ -159,6 +173,12 @@ type ServiceDiscovery struct {
 	versions int
 }
 
+// NewDiscovery creates a mock discovery interface for the services with the
+// number of instance versions
+func NewDiscovery(services map[string]*model.Service, versions int) *ServiceDiscovery {
+	return &ServiceDiscovery{services: services, versions: versions}
+}
+
 // Services implements discovery interface
 func (sd *ServiceDiscovery) Services() []*model.Service {
 	out := make([]*model.Service, 0, len(sd.services))